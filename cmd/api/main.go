@@ -1,15 +1,19 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net/http"
+	"time"
 
 	"os"
 
 	"money-splitter/pkg/db"
 	"money-splitter/pkg/handlers"
 	"money-splitter/pkg/middleware"
+	"money-splitter/pkg/rates"
+	"money-splitter/pkg/storage"
 
 	"github.com/joho/godotenv"
 )
@@ -21,6 +25,14 @@ func main() {
 	}
 	db.Connect()
 	db.Migrate()
+	handlers.RateProvider = rates.NewDBProvider(db.DB)
+	handlers.Storage, err = storage.FromEnv()
+	if err != nil {
+		log.Fatalf("Storage setup failed: %v", err)
+	}
+	go handlers.StartRecurringScheduler(context.Background(), time.Minute)
+	go handlers.StartTrashPurger(context.Background(), time.Hour)
+	go middleware.StartIdempotencyPurger(context.Background(), time.Hour)
 
 	mux := http.NewServeMux()
 
@@ -35,9 +47,10 @@ func main() {
 
 	mux.HandleFunc("POST /register", handlers.RegisterUser)
 	mux.HandleFunc("POST /login", handlers.LoginUser)
-	mux.HandleFunc("POST /groups", middleware.AuthMiddleware(handlers.CreateGroup))
-	mux.HandleFunc("POST /groups/{id}/members", middleware.AuthMiddleware(handlers.AddMember))
-	mux.HandleFunc("POST /groups/{id}/expenses", middleware.AuthMiddleware(handlers.CreateExpense))
+	mux.HandleFunc("POST /groups", middleware.AuthMiddleware(middleware.Idempotency(handlers.CreateGroup)))
+	mux.HandleFunc("POST /groups/{id}/members", middleware.AuthMiddleware(middleware.Idempotency(handlers.AddMember)))
+	mux.HandleFunc("POST /groups/{id}/expenses", middleware.AuthMiddleware(middleware.Idempotency(handlers.CreateExpense)))
+	mux.HandleFunc("POST /groups/{id}/expenses:batch", middleware.AuthMiddleware(handlers.CreateExpensesBatch))
 	mux.HandleFunc("GET /groups/{id}/balance", middleware.AuthMiddleware(handlers.GetGroupBalance))
 	mux.HandleFunc("GET /groups", middleware.AuthMiddleware(handlers.GetGroups))
 	mux.HandleFunc("GET /groups/{id}/expenses", middleware.AuthMiddleware(handlers.GetGroupExpenses))
@@ -45,10 +58,62 @@ func main() {
 	mux.HandleFunc("DELETE /expenses/{id}", middleware.AuthMiddleware(handlers.DeleteExpense))
 	mux.HandleFunc("GET /expenses/{id}", middleware.AuthMiddleware(handlers.GetExpenseDetails))
 	mux.HandleFunc("GET /me", middleware.AuthMiddleware(handlers.GetCurrentUser))
-	mux.HandleFunc("PUT /expenses/{id}", middleware.AuthMiddleware(handlers.UpdateExpense))
+	mux.HandleFunc("PUT /expenses/{id}", middleware.AuthMiddleware(middleware.Idempotency(handlers.UpdateExpense)))
+	mux.HandleFunc("PATCH /expenses/{id}", middleware.AuthMiddleware(middleware.Idempotency(handlers.PatchExpense)))
 	mux.HandleFunc("GET /groups/{id}/export", middleware.AuthMiddleware(handlers.ExportGroupPDF))
 	mux.HandleFunc("DELETE /groups/{id}", handlers.DeleteGroup)
 	mux.HandleFunc("GET /groups/{id}/name",middleware.AuthMiddleware(handlers.GroupName))
+	mux.HandleFunc("GET /expenses/{id}/claims", middleware.AuthMiddleware(handlers.GetExpenseClaims))
+	mux.HandleFunc("POST /expenses/{id}/items/{itemId}/claim", middleware.AuthMiddleware(handlers.ClaimExpenseItem))
+	mux.HandleFunc("DELETE /expenses/{id}/items/{itemId}/claim", middleware.AuthMiddleware(handlers.UnclaimExpenseItem))
+	mux.HandleFunc("POST /expenses/{id}/finalize", middleware.AuthMiddleware(handlers.FinalizeExpenseClaims))
+	mux.HandleFunc("POST /groups/{id}/recurring", middleware.AuthMiddleware(handlers.CreateRecurringExpense))
+	mux.HandleFunc("GET /groups/{id}/recurring", middleware.AuthMiddleware(handlers.GetRecurringExpenses))
+	mux.HandleFunc("PUT /recurring/{id}", middleware.AuthMiddleware(handlers.UpdateRecurringExpense))
+	mux.HandleFunc("POST /recurring/{id}/pause", middleware.AuthMiddleware(handlers.PauseRecurringExpense))
+	mux.HandleFunc("POST /recurring/{id}/resume", middleware.AuthMiddleware(handlers.ResumeRecurringExpense))
+	mux.HandleFunc("POST /recurring/{id}/skip", middleware.AuthMiddleware(handlers.SkipRecurringOccurrence))
+	mux.HandleFunc("DELETE /recurring/{id}", middleware.AuthMiddleware(handlers.DeleteRecurringExpense))
+	mux.HandleFunc("POST /expenses/{id}/attachments", middleware.AuthMiddleware(handlers.UploadAttachment))
+	mux.HandleFunc("GET /expenses/{id}/attachments", middleware.AuthMiddleware(handlers.GetExpenseAttachments))
+	mux.HandleFunc("DELETE /attachments/{id}", middleware.AuthMiddleware(handlers.DeleteAttachment))
+	mux.HandleFunc("GET /attachments/{id}/download", handlers.DownloadAttachment) // Signed URL, no bearer token
+	mux.HandleFunc("GET /expenses/{id}/comments", middleware.AuthMiddleware(handlers.GetExpenseComments))
+	mux.HandleFunc("POST /expenses/{id}/comments", middleware.AuthMiddleware(handlers.CreateComment))
+	mux.HandleFunc("PUT /comments/{id}", middleware.AuthMiddleware(handlers.UpdateComment))
+	mux.HandleFunc("DELETE /comments/{id}", middleware.AuthMiddleware(handlers.DeleteComment))
+	mux.HandleFunc("GET /expenses/{id}/history", middleware.AuthMiddleware(handlers.GetExpenseHistory))
+	mux.HandleFunc("POST /expenses/{id}/revert", middleware.AuthMiddleware(handlers.RevertExpense))
+	mux.HandleFunc("GET /groups/{id}/trash", middleware.AuthMiddleware(handlers.GetGroupTrash))
+	mux.HandleFunc("POST /expenses/{id}/restore", middleware.AuthMiddleware(handlers.RestoreExpense))
+	mux.HandleFunc("GET /groups/{id}/categories", middleware.AuthMiddleware(handlers.GetGroupCategories))
+	mux.HandleFunc("POST /groups/{id}/categories", middleware.AuthMiddleware(handlers.CreateCategory))
+	mux.HandleFunc("PUT /categories/{id}", middleware.AuthMiddleware(handlers.UpdateCategory))
+	mux.HandleFunc("POST /categories/{id}/merge", middleware.AuthMiddleware(handlers.MergeCategory))
+	mux.HandleFunc("GET /search", middleware.AuthMiddleware(handlers.Search))
+	mux.HandleFunc("POST /expenses/{id}/refunds", middleware.AuthMiddleware(middleware.Idempotency(handlers.CreateRefund)))
+	mux.HandleFunc("PUT /groups/{id}/settings", middleware.AuthMiddleware(handlers.UpdateGroupSettings))
+	mux.HandleFunc("POST /expenses/{id}/accept", middleware.AuthMiddleware(handlers.AcceptExpenseShare))
+	mux.HandleFunc("POST /expenses/{id}/dispute", middleware.AuthMiddleware(handlers.DisputeExpenseShare))
+	mux.HandleFunc("GET /notifications", middleware.AuthMiddleware(handlers.GetNotifications))
+	mux.HandleFunc("POST /notifications/{id}/read", middleware.AuthMiddleware(handlers.MarkNotificationRead))
+	mux.HandleFunc("GET /groups/{id}/duplicates", middleware.AuthMiddleware(handlers.GetGroupDuplicates))
+	mux.HandleFunc("POST /groups/{id}/templates", middleware.AuthMiddleware(handlers.CreateExpenseTemplate))
+	mux.HandleFunc("GET /groups/{id}/templates", middleware.AuthMiddleware(handlers.GetExpenseTemplates))
+	mux.HandleFunc("PUT /templates/{id}", middleware.AuthMiddleware(handlers.UpdateExpenseTemplate))
+	mux.HandleFunc("DELETE /templates/{id}", middleware.AuthMiddleware(handlers.DeleteExpenseTemplate))
+	mux.HandleFunc("POST /templates/{id}/expenses", middleware.AuthMiddleware(middleware.Idempotency(handlers.CreateExpenseFromTemplate)))
+	mux.HandleFunc("POST /groups/{id}/expenses:parse", middleware.AuthMiddleware(middleware.Idempotency(handlers.ParseExpense)))
+	mux.HandleFunc("GET /groups/{id}/category-rules", middleware.AuthMiddleware(handlers.GetCategoryRules))
+	mux.HandleFunc("POST /groups/{id}/category-rules", middleware.AuthMiddleware(handlers.CreateCategoryRule))
+	mux.HandleFunc("DELETE /category-rules/{id}", middleware.AuthMiddleware(handlers.DeleteCategoryRule))
+	mux.HandleFunc("POST /groups/{id}/categorize", middleware.AuthMiddleware(handlers.TestCategorize))
+	mux.HandleFunc("POST /groups/{id}/settlements", middleware.AuthMiddleware(middleware.Idempotency(handlers.CreateSettlement)))
+	mux.HandleFunc("GET /groups/{id}/settlements", middleware.AuthMiddleware(handlers.GetSettlements))
+	mux.HandleFunc("DELETE /settlements/{id}", middleware.AuthMiddleware(handlers.DeleteSettlement))
+	mux.HandleFunc("GET /groups/{id}/timeline", middleware.AuthMiddleware(handlers.GetGroupTimeline))
+	mux.HandleFunc("GET /rates", middleware.AuthMiddleware(handlers.GetExchangeRate))
+	mux.HandleFunc("POST /rates", middleware.AuthMiddleware(handlers.SetExchangeRate))
 	mux.HandleFunc("GET /", func(w http.ResponseWriter, r *http.Request) {
         w.WriteHeader(http.StatusOK)
         w.Write([]byte("Server is up and running!"))
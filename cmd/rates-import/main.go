@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"money-splitter/pkg/db"
+	"money-splitter/pkg/rates"
+
+	"github.com/joho/godotenv"
+)
+
+// Imports ECB reference rate files (CSV, XML or the zipped downloads) into exchange_rates.
+// Usage: go run ./cmd/rates-import eurofxref-hist.zip [more files...]
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Println("usage: rates-import <file.csv|file.xml|file.zip>...")
+		os.Exit(1)
+	}
+
+	if err := godotenv.Load(); err != nil {
+		log.Println("No .env file found")
+	}
+	db.Connect()
+	db.Migrate()
+
+	provider := rates.NewDBProvider(db.DB)
+	for _, path := range os.Args[1:] {
+		count, err := rates.ImportFile(provider, path)
+		if err != nil {
+			log.Fatalf("Import failed: %v", err)
+		}
+		fmt.Printf("Imported %d rates from %s\n", count, path)
+	}
+}
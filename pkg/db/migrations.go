@@ -55,6 +55,406 @@ func Migrate() {
         user_id INT REFERENCES users(id),
         amount_owed DECIMAL(10, 2) NOT NULL
     );
+
+    -- MULTI-CURRENCY: Each group settles in one base currency.
+    -- Expenses keep their original currency plus the rate used to convert
+    -- into the group currency (1 unit of expense currency = rate units of group currency).
+    ALTER TABLE groups ADD COLUMN IF NOT EXISTS currency VARCHAR(3) NOT NULL DEFAULT 'USD';
+    ALTER TABLE expenses ADD COLUMN IF NOT EXISTS currency VARCHAR(3);
+    ALTER TABLE expenses ADD COLUMN IF NOT EXISTS exchange_rate DECIMAL(18, 8) NOT NULL DEFAULT 1;
+    UPDATE expenses e SET currency = g.currency FROM groups g WHERE e.group_id = g.id AND e.currency IS NULL;
+
+    -- EXCHANGE RATES: Imported reference rates (e.g. ECB files) plus manual overrides.
+    -- 1 unit of base_currency = rate units of quote_currency on rate_date.
+    CREATE TABLE IF NOT EXISTS exchange_rates (
+        rate_date DATE NOT NULL,
+        base_currency VARCHAR(3) NOT NULL,
+        quote_currency VARCHAR(3) NOT NULL,
+        rate DECIMAL(18, 8) NOT NULL,
+        source VARCHAR(20) NOT NULL DEFAULT 'manual',
+        PRIMARY KEY (rate_date, base_currency, quote_currency, source)
+    );
+    CREATE INDEX IF NOT EXISTS idx_exchange_rates_pair ON exchange_rates (base_currency, quote_currency, rate_date DESC);
+
+    -- ITEMIZED RECEIPTS: Line items and who shared them.
+    -- Tax/tip/service charge are spread proportionally when generating expense_splits.
+    ALTER TABLE expenses ADD COLUMN IF NOT EXISTS tax DECIMAL(10, 2) NOT NULL DEFAULT 0;
+    ALTER TABLE expenses ADD COLUMN IF NOT EXISTS tip DECIMAL(10, 2) NOT NULL DEFAULT 0;
+    ALTER TABLE expenses ADD COLUMN IF NOT EXISTS service_charge DECIMAL(10, 2) NOT NULL DEFAULT 0;
+
+    CREATE TABLE IF NOT EXISTS expense_items (
+        id SERIAL PRIMARY KEY,
+        expense_id INT REFERENCES expenses(id) ON DELETE CASCADE,
+        name VARCHAR(100) NOT NULL,
+        price DECIMAL(10, 2) NOT NULL,
+        position INT NOT NULL DEFAULT 0
+    );
+
+    CREATE TABLE IF NOT EXISTS expense_item_shares (
+        item_id INT REFERENCES expense_items(id) ON DELETE CASCADE,
+        user_id INT REFERENCES users(id),
+        PRIMARY KEY (item_id, user_id)
+    );
+
+    -- CLAIMING: Drafts ('claiming') are excluded from balances until finalized ('final')
+    ALTER TABLE expenses ADD COLUMN IF NOT EXISTS status VARCHAR(20) NOT NULL DEFAULT 'final';
+
+    -- RECURRING EXPENSES: A CreateExpenseRequest template plus an RRULE-like schedule
+    CREATE TABLE IF NOT EXISTS recurring_expenses (
+        id SERIAL PRIMARY KEY,
+        group_id INT REFERENCES groups(id) ON DELETE CASCADE,
+        created_by INT REFERENCES users(id),
+        template JSONB NOT NULL,
+        frequency VARCHAR(10) NOT NULL,       -- daily | weekly | monthly
+        interval_count INT NOT NULL DEFAULT 1,
+        month_day INT NOT NULL DEFAULT 0,     -- monthly: 0 means the start date's day
+        weekday INT,                          -- weekly: 0 = Sunday, NULL means the start date's weekday
+        start_date DATE NOT NULL,
+        end_date DATE,
+        next_run DATE,                        -- NULL once the schedule has ended
+        paused BOOLEAN NOT NULL DEFAULT FALSE,
+        created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+    );
+    CREATE INDEX IF NOT EXISTS idx_recurring_due ON recurring_expenses (next_run) WHERE paused = FALSE;
+
+    -- One row per materialized or skipped date; the primary key is what prevents duplicates
+    CREATE TABLE IF NOT EXISTS recurring_occurrences (
+        recurring_id INT REFERENCES recurring_expenses(id) ON DELETE CASCADE,
+        occurrence_date DATE NOT NULL,
+        expense_id INT REFERENCES expenses(id) ON DELETE SET NULL,
+        skipped BOOLEAN NOT NULL DEFAULT FALSE,
+        PRIMARY KEY (recurring_id, occurrence_date)
+    );
+
+    -- ATTACHMENTS: Receipt photos/PDFs live in blob storage, this is the index
+    CREATE TABLE IF NOT EXISTS expense_attachments (
+        id SERIAL PRIMARY KEY,
+        expense_id INT REFERENCES expenses(id) ON DELETE CASCADE,
+        uploaded_by INT REFERENCES users(id),
+        file_name VARCHAR(255) NOT NULL,
+        content_type VARCHAR(100) NOT NULL,
+        size_bytes BIGINT NOT NULL,
+        storage_key VARCHAR(255) NOT NULL,
+        thumbnail_key VARCHAR(255),
+        created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+    );
+    CREATE INDEX IF NOT EXISTS idx_attachments_expense ON expense_attachments (expense_id);
+
+    -- COMMENTS: Discussion threads on expenses, with resolved @mentions
+    CREATE TABLE IF NOT EXISTS expense_comments (
+        id SERIAL PRIMARY KEY,
+        expense_id INT REFERENCES expenses(id) ON DELETE CASCADE,
+        author_id INT REFERENCES users(id),
+        body TEXT NOT NULL,
+        created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+        updated_at TIMESTAMP
+    );
+    CREATE INDEX IF NOT EXISTS idx_comments_expense ON expense_comments (expense_id, created_at);
+
+    CREATE TABLE IF NOT EXISTS comment_mentions (
+        comment_id INT REFERENCES expense_comments(id) ON DELETE CASCADE,
+        user_id INT REFERENCES users(id) ON DELETE CASCADE,
+        PRIMARY KEY (comment_id, user_id)
+    );
+
+    -- REVISIONS: Full snapshot + field-level diff for every change to an expense.
+    -- No FK on expense_id so the history survives the expense being deleted.
+    ALTER TABLE expenses ADD COLUMN IF NOT EXISTS created_by INT REFERENCES users(id);
+
+    CREATE TABLE IF NOT EXISTS expense_revisions (
+        id SERIAL PRIMARY KEY,
+        expense_id INT NOT NULL,
+        group_id INT REFERENCES groups(id) ON DELETE CASCADE,
+        revision INT NOT NULL,
+        action VARCHAR(20) NOT NULL,          -- create | update | delete | revert | finalize | restore | recategorize
+        changed_by INT REFERENCES users(id),
+        changed_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+        snapshot JSONB NOT NULL,
+        diff JSONB NOT NULL DEFAULT '{}',
+        UNIQUE (expense_id, revision)
+    );
+
+    -- TRASH: Deleted expenses are only hidden; they are purged after the retention period.
+    ALTER TABLE expenses ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP;
+    ALTER TABLE expenses ADD COLUMN IF NOT EXISTS deleted_by INT REFERENCES users(id);
+    CREATE INDEX IF NOT EXISTS idx_expenses_trash ON expenses (group_id, deleted_at) WHERE deleted_at IS NOT NULL;
+
+    -- EXPENSE DATE: When the expense happened (created_at stays as the audit timestamp).
+    -- Time and timezone are optional; old rows take the day they were logged.
+    ALTER TABLE expenses ADD COLUMN IF NOT EXISTS expense_date DATE;
+    ALTER TABLE expenses ADD COLUMN IF NOT EXISTS expense_time TIME;
+    ALTER TABLE expenses ADD COLUMN IF NOT EXISTS expense_timezone VARCHAR(64) NOT NULL DEFAULT '';
+    UPDATE expenses SET expense_date = created_at::date WHERE expense_date IS NULL;
+    ALTER TABLE expenses ALTER COLUMN expense_date SET DEFAULT CURRENT_DATE;
+    ALTER TABLE expenses ALTER COLUMN expense_date SET NOT NULL;
+    CREATE INDEX IF NOT EXISTS idx_expenses_group_date ON expenses (group_id, expense_date);
+
+    -- CATEGORIES: A global default set (group_id NULL) plus per-group custom ones.
+    -- Expenses keep the category name; rename/merge rewrite it.
+    CREATE TABLE IF NOT EXISTS categories (
+        id SERIAL PRIMARY KEY,
+        group_id INT REFERENCES groups(id) ON DELETE CASCADE,
+        name VARCHAR(50) NOT NULL,
+        icon VARCHAR(32) NOT NULL DEFAULT '',
+        color VARCHAR(7) NOT NULL DEFAULT '',
+        parent_id INT REFERENCES categories(id) ON DELETE SET NULL,
+        created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+    );
+    CREATE UNIQUE INDEX IF NOT EXISTS idx_categories_name ON categories ((COALESCE(group_id, 0)), LOWER(name));
+
+    INSERT INTO categories (group_id, name, icon, color) VALUES
+        (NULL, 'General', 'receipt', '#9E9E9E'),
+        (NULL, 'Food & Drink', 'utensils', '#FF7043'),
+        (NULL, 'Groceries', 'shopping-cart', '#8BC34A'),
+        (NULL, 'Transport', 'car', '#42A5F5'),
+        (NULL, 'Accommodation', 'bed', '#7E57C2'),
+        (NULL, 'Entertainment', 'film', '#EC407A'),
+        (NULL, 'Shopping', 'shopping-bag', '#FFCA28'),
+        (NULL, 'Utilities', 'bolt', '#26A69A'),
+        (NULL, 'Rent', 'home', '#8D6E63'),
+        (NULL, 'Health', 'heart', '#EF5350'),
+        (NULL, 'Travel', 'plane', '#29B6F6'),
+        (NULL, 'Other', 'tag', '#78909C')
+    ON CONFLICT DO NOTHING;
+
+    -- Existing free-text categories: match the defaults case-insensitively, keep the rest as group categories
+    UPDATE expenses e SET category = c.name
+    FROM categories c
+    WHERE c.group_id IS NULL AND LOWER(c.name) = LOWER(e.category) AND c.name <> e.category;
+    UPDATE expenses SET category = 'General' WHERE category IS NULL OR TRIM(category) = '';
+    INSERT INTO categories (group_id, name)
+    SELECT DISTINCT ON (e.group_id, LOWER(e.category)) e.group_id, e.category
+    FROM expenses e
+    WHERE NOT EXISTS (SELECT 1 FROM categories c WHERE c.group_id IS NULL AND LOWER(c.name) = LOWER(e.category))
+    ON CONFLICT DO NOTHING;
+
+    -- SEARCH: Full-text indexes; the expressions must match the ones used in pkg/handlers/search.go
+    CREATE INDEX IF NOT EXISTS idx_expenses_fts ON expenses USING GIN ((
+        setweight(to_tsvector('english', title), 'A') ||
+        setweight(to_tsvector('english', COALESCE(description, '')), 'B') ||
+        setweight(to_tsvector('english', COALESCE(category, '')), 'C')
+    ));
+    CREATE INDEX IF NOT EXISTS idx_comments_fts ON expense_comments USING GIN (to_tsvector('english', body));
+    CREATE INDEX IF NOT EXISTS idx_users_name_fts ON users USING GIN (to_tsvector('simple', name));
+
+    -- IDEMPOTENCY: First response per (user, Idempotency-Key), replayed on retries until it expires.
+    -- status_code is NULL while the first request is still running.
+    CREATE TABLE IF NOT EXISTS idempotency_keys (
+        user_id INT REFERENCES users(id) ON DELETE CASCADE,
+        key VARCHAR(255) NOT NULL,
+        request_hash CHAR(64) NOT NULL,
+        status_code INT,
+        content_type VARCHAR(100),
+        response_body BYTEA,
+        created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+        expires_at TIMESTAMP NOT NULL,
+        PRIMARY KEY (user_id, key)
+    );
+    CREATE INDEX IF NOT EXISTS idx_idempotency_expiry ON idempotency_keys (expires_at);
+    -- Headers a client needs from the replayed response: the new version and where the resource lives
+    ALTER TABLE idempotency_keys ADD COLUMN IF NOT EXISTS etag VARCHAR(100);
+    ALTER TABLE idempotency_keys ADD COLUMN IF NOT EXISTS location TEXT;
+
+    -- OPTIMISTIC LOCKING: Bumped on every change to an expense, exposed as its ETag
+    ALTER TABLE expenses ADD COLUMN IF NOT EXISTS version INT NOT NULL DEFAULT 1;
+
+    -- SPLIT MODES: How the splits were derived (exact, equal, percent, shares, itemized), so a new amount
+    -- can be split the same way again. weight holds the percent or share count for those modes.
+    ALTER TABLE expenses ADD COLUMN IF NOT EXISTS split_mode VARCHAR(20) NOT NULL DEFAULT 'exact';
+    ALTER TABLE expense_splits ADD COLUMN IF NOT EXISTS weight DECIMAL(12, 4);
+    UPDATE expenses SET split_mode = 'itemized'
+    WHERE split_mode = 'exact' AND EXISTS (SELECT 1 FROM expense_items i WHERE i.expense_id = expenses.id);
+
+    -- REFUNDS: A refund gives (part of) an expense back. Its payers receive the money and its splits
+    -- are the shares being reduced, so it counts against balances with the opposite sign.
+    ALTER TABLE expenses ADD COLUMN IF NOT EXISTS type VARCHAR(20) NOT NULL DEFAULT 'expense';
+    ALTER TABLE expenses ADD COLUMN IF NOT EXISTS refund_of INT REFERENCES expenses(id) ON DELETE SET NULL;
+    CREATE INDEX IF NOT EXISTS idx_expenses_refund_of ON expenses (refund_of) WHERE refund_of IS NOT NULL;
+
+    -- CONFIRMATION: Groups can require participants to confirm their share. Expenses then start as 'pending'
+    -- and become 'final' once every split is accepted; a disputed share keeps the expense pending.
+    ALTER TABLE groups ADD COLUMN IF NOT EXISTS require_confirmation BOOLEAN NOT NULL DEFAULT FALSE;
+    ALTER TABLE expense_splits ADD COLUMN IF NOT EXISTS confirmation VARCHAR(20) NOT NULL DEFAULT 'accepted'; -- 'pending', 'accepted', 'disputed'
+    ALTER TABLE expense_splits ADD COLUMN IF NOT EXISTS dispute_reason TEXT;
+    ALTER TABLE expense_splits ADD COLUMN IF NOT EXISTS responded_at TIMESTAMP;
+
+    -- NOTIFICATIONS: In-app notifications, e.g. an expense creator hearing about a disputed share
+    CREATE TABLE IF NOT EXISTS notifications (
+        id SERIAL PRIMARY KEY,
+        user_id INT REFERENCES users(id) ON DELETE CASCADE,
+        kind VARCHAR(30) NOT NULL,
+        group_id INT REFERENCES groups(id) ON DELETE CASCADE,
+        expense_id INT REFERENCES expenses(id) ON DELETE CASCADE,
+        actor_id INT REFERENCES users(id) ON DELETE SET NULL,
+        message TEXT NOT NULL,
+        created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+        read_at TIMESTAMP
+    );
+    CREATE INDEX IF NOT EXISTS idx_notifications_user ON notifications (user_id, created_at DESC);
+
+    -- EXPENSE TEMPLATES: Presets for expenses logged again and again. The template is a CreateExpenseRequest
+    -- without an amount; payers and splits are weights that get scaled to the amount given at use.
+    CREATE TABLE IF NOT EXISTS expense_templates (
+        id SERIAL PRIMARY KEY,
+        group_id INT REFERENCES groups(id) ON DELETE CASCADE,
+        name VARCHAR(100) NOT NULL,
+        template JSONB NOT NULL,
+        created_by INT REFERENCES users(id) ON DELETE SET NULL,
+        created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+        updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+    );
+    CREATE UNIQUE INDEX IF NOT EXISTS idx_expense_templates_name ON expense_templates (group_id, LOWER(name));
+
+    -- CATEGORY RULES: Keyword and merchant rules pick a category for expenses saved without one.
+    -- group_id NULL are the defaults; a group's own rules win over them.
+    CREATE TABLE IF NOT EXISTS category_rules (
+        id SERIAL PRIMARY KEY,
+        group_id INT REFERENCES groups(id) ON DELETE CASCADE,
+        match_type VARCHAR(10) NOT NULL,      -- keyword | merchant
+        pattern VARCHAR(100) NOT NULL,
+        category VARCHAR(50) NOT NULL,
+        created_by INT REFERENCES users(id) ON DELETE SET NULL,
+        created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+    );
+    CREATE UNIQUE INDEX IF NOT EXISTS idx_category_rules_pattern ON category_rules ((COALESCE(group_id, 0)), match_type, LOWER(pattern));
+
+    INSERT INTO category_rules (group_id, match_type, pattern, category) VALUES
+        (NULL, 'keyword', 'grocery', 'Groceries'),
+        (NULL, 'keyword', 'groceries', 'Groceries'),
+        (NULL, 'keyword', 'supermarket', 'Groceries'),
+        (NULL, 'keyword', 'restaurant', 'Food & Drink'),
+        (NULL, 'keyword', 'breakfast', 'Food & Drink'),
+        (NULL, 'keyword', 'brunch', 'Food & Drink'),
+        (NULL, 'keyword', 'lunch', 'Food & Drink'),
+        (NULL, 'keyword', 'dinner', 'Food & Drink'),
+        (NULL, 'keyword', 'coffee', 'Food & Drink'),
+        (NULL, 'keyword', 'cafe', 'Food & Drink'),
+        (NULL, 'keyword', 'pizza', 'Food & Drink'),
+        (NULL, 'keyword', 'burger', 'Food & Drink'),
+        (NULL, 'keyword', 'sushi', 'Food & Drink'),
+        (NULL, 'keyword', 'takeaway', 'Food & Drink'),
+        (NULL, 'keyword', 'drink', 'Food & Drink'),
+        (NULL, 'keyword', 'beer', 'Food & Drink'),
+        (NULL, 'keyword', 'bar', 'Food & Drink'),
+        (NULL, 'keyword', 'taxi', 'Transport'),
+        (NULL, 'keyword', 'cab', 'Transport'),
+        (NULL, 'keyword', 'bus', 'Transport'),
+        (NULL, 'keyword', 'train', 'Transport'),
+        (NULL, 'keyword', 'metro', 'Transport'),
+        (NULL, 'keyword', 'subway', 'Transport'),
+        (NULL, 'keyword', 'tram', 'Transport'),
+        (NULL, 'keyword', 'fuel', 'Transport'),
+        (NULL, 'keyword', 'petrol', 'Transport'),
+        (NULL, 'keyword', 'gas station', 'Transport'),
+        (NULL, 'keyword', 'parking', 'Transport'),
+        (NULL, 'keyword', 'toll', 'Transport'),
+        (NULL, 'keyword', 'hotel', 'Accommodation'),
+        (NULL, 'keyword', 'hostel', 'Accommodation'),
+        (NULL, 'keyword', 'motel', 'Accommodation'),
+        (NULL, 'keyword', 'cinema', 'Entertainment'),
+        (NULL, 'keyword', 'movie', 'Entertainment'),
+        (NULL, 'keyword', 'concert', 'Entertainment'),
+        (NULL, 'keyword', 'museum', 'Entertainment'),
+        (NULL, 'keyword', 'theatre', 'Entertainment'),
+        (NULL, 'keyword', 'theater', 'Entertainment'),
+        (NULL, 'keyword', 'ticket', 'Entertainment'),
+        (NULL, 'keyword', 'clothes', 'Shopping'),
+        (NULL, 'keyword', 'shoe', 'Shopping'),
+        (NULL, 'keyword', 'gift', 'Shopping'),
+        (NULL, 'keyword', 'electricity', 'Utilities'),
+        (NULL, 'keyword', 'water bill', 'Utilities'),
+        (NULL, 'keyword', 'gas bill', 'Utilities'),
+        (NULL, 'keyword', 'phone bill', 'Utilities'),
+        (NULL, 'keyword', 'internet', 'Utilities'),
+        (NULL, 'keyword', 'wifi', 'Utilities'),
+        (NULL, 'keyword', 'heating', 'Utilities'),
+        (NULL, 'keyword', 'rent', 'Rent'),
+        (NULL, 'keyword', 'pharmacy', 'Health'),
+        (NULL, 'keyword', 'doctor', 'Health'),
+        (NULL, 'keyword', 'dentist', 'Health'),
+        (NULL, 'keyword', 'medicine', 'Health'),
+        (NULL, 'keyword', 'flight', 'Travel'),
+        (NULL, 'keyword', 'airport', 'Travel'),
+        (NULL, 'keyword', 'visa', 'Travel'),
+        (NULL, 'keyword', 'luggage', 'Travel'),
+        (NULL, 'merchant', 'Tesco', 'Groceries'),
+        (NULL, 'merchant', 'Lidl', 'Groceries'),
+        (NULL, 'merchant', 'Aldi', 'Groceries'),
+        (NULL, 'merchant', 'Carrefour', 'Groceries'),
+        (NULL, 'merchant', 'Walmart', 'Groceries'),
+        (NULL, 'merchant', 'Costco', 'Groceries'),
+        (NULL, 'merchant', 'Whole Foods', 'Groceries'),
+        (NULL, 'merchant', 'Trader Joe''s', 'Groceries'),
+        (NULL, 'merchant', 'Starbucks', 'Food & Drink'),
+        (NULL, 'merchant', 'McDonald''s', 'Food & Drink'),
+        (NULL, 'merchant', 'Burger King', 'Food & Drink'),
+        (NULL, 'merchant', 'KFC', 'Food & Drink'),
+        (NULL, 'merchant', 'Domino''s', 'Food & Drink'),
+        (NULL, 'merchant', 'Deliveroo', 'Food & Drink'),
+        (NULL, 'merchant', 'Uber Eats', 'Food & Drink'),
+        (NULL, 'merchant', 'DoorDash', 'Food & Drink'),
+        (NULL, 'merchant', 'Uber', 'Transport'),
+        (NULL, 'merchant', 'Lyft', 'Transport'),
+        (NULL, 'merchant', 'Bolt', 'Transport'),
+        (NULL, 'merchant', 'Shell', 'Transport'),
+        (NULL, 'merchant', 'Airbnb', 'Accommodation'),
+        (NULL, 'merchant', 'Booking.com', 'Accommodation'),
+        (NULL, 'merchant', 'Hilton', 'Accommodation'),
+        (NULL, 'merchant', 'Marriott', 'Accommodation'),
+        (NULL, 'merchant', 'Netflix', 'Entertainment'),
+        (NULL, 'merchant', 'Spotify', 'Entertainment'),
+        (NULL, 'merchant', 'Steam', 'Entertainment'),
+        (NULL, 'merchant', 'Amazon', 'Shopping'),
+        (NULL, 'merchant', 'IKEA', 'Shopping'),
+        (NULL, 'merchant', 'Zara', 'Shopping'),
+        (NULL, 'merchant', 'Ryanair', 'Travel'),
+        (NULL, 'merchant', 'easyJet', 'Travel'),
+        (NULL, 'merchant', 'Lufthansa', 'Travel')
+    ON CONFLICT DO NOTHING;
+
+    -- Manual category changes teach the categorizer: a title (normalized) keeps the category it was corrected to
+    CREATE TABLE IF NOT EXISTS category_corrections (
+        group_id INT REFERENCES groups(id) ON DELETE CASCADE,
+        title_key VARCHAR(200) NOT NULL,
+        category VARCHAR(50) NOT NULL,
+        times INT NOT NULL DEFAULT 1,         -- how often in a row it was corrected to this category
+        updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+        PRIMARY KEY (group_id, title_key)
+    );
+
+    -- SETTLEMENTS: A member paying another back. In balances it works like an expense paid by from_user
+    -- and owed entirely by to_user.
+    CREATE TABLE IF NOT EXISTS settlements (
+        id SERIAL PRIMARY KEY,
+        group_id INT REFERENCES groups(id) ON DELETE CASCADE,
+        from_user INT REFERENCES users(id),
+        to_user INT REFERENCES users(id),
+        amount DECIMAL(10, 2) NOT NULL,
+        currency VARCHAR(3) NOT NULL,
+        exchange_rate DECIMAL(18, 8) NOT NULL DEFAULT 1,
+        settled_on DATE NOT NULL DEFAULT CURRENT_DATE,
+        note TEXT NOT NULL DEFAULT '',
+        created_by INT REFERENCES users(id) ON DELETE SET NULL,
+        created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+    );
+    CREATE INDEX IF NOT EXISTS idx_settlements_group ON settlements (group_id, settled_on);
+
+    -- CATEGORY RENAMES: Renames and merges of a group's categories, so reverting to a revision
+    -- saved under an old name finds the category it became.
+    CREATE TABLE IF NOT EXISTS category_renames (
+        id SERIAL PRIMARY KEY,
+        group_id INT REFERENCES groups(id) ON DELETE CASCADE,
+        from_name VARCHAR(50) NOT NULL,
+        to_name VARCHAR(50) NOT NULL,
+        renamed_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+    );
+    CREATE INDEX IF NOT EXISTS idx_category_renames_group ON category_renames (group_id, LOWER(from_name));
+
+    -- RECURRING FAILURES: A schedule that keeps failing waits longer before each retry and is
+    -- paused after too many; last_error tells the group what went wrong.
+    ALTER TABLE recurring_expenses ADD COLUMN IF NOT EXISTS failure_count INT NOT NULL DEFAULT 0;
+    ALTER TABLE recurring_expenses ADD COLUMN IF NOT EXISTS last_error TEXT;
+    ALTER TABLE recurring_expenses ADD COLUMN IF NOT EXISTS retry_at TIMESTAMP;
     `
 
 	_, err := DB.Exec(schema)
@@ -63,4 +463,4 @@ func Migrate() {
 	}
 
 	fmt.Println("Database tables checked/created successfully!")
-}
\ No newline at end of file
+}
@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+
+	"money-splitter/pkg/db"
+)
+
+// isGroupMember reports whether the user belongs to the group
+func isGroupMember(groupID any, userID int) bool {
+	var exists bool
+	query := `SELECT EXISTS(SELECT 1 FROM group_members WHERE group_id=$1 AND user_id=$2)`
+	if err := db.DB.QueryRow(query, groupID, userID).Scan(&exists); err != nil {
+		return false
+	}
+	return exists
+}
+
+// expenseGroupID returns the group a live (not trashed) expense belongs to
+func expenseGroupID(expenseID any) (int, error) {
+	var groupID int
+	err := db.DB.QueryRow(`SELECT group_id FROM expenses WHERE id = $1 AND deleted_at IS NULL`, expenseID).Scan(&groupID)
+	return groupID, err
+}
+
+// groupMemberSet loads the ids of everyone in the group
+func groupMemberSet(q queryer, groupID any) (map[int]bool, error) {
+	rows, err := q.Query(`SELECT user_id FROM group_members WHERE group_id = $1`, groupID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	members := make(map[int]bool)
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		members[id] = true
+	}
+	return members, rows.Err()
+}
+
+// checkParticipants makes sure payers, splits and item sharers all belong to the group
+func checkParticipants(members map[int]bool, req *CreateExpenseRequest) error {
+	for _, p := range req.Payers {
+		if !members[p.UserID] {
+			return errors.New("Payers must be members of the group")
+		}
+	}
+	for _, s := range req.Splits {
+		if !members[s.UserID] {
+			return errors.New("Splits must be for members of the group")
+		}
+	}
+	for _, item := range req.Items {
+		for _, uid := range item.SharedBy {
+			if !members[uid] {
+				return fmt.Errorf("Item %q is shared by someone outside the group", item.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// checkGroupParticipants is checkParticipants for a single expense
+func checkGroupParticipants(groupID any, req *CreateExpenseRequest) error {
+	members, err := groupMemberSet(db.DB, groupID)
+	if err != nil {
+		return err
+	}
+	return checkParticipants(members, req)
+}
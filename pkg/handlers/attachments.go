@@ -0,0 +1,397 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"money-splitter/pkg/db"
+	"money-splitter/pkg/middleware"
+	"money-splitter/pkg/storage"
+)
+
+// Storage holds receipt files and thumbnails (set in main)
+var Storage storage.Blob
+
+const (
+	maxAttachmentSize  = 10 << 20 // 10 MB
+	thumbnailMaxSide   = 320
+	thumbnailMaxPixels = 40_000_000 // larger images are stored without a thumbnail rather than decoded
+	downloadURLTTL     = 15 * time.Minute
+)
+
+// Receipts are photos or PDFs; the type is sniffed from the bytes, not trusted from the client
+var allowedAttachmentTypes = map[string]bool{
+	"image/jpeg":      true,
+	"image/png":       true,
+	"image/gif":       true,
+	"image/webp":      true,
+	"application/pdf": true,
+}
+
+// Types makeThumbnail can decode; WebP has no decoder in the standard library, so it gets no thumbnail
+var thumbnailTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/gif":  true,
+}
+
+type AttachmentResponse struct {
+	ID           int    `json:"id"`
+	ExpenseID    int    `json:"expense_id"`
+	FileName     string `json:"file_name"`
+	ContentType  string `json:"content_type"`
+	SizeBytes    int64  `json:"size_bytes"`
+	UploadedBy   int    `json:"uploaded_by"`
+	CreatedAt    string `json:"created_at"`
+	URL          string `json:"url"`
+	ThumbnailURL string `json:"thumbnail_url,omitempty"`
+}
+
+// UploadAttachment accepts a multipart "file" field and stores it against the expense
+func UploadAttachment(w http.ResponseWriter, r *http.Request) {
+	expenseID := r.PathValue("id")
+	userID := r.Context().Value(middleware.UserIDKey).(int)
+
+	groupID, err := expenseGroupID(expenseID)
+	if err != nil {
+		http.Error(w, "Expense not found", http.StatusNotFound)
+		return
+	}
+	if !isGroupMember(groupID, userID) {
+		http.Error(w, "Not a member of this group", http.StatusForbidden)
+		return
+	}
+
+	// 1. Read the upload (with some headroom for the multipart envelope)
+	r.Body = http.MaxBytesReader(w, r.Body, maxAttachmentSize+1<<20)
+	if err := r.ParseMultipartForm(maxAttachmentSize); err != nil {
+		http.Error(w, "File too large (max 10 MB) or invalid form", http.StatusRequestEntityTooLarge)
+		return
+	}
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		http.Error(w, "Missing file field", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, maxAttachmentSize+1))
+	if err != nil {
+		http.Error(w, "Failed to read file", http.StatusBadRequest)
+		return
+	}
+	if len(data) == 0 {
+		http.Error(w, "File is empty", http.StatusBadRequest)
+		return
+	}
+	if len(data) > maxAttachmentSize {
+		http.Error(w, "File too large (max 10 MB)", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	// 2. Check the content type
+	contentType := http.DetectContentType(data)
+	if !allowedAttachmentTypes[contentType] {
+		http.Error(w, "Only JPEG, PNG, GIF, WebP images and PDFs are allowed", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	// 3. Store the original and, for images we can decode, a thumbnail
+	key := fmt.Sprintf("expenses/%s/%s", expenseID, randomKey())
+	if err := Storage.Put(r.Context(), key, data, contentType); err != nil {
+		fmt.Println("Error storing attachment:", err)
+		http.Error(w, "Failed to store file", http.StatusInternalServerError)
+		return
+	}
+
+	var thumbnailKey *string
+	if thumbnailTypes[contentType] {
+		if thumb, err := makeThumbnail(data); err == nil {
+			tk := key + "-thumb.jpg"
+			if err := Storage.Put(r.Context(), tk, thumb, "image/jpeg"); err == nil {
+				thumbnailKey = &tk
+			}
+		}
+	}
+
+	// 4. Record it
+	var a AttachmentResponse
+	var createdAt time.Time
+	query := `
+		INSERT INTO expense_attachments (expense_id, uploaded_by, file_name, content_type, size_bytes, storage_key, thumbnail_key)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id, expense_id, file_name, content_type, size_bytes, uploaded_by, created_at`
+	err = db.DB.QueryRow(query, expenseID, userID, filepath.Base(header.Filename), contentType, len(data), key, thumbnailKey).
+		Scan(&a.ID, &a.ExpenseID, &a.FileName, &a.ContentType, &a.SizeBytes, &a.UploadedBy, &createdAt)
+	if err != nil {
+		fmt.Println("Error saving attachment:", err)
+		Storage.Delete(r.Context(), key)
+		if thumbnailKey != nil {
+			Storage.Delete(r.Context(), *thumbnailKey)
+		}
+		http.Error(w, "Failed to save attachment", http.StatusInternalServerError)
+		return
+	}
+	a.CreatedAt = createdAt.Format(time.RFC3339)
+	a.URL = signedAttachmentURL(a.ID, "original")
+	if thumbnailKey != nil {
+		a.ThumbnailURL = signedAttachmentURL(a.ID, "thumbnail")
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(a)
+}
+
+func GetExpenseAttachments(w http.ResponseWriter, r *http.Request) {
+	expenseID := r.PathValue("id")
+	userID := r.Context().Value(middleware.UserIDKey).(int)
+
+	groupID, err := expenseGroupID(expenseID)
+	if err != nil {
+		http.Error(w, "Expense not found", http.StatusNotFound)
+		return
+	}
+	if !isGroupMember(groupID, userID) {
+		http.Error(w, "Not a member of this group", http.StatusForbidden)
+		return
+	}
+
+	attachments, err := loadAttachments(expenseID)
+	if err != nil {
+		fmt.Println("Error fetching attachments:", err)
+		http.Error(w, "Failed to fetch attachments", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(attachments)
+}
+
+// DownloadAttachment serves a file through a signed, expiring URL so it can be used
+// directly in <img>/<a> tags without an Authorization header
+func DownloadAttachment(w http.ResponseWriter, r *http.Request) {
+	attachmentID := r.PathValue("id")
+	variant := r.URL.Query().Get("variant")
+	expires := r.URL.Query().Get("expires")
+	sig := r.URL.Query().Get("sig")
+
+	id, err := strconv.Atoi(attachmentID)
+	if err != nil {
+		http.Error(w, "Attachment not found", http.StatusNotFound)
+		return
+	}
+	expiresAt, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || time.Now().Unix() > expiresAt {
+		http.Error(w, "Download link expired", http.StatusForbidden)
+		return
+	}
+	if !hmac.Equal([]byte(sig), []byte(attachmentSignature(id, variant, expiresAt))) {
+		http.Error(w, "Invalid download link", http.StatusForbidden)
+		return
+	}
+
+	var fileName, contentType, key string
+	var thumbnailKey *string
+	err = db.DB.QueryRow(`
+		SELECT a.file_name, a.content_type, a.storage_key, a.thumbnail_key
+		FROM expense_attachments a
+		JOIN expenses e ON a.expense_id = e.id
+		WHERE a.id = $1 AND e.deleted_at IS NULL`, id).
+		Scan(&fileName, &contentType, &key, &thumbnailKey)
+	if err != nil {
+		http.Error(w, "Attachment not found", http.StatusNotFound)
+		return
+	}
+	if variant == "thumbnail" {
+		if thumbnailKey == nil {
+			http.Error(w, "No thumbnail for this attachment", http.StatusNotFound)
+			return
+		}
+		key, contentType = *thumbnailKey, "image/jpeg"
+	}
+
+	body, err := Storage.Get(r.Context(), key)
+	if errors.Is(err, storage.ErrNotFound) {
+		http.Error(w, "File missing from storage", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		fmt.Println("Error reading attachment:", err)
+		http.Error(w, "Failed to read file", http.StatusInternalServerError)
+		return
+	}
+	defer body.Close()
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=%q", fileName))
+	w.Header().Set("Cache-Control", "private, max-age=900")
+	io.Copy(w, body)
+}
+
+func DeleteAttachment(w http.ResponseWriter, r *http.Request) {
+	attachmentID := r.PathValue("id")
+	userID := r.Context().Value(middleware.UserIDKey).(int)
+
+	var groupID int
+	var key string
+	var thumbnailKey *string
+	err := db.DB.QueryRow(`
+		SELECT e.group_id, a.storage_key, a.thumbnail_key
+		FROM expense_attachments a
+		JOIN expenses e ON a.expense_id = e.id
+		WHERE a.id = $1 AND e.deleted_at IS NULL`, attachmentID).Scan(&groupID, &key, &thumbnailKey)
+	if err != nil {
+		http.Error(w, "Attachment not found", http.StatusNotFound)
+		return
+	}
+	if !isGroupMember(groupID, userID) {
+		http.Error(w, "Not a member of this group", http.StatusForbidden)
+		return
+	}
+
+	if _, err := db.DB.Exec(`DELETE FROM expense_attachments WHERE id = $1`, attachmentID); err != nil {
+		http.Error(w, "Failed to delete attachment", http.StatusInternalServerError)
+		return
+	}
+	deleteAttachmentBlobs(key, thumbnailKey)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Attachment deleted"})
+}
+
+// loadAttachments lists an expense's files with fresh signed URLs
+func loadAttachments(expenseID any) ([]AttachmentResponse, error) {
+	rows, err := db.DB.Query(`
+		SELECT id, expense_id, file_name, content_type, size_bytes, uploaded_by, created_at, thumbnail_key IS NOT NULL
+		FROM expense_attachments
+		WHERE expense_id = $1
+		ORDER BY created_at, id`, expenseID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	attachments := []AttachmentResponse{}
+	for rows.Next() {
+		var a AttachmentResponse
+		var createdAt time.Time
+		var hasThumbnail bool
+		if err := rows.Scan(&a.ID, &a.ExpenseID, &a.FileName, &a.ContentType, &a.SizeBytes, &a.UploadedBy, &createdAt, &hasThumbnail); err != nil {
+			return nil, err
+		}
+		a.CreatedAt = createdAt.Format(time.RFC3339)
+		a.URL = signedAttachmentURL(a.ID, "original")
+		if hasThumbnail {
+			a.ThumbnailURL = signedAttachmentURL(a.ID, "thumbnail")
+		}
+		attachments = append(attachments, a)
+	}
+	return attachments, rows.Err()
+}
+
+// deleteAttachmentBlobs removes stored files; failures only leave orphans behind, so they are just logged
+func deleteAttachmentBlobs(key string, thumbnailKey *string) {
+	if err := Storage.Delete(context.Background(), key); err != nil {
+		fmt.Println("Error deleting attachment blob:", err)
+	}
+	if thumbnailKey != nil {
+		if err := Storage.Delete(context.Background(), *thumbnailKey); err != nil {
+			fmt.Println("Error deleting thumbnail blob:", err)
+		}
+	}
+}
+
+func signedAttachmentURL(id int, variant string) string {
+	expires := time.Now().Add(downloadURLTTL).Unix()
+	return fmt.Sprintf("/attachments/%d/download?variant=%s&expires=%d&sig=%s",
+		id, variant, expires, attachmentSignature(id, variant, expires))
+}
+
+func attachmentSignature(id int, variant string, expires int64) string {
+	mac := hmac.New(sha256.New, []byte(os.Getenv("JWT_SECRET")))
+	fmt.Fprintf(mac, "attachment:%d:%s:%d", id, variant, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func randomKey() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// makeThumbnail downsizes a JPEG/PNG/GIF to fit thumbnailMaxSide using box averaging.
+// Transparent areas are flattened onto white since the thumbnail is a JPEG.
+// The header is checked first: a small file can declare a huge image, and decoding it would allocate all of it.
+func makeThumbnail(data []byte) ([]byte, error) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if config.Width <= 0 || config.Height <= 0 || int64(config.Width)*int64(config.Height) > thumbnailMaxPixels {
+		return nil, errors.New("image too large for a thumbnail")
+	}
+
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	bounds := src.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	if w == 0 || h == 0 {
+		return nil, errors.New("empty image")
+	}
+	scale := float64(thumbnailMaxSide) / float64(max(w, h))
+	if scale > 1 {
+		scale = 1
+	}
+	tw, th := max(1, int(float64(w)*scale)), max(1, int(float64(h)*scale))
+
+	dst := image.NewRGBA(image.Rect(0, 0, tw, th))
+	for y := 0; y < th; y++ {
+		y0 := bounds.Min.Y + y*h/th
+		y1 := max(y0+1, bounds.Min.Y+(y+1)*h/th)
+		for x := 0; x < tw; x++ {
+			x0 := bounds.Min.X + x*w/tw
+			x1 := max(x0+1, bounds.Min.X+(x+1)*w/tw)
+
+			var r, g, b, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := src.At(sx, sy).RGBA()
+					white := uint64(0xffff - ca)
+					r, g, b, n = r+uint64(cr)+white, g+uint64(cg)+white, b+uint64(cb)+white, n+1
+				}
+			}
+			i := dst.PixOffset(x, y)
+			dst.Pix[i+0] = uint8(r / n >> 8)
+			dst.Pix[i+1] = uint8(g / n >> 8)
+			dst.Pix[i+2] = uint8(b / n >> 8)
+			dst.Pix[i+3] = 0xff
+		}
+	}
+
+	var out bytes.Buffer
+	if err := jpeg.Encode(&out, dst, &jpeg.Options{Quality: 80}); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
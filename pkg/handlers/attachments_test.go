@@ -0,0 +1,235 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"money-splitter/pkg/db"
+	"money-splitter/pkg/storage"
+)
+
+// memoryStore is an in-memory storage.Blob
+type memoryStore struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{objects: map[string][]byte{}}
+}
+
+func (m *memoryStore) Put(_ context.Context, key string, data []byte, _ string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.objects[key] = data
+	return nil
+}
+
+func (m *memoryStore) Get(_ context.Context, key string) (io.ReadCloser, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	data, ok := m.objects[key]
+	if !ok {
+		return nil, storage.ErrNotFound
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (m *memoryStore) Delete(_ context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.objects, key)
+	return nil
+}
+
+func useStore(t *testing.T, store storage.Blob) {
+	previous := Storage
+	Storage = store
+	t.Cleanup(func() { Storage = previous })
+}
+
+func TestSignedAttachmentURL(t *testing.T) {
+	t.Setenv("JWT_SECRET", "test-secret")
+
+	link, err := url.Parse(signedAttachmentURL(42, "thumbnail"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if link.Path != "/attachments/42/download" {
+		t.Fatalf("path = %q", link.Path)
+	}
+	q := link.Query()
+	expires, _ := strconv.ParseInt(q.Get("expires"), 10, 64)
+	if ttl := time.Until(time.Unix(expires, 0)); ttl <= 0 || ttl > downloadURLTTL {
+		t.Fatalf("link expires in %v, want within %v", ttl, downloadURLTTL)
+	}
+	if q.Get("sig") != attachmentSignature(42, "thumbnail", expires) {
+		t.Fatal("signature doesn't verify")
+	}
+
+	// The signature covers the id, the variant and the expiry
+	for name, sig := range map[string]string{
+		"other id":      attachmentSignature(43, "thumbnail", expires),
+		"other variant": attachmentSignature(42, "original", expires),
+		"later expiry":  attachmentSignature(42, "thumbnail", expires+3600),
+	} {
+		if sig == q.Get("sig") {
+			t.Errorf("%s: same signature", name)
+		}
+	}
+}
+
+func TestDownloadAttachmentRejectsBadLinks(t *testing.T) {
+	t.Setenv("JWT_SECRET", "test-secret")
+	future := time.Now().Add(time.Hour).Unix()
+	past := time.Now().Add(-time.Minute).Unix()
+
+	tests := []struct {
+		name    string
+		expires int64
+		sig     string
+	}{
+		{"expired", past, attachmentSignature(7, "original", past)},
+		{"tampered signature", future, attachmentSignature(8, "original", future)},
+		{"corrupted signature", future, "00" + attachmentSignature(7, "original", future)[2:]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := "/attachments/7/download?variant=original&expires=" + strconv.FormatInt(tt.expires, 10) + "&sig=" + tt.sig
+			r := httptest.NewRequest(http.MethodGet, target, nil)
+			r.SetPathValue("id", "7")
+			w := httptest.NewRecorder()
+
+			DownloadAttachment(w, r)
+			if w.Code != http.StatusForbidden {
+				t.Fatalf("status = %d, want 403", w.Code)
+			}
+		})
+	}
+}
+
+func TestMakeThumbnail(t *testing.T) {
+	var buf bytes.Buffer
+	src := image.NewRGBA(image.Rect(0, 0, 800, 400))
+	for i := range src.Pix {
+		src.Pix[i] = 0xff
+	}
+	src.Set(0, 0, color.Black)
+	png.Encode(&buf, src)
+
+	thumb, err := makeThumbnail(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _, err := image.DecodeConfig(bytes.NewReader(thumb))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Width != thumbnailMaxSide || got.Height != thumbnailMaxSide/2 {
+		t.Fatalf("thumbnail is %dx%d", got.Width, got.Height)
+	}
+}
+
+func TestMakeThumbnailRefusesHugeDimensions(t *testing.T) {
+	// A tiny PNG header can claim a 100000x100000 image; it must be refused before decoding
+	var buf bytes.Buffer
+	png.Encode(&buf, image.NewGray(image.Rect(0, 0, 1, 1)))
+	data := buf.Bytes()
+	ihdr := bytes.Index(data, []byte("IHDR"))
+	binary.BigEndian.PutUint32(data[ihdr+4:], 100000)
+	binary.BigEndian.PutUint32(data[ihdr+8:], 100000)
+	binary.BigEndian.PutUint32(data[ihdr+17:], crc32.ChecksumIEEE(data[ihdr:ihdr+17]))
+	if config, _, err := image.DecodeConfig(bytes.NewReader(data)); err != nil || config.Width != 100000 {
+		t.Fatalf("crafted header doesn't parse: %v", err)
+	}
+
+	if _, err := makeThumbnail(data); err == nil {
+		t.Fatal("makeThumbnail decoded a 10-gigapixel image")
+	}
+}
+
+// TestPurgeTrashDeletesBlobsAfterCommit needs a disposable Postgres database:
+//
+//	TEST_DB_URL=postgres://localhost/money_splitter_test?sslmode=disable go test ./pkg/handlers
+func TestPurgeTrashDeletesBlobsAfterCommit(t *testing.T) {
+	dsn := os.Getenv("TEST_DB_URL")
+	if dsn == "" {
+		t.Skip("TEST_DB_URL not set")
+	}
+	conn, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	previous := db.DB
+	db.DB = conn
+	t.Cleanup(func() { db.DB = previous })
+	db.Migrate()
+
+	store := newMemoryStore()
+	useStore(t, store)
+
+	var userID, groupID, oldID, recentID int
+	must := func(err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	must(conn.QueryRow(`INSERT INTO users (name, is_ghost) VALUES ('Purge test', TRUE) RETURNING id`).Scan(&userID))
+	must(conn.QueryRow(`INSERT INTO groups (name, created_by) VALUES ('Purge test', $1) RETURNING id`, userID).Scan(&groupID))
+	t.Cleanup(func() {
+		conn.Exec(`DELETE FROM groups WHERE id = $1`, groupID)
+		conn.Exec(`DELETE FROM users WHERE id = $1`, userID)
+	})
+	insertTrashed := func(deletedAt time.Time, key string) int {
+		var id int
+		must(conn.QueryRow(`
+			INSERT INTO expenses (group_id, title, amount, deleted_at, deleted_by)
+			VALUES ($1, 'Receipt', 10, $2, $3) RETURNING id`, groupID, deletedAt, userID).Scan(&id))
+		thumb := key + "-thumb.jpg"
+		_, err := conn.Exec(`
+			INSERT INTO expense_attachments (expense_id, uploaded_by, file_name, content_type, size_bytes, storage_key, thumbnail_key)
+			VALUES ($1, $2, 'r.jpg', 'image/jpeg', 1, $3, $4)`, id, userID, key, thumb)
+		must(err)
+		store.Put(context.Background(), key, []byte("x"), "image/jpeg")
+		store.Put(context.Background(), thumb, []byte("x"), "image/jpeg")
+		return id
+	}
+	oldID = insertTrashed(time.Now().Add(-60*24*time.Hour), "purge-test/old")
+	recentID = insertTrashed(time.Now().Add(-time.Hour), "purge-test/recent")
+
+	if _, err := purgeTrash(time.Now().Add(-30 * 24 * time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	var left int
+	must(conn.QueryRow(`SELECT COUNT(*) FROM expenses WHERE id = ANY(ARRAY[$1, $2]::int[])`, oldID, recentID).Scan(&left))
+	if left != 1 {
+		t.Fatalf("%d expenses left, want only the recent one", left)
+	}
+	if _, ok := store.objects["purge-test/old"]; ok {
+		t.Error("purged expense's receipt is still stored")
+	}
+	if _, ok := store.objects["purge-test/old-thumb.jpg"]; ok {
+		t.Error("purged expense's thumbnail is still stored")
+	}
+	if _, ok := store.objects["purge-test/recent"]; !ok {
+		t.Error("receipt of an expense still in the trash was deleted")
+	}
+}
@@ -23,18 +23,28 @@ type balanceItem struct {
 	Amount float64
 }
 
-// GetGroupBalance handles the API request to fetch balances and settlements
+// GetGroupBalance handles the API request to fetch balances and settlements.
+// Recorded settlements count towards the balances, so the suggested transactions shrink as people pay.
+// ?include_pending=true also counts expenses that are still waiting for confirmation.
 func GetGroupBalance(w http.ResponseWriter, r *http.Request) {
 	groupID := r.PathValue("id")
+	includePending := r.URL.Query().Get("include_pending") == "true"
 
-	// 1. Calculate Total Paid by each user
+	baseCurrency, err := groupCurrency(groupID)
+	if err != nil {
+		http.Error(w, "Group not found", http.StatusNotFound)
+		return
+	}
+
+	// 1. Calculate Total Paid by each user (converted into the group currency)
+	// Drafts still being claimed don't count until they are finalized; refunds count negatively
 	rows, err := db.DB.Query(`
-        SELECT ep.user_id, SUM(ep.paid_amount)
+        SELECT ep.user_id, SUM(ep.paid_amount * e.exchange_rate * `+balanceSign+`)
         FROM expense_payers ep
         JOIN expenses e ON ep.expense_id = e.id
-        WHERE e.group_id = $1
+        WHERE e.group_id = $1 AND (e.status = 'final' OR ($2 AND e.status = 'pending')) AND e.deleted_at IS NULL
         GROUP BY ep.user_id
-    `, groupID)
+    `, groupID, includePending)
 
 	if err != nil {
 		fmt.Println("Error calculating paid:", err)
@@ -53,14 +63,14 @@ func GetGroupBalance(w http.ResponseWriter, r *http.Request) {
 		paidMap[userID] = amount
 	}
 
-	// 2. Calculate Total Owed by each user
+	// 2. Calculate Total Owed by each user (converted into the group currency)
 	rows, err = db.DB.Query(`
-        SELECT es.user_id, SUM(es.amount_owed)
+        SELECT es.user_id, SUM(es.amount_owed * e.exchange_rate * `+balanceSign+`)
         FROM expense_splits es
         JOIN expenses e ON es.expense_id = e.id
-        WHERE e.group_id = $1
+        WHERE e.group_id = $1 AND (e.status = 'final' OR ($2 AND e.status = 'pending')) AND e.deleted_at IS NULL
         GROUP BY es.user_id
-    `, groupID)
+    `, groupID, includePending)
 
 	if err != nil {
 		fmt.Println("Error calculating owed:", err)
@@ -79,6 +89,31 @@ func GetGroupBalance(w http.ResponseWriter, r *http.Request) {
 		owedMap[userID] = amount
 	}
 
+	// Settlements: the sender paid, the receiver got the money as if it was owed to them
+	rows, err = db.DB.Query(`
+        SELECT from_user, to_user, SUM(amount * exchange_rate)
+        FROM settlements
+        WHERE group_id = $1
+        GROUP BY from_user, to_user
+    `, groupID)
+
+	if err != nil {
+		fmt.Println("Error calculating settlements:", err)
+		http.Error(w, "Database error (Settlements)", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var fromUser, toUser int
+		var amount float64
+		if err := rows.Scan(&fromUser, &toUser, &amount); err != nil {
+			continue
+		}
+		paidMap[fromUser] += amount
+		owedMap[toUser] += amount
+	}
+
 	// 3. Calculate Net Balance (Paid - Owed)
 	balances := make(map[int]float64)
 	allUsers := make(map[int]bool)
@@ -100,8 +135,10 @@ func GetGroupBalance(w http.ResponseWriter, r *http.Request) {
 
 	// 5. Send Response
 	response := map[string]interface{}{
-		"balances":     balances,
-		"transactions": transactions,
+		"currency":        baseCurrency,
+		"include_pending": includePending,
+		"balances":        balances,
+		"transactions":    transactions,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -123,7 +160,7 @@ func minimizeDebts(balances map[int]float64) []Transaction {
 	}
 
 	// Sort to prioritize largest debts/credits
-	sort.Slice(debtors, func(i, j int) bool { return debtors[i].Amount < debtors[j].Amount })    // Ascending (most negative first)
+	sort.Slice(debtors, func(i, j int) bool { return debtors[i].Amount < debtors[j].Amount })       // Ascending (most negative first)
 	sort.Slice(creditors, func(i, j int) bool { return creditors[i].Amount > creditors[j].Amount }) // Descending (most positive first)
 
 	var transactions []Transaction
@@ -161,4 +198,4 @@ func minimizeDebts(balances map[int]float64) []Transaction {
 	}
 
 	return transactions
-}
\ No newline at end of file
+}
@@ -0,0 +1,343 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"money-splitter/pkg/db"
+	"money-splitter/pkg/middleware"
+)
+
+const (
+	maxBatchSize = 500
+
+	// Postgres allows 65535 bind parameters per statement; stay well below it
+	maxBatchParams = 30000
+
+	batchAtomic     = "atomic"
+	batchBestEffort = "best_effort"
+)
+
+type BatchExpenseRequest struct {
+	Mode     string                 `json:"mode"` // atomic (default) or best_effort
+	Expenses []CreateExpenseRequest `json:"expenses"`
+}
+
+type BatchItemResult struct {
+	Index     int    `json:"index"`
+	ExpenseID int    `json:"expense_id,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+type BatchExpenseResponse struct {
+	Mode    string            `json:"mode"`
+	Created int               `json:"created"`
+	Failed  int               `json:"failed"`
+	Results []BatchItemResult `json:"results"`
+}
+
+// CreateExpensesBatch creates many expenses in one request.
+// Every item is validated like CreateExpense. In atomic mode one bad item rejects the whole batch;
+// in best_effort mode the valid items are created and the rest are reported by index.
+func CreateExpensesBatch(w http.ResponseWriter, r *http.Request) {
+	groupID := r.PathValue("id")
+	userID := r.Context().Value(middleware.UserIDKey).(int)
+
+	if !isGroupMember(groupID, userID) {
+		http.Error(w, "Not a member of this group", http.StatusForbidden)
+		return
+	}
+
+	var req BatchExpenseRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	if req.Mode == "" {
+		req.Mode = batchAtomic
+	}
+	if req.Mode != batchAtomic && req.Mode != batchBestEffort {
+		http.Error(w, "mode must be atomic or best_effort", http.StatusBadRequest)
+		return
+	}
+	if len(req.Expenses) == 0 || len(req.Expenses) > maxBatchSize {
+		http.Error(w, fmt.Sprintf("A batch must contain 1-%d expenses", maxBatchSize), http.StatusBadRequest)
+		return
+	}
+
+	baseCurrency, err := groupCurrency(groupID)
+	if err != nil {
+		http.Error(w, "Group not found", http.StatusNotFound)
+		return
+	}
+	categories, err := loadCategorizer(db.DB, groupID)
+	if err != nil {
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+	members, err := groupMemberSet(db.DB, groupID)
+	if err != nil {
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	// 1. Validate every item with the CreateExpense rules
+	resp := BatchExpenseResponse{Mode: req.Mode, Results: make([]BatchItemResult, len(req.Expenses))}
+	var valid []int
+	now := time.Now()
+	for i := range req.Expenses {
+		resp.Results[i].Index = i
+		if err := prepareBatchItem(&req.Expenses[i], categories, members, baseCurrency, now); err != nil {
+			resp.Results[i].Error = err.Error()
+			continue
+		}
+		valid = append(valid, i)
+	}
+
+	if req.Mode == batchAtomic && len(valid) < len(req.Expenses) {
+		resp.Failed = len(req.Expenses) - len(valid)
+		writeBatchResponse(w, http.StatusBadRequest, resp)
+		return
+	}
+
+	// 2. Insert
+	tx, err := db.DB.Begin()
+	if err != nil {
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	batch := make([]*CreateExpenseRequest, len(valid))
+	for n, i := range valid {
+		batch[n] = &req.Expenses[i]
+	}
+
+	if len(batch) > 0 {
+		if req.Mode == batchAtomic {
+			ids, err := insertExpenseBatch(tx, groupID, batch, now, userID)
+			if err != nil {
+				fmt.Println("Error inserting expense batch:", err)
+				http.Error(w, "Failed to save expenses", http.StatusInternalServerError)
+				return
+			}
+			for n, i := range valid {
+				resp.Results[i].ExpenseID = ids[n]
+			}
+		} else {
+			insertBestEffort(tx, groupID, batch, valid, now, userID, resp.Results)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
+		return
+	}
+
+	for _, res := range resp.Results {
+		if res.ExpenseID != 0 {
+			resp.Created++
+		} else {
+			resp.Failed++
+		}
+	}
+	status := http.StatusCreated
+	if resp.Failed > 0 {
+		status = http.StatusMultiStatus
+	}
+	writeBatchResponse(w, status, resp)
+}
+
+// prepareBatchItem runs the CreateExpense validation for one item against preloaded group data
+func prepareBatchItem(req *CreateExpenseRequest, categories *categorizer, members map[int]bool, baseCurrency string, now time.Time) error {
+	if err := prepareExpense(req); err != nil {
+		return err
+	}
+	if err := checkParticipants(members, req); err != nil {
+		return err
+	}
+	if req.Type == typeRefund {
+		return errors.New("Refunds can't be batched, create them one at a time")
+	}
+	day, err := resolveExpenseDate(req, now)
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(req.Category) == "" {
+		req.Category, _ = categories.categorize(req.Title, req.Description)
+	}
+	if req.Category, err = matchCategory(categories.categories, req.Category); err != nil {
+		return err
+	}
+	return applyCurrency(req, baseCurrency, day)
+}
+
+// insertBestEffort tries the whole batch under a savepoint and, if that fails, falls back to
+// inserting item by item so one bad row only costs that row.
+func insertBestEffort(tx *sql.Tx, groupID any, batch []*CreateExpenseRequest, indexes []int, now time.Time, userID int, results []BatchItemResult) {
+	if _, err := tx.Exec(`SAVEPOINT batch`); err != nil {
+		for _, i := range indexes {
+			results[i].Error = "Failed to save expense"
+		}
+		return
+	}
+	ids, err := insertExpenseBatch(tx, groupID, batch, now, userID)
+	if err == nil {
+		tx.Exec(`RELEASE SAVEPOINT batch`)
+		for n, i := range indexes {
+			results[i].ExpenseID = ids[n]
+		}
+		return
+	}
+	fmt.Println("Batch insert failed, retrying item by item:", err)
+	tx.Exec(`ROLLBACK TO SAVEPOINT batch`)
+
+	for n, i := range indexes {
+		tx.Exec(`SAVEPOINT batch_item`)
+		id, err := insertExpense(tx, groupID, batch[n], now, userID)
+		if err != nil {
+			tx.Exec(`ROLLBACK TO SAVEPOINT batch_item`)
+			fmt.Printf("Batch item %d failed: %v\n", i, err)
+			results[i].Error = "Failed to save expense"
+			continue
+		}
+		tx.Exec(`RELEASE SAVEPOINT batch_item`)
+		results[i].ExpenseID = id
+	}
+}
+
+// insertExpenseBatch is insertExpense for many expenses at once: ids are reserved up front
+// so payers, splits and revisions can all go in as multi-row INSERTs.
+func insertExpenseBatch(tx *sql.Tx, groupID any, batch []*CreateExpenseRequest, createdAt time.Time, createdBy int) ([]int, error) {
+	ids := make([]int, 0, len(batch))
+	rows, err := tx.Query(`SELECT nextval(pg_get_serial_sequence('expenses', 'id')) FROM generate_series(1, $1)`, len(batch))
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if len(ids) != len(batch) {
+		return nil, fmt.Errorf("reserved %d ids for %d expenses", len(ids), len(batch))
+	}
+
+	var expenses, payers, splits, revisions [][]any
+	var pending []int
+	statuses := make(map[bool]string) // the status only depends on the group and on claiming
+	for n, req := range batch {
+		status, ok := statuses[req.Claiming]
+		if !ok {
+			if status, err = expenseStatus(tx, groupID, req); err != nil {
+				return nil, err
+			}
+			statuses[req.Claiming] = status
+		}
+		if status == statusPending {
+			pending = append(pending, ids[n])
+		}
+
+		expenses = append(expenses, []any{ids[n], groupID, req.Amount, req.Title, req.Description, req.Category, req.Currency,
+			req.ExchangeRate, req.Tax, req.Tip, req.ServiceCharge, status, createdBy, createdAt,
+			req.Date, nullIfEmpty(req.Time), req.Timezone, req.SplitMode, req.Type})
+		for _, p := range req.Payers {
+			payers = append(payers, []any{ids[n], p.UserID, p.PaidAmount})
+		}
+		for _, s := range req.Splits {
+			splits = append(splits, []any{ids[n], s.UserID, s.Amount, splitWeight(req.SplitMode, s),
+				confirmationFor(status, s, createdBy, nil).confirmation})
+		}
+
+		// The request is already in snapshot shape, so the first revision needs no read-back
+		snapshotJSON, err := json.Marshal(req)
+		if err != nil {
+			return nil, err
+		}
+		diffJSON, err := json.Marshal(diffSnapshots(nil, req))
+		if err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, []any{ids[n], groupID, 1, revisionCreate, createdBy, string(snapshotJSON), string(diffJSON)})
+	}
+
+	err = insertRows(tx, "expenses", []string{"id", "group_id", "amount", "title", "description", "category", "currency",
+		"exchange_rate", "tax", "tip", "service_charge", "status", "created_by", "created_at",
+		"expense_date", "expense_time", "expense_timezone", "split_mode", "type"}, expenses)
+	if err != nil {
+		return nil, fmt.Errorf("inserting expenses: %w", err)
+	}
+	if err := insertRows(tx, "expense_payers", []string{"expense_id", "user_id", "paid_amount"}, payers); err != nil {
+		return nil, fmt.Errorf("inserting payers: %w", err)
+	}
+	if err := insertRows(tx, "expense_splits", []string{"expense_id", "user_id", "amount_owed", "weight", "confirmation"}, splits); err != nil {
+		return nil, fmt.Errorf("inserting splits: %w", err)
+	}
+	for _, id := range pending {
+		if _, err := settleConfirmation(tx, id); err != nil {
+			return nil, fmt.Errorf("settling confirmation: %w", err)
+		}
+	}
+	// Items need their generated ids for the shares, so they go one receipt at a time
+	for n, req := range batch {
+		if err := saveItems(tx, ids[n], req.Items); err != nil {
+			return nil, fmt.Errorf("saving items: %w", err)
+		}
+	}
+	err = insertRows(tx, "expense_revisions", []string{"expense_id", "group_id", "revision", "action", "changed_by",
+		"snapshot", "diff"}, revisions)
+	if err != nil {
+		return nil, fmt.Errorf("recording revisions: %w", err)
+	}
+	return ids, nil
+}
+
+// insertRows writes rows with multi-row INSERT statements, chunked to respect the parameter limit
+func insertRows(tx *sql.Tx, table string, columns []string, rows [][]any) error {
+	if len(rows) == 0 {
+		return nil
+	}
+	perStatement := maxBatchParams / len(columns)
+
+	for start := 0; start < len(rows); start += perStatement {
+		end := min(start+perStatement, len(rows))
+
+		var query strings.Builder
+		fmt.Fprintf(&query, "INSERT INTO %s (%s) VALUES ", table, strings.Join(columns, ", "))
+		args := make([]any, 0, (end-start)*len(columns))
+		for i, row := range rows[start:end] {
+			if i > 0 {
+				query.WriteString(", ")
+			}
+			query.WriteString("(")
+			for j, value := range row {
+				if j > 0 {
+					query.WriteString(", ")
+				}
+				args = append(args, value)
+				fmt.Fprintf(&query, "$%d", len(args))
+			}
+			query.WriteString(")")
+		}
+
+		if _, err := tx.Exec(query.String(), args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeBatchResponse(w http.ResponseWriter, status int, resp BatchExpenseResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
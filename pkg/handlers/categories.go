@@ -0,0 +1,507 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"money-splitter/pkg/db"
+	"money-splitter/pkg/middleware"
+)
+
+const defaultCategory = "General"
+
+var errUnknownCategory = errors.New("unknown category")
+
+var colorPattern = regexp.MustCompile(`^#[0-9A-Fa-f]{6}$`)
+
+type CategoryResponse struct {
+	ID           int    `json:"id"`
+	GroupID      *int   `json:"group_id"` // null for the global defaults
+	Name         string `json:"name"`
+	Icon         string `json:"icon"`
+	Color        string `json:"color"`
+	ParentID     *int   `json:"parent_id"`
+	ExpenseCount int    `json:"expense_count"`
+}
+
+type CategoryRequest struct {
+	Name     string `json:"name"`
+	Icon     string `json:"icon"`
+	Color    string `json:"color"`
+	ParentID *int   `json:"parent_id"`
+}
+
+type MergeCategoryRequest struct {
+	IntoID int `json:"into_id"`
+}
+
+type category struct {
+	ID       int
+	GroupID  *int
+	Name     string
+	ParentID *int
+}
+
+// visibleCategories returns the global defaults plus the group's own categories
+func visibleCategories(q queryer, groupID any) ([]category, error) {
+	rows, err := q.Query(`
+		SELECT id, group_id, name, parent_id
+		FROM categories
+		WHERE group_id IS NULL OR group_id = $1
+		ORDER BY group_id NULLS FIRST, id`, groupID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []category
+	for rows.Next() {
+		var c category
+		if err := rows.Scan(&c.ID, &c.GroupID, &c.Name, &c.ParentID); err != nil {
+			return nil, err
+		}
+		list = append(list, c)
+	}
+	return list, rows.Err()
+}
+
+// categoryKey folds case, punctuation and a plural "s" so "Food", "food" and "Foods" compare equal
+func categoryKey(name string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(name) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+		}
+	}
+	key := b.String()
+	if len(key) > 3 {
+		key = strings.TrimSuffix(key, "s")
+	}
+	return key
+}
+
+// resolveCategory maps a client-supplied category onto a known one for the group.
+// Empty means General; otherwise an exact (case-insensitive) match wins, then a single fuzzy match.
+func resolveCategory(q queryer, groupID any, name string) (string, error) {
+	if strings.TrimSpace(name) == "" {
+		return defaultCategory, nil
+	}
+	categories, err := visibleCategories(q, groupID)
+	if err != nil {
+		return "", err
+	}
+	return matchCategory(categories, name)
+}
+
+// matchCategory is resolveCategory against an already loaded category list
+func matchCategory(categories []category, name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return defaultCategory, nil
+	}
+
+	// Group categories come last, so they win over a global one with the same name
+	match := ""
+	for _, c := range categories {
+		if strings.EqualFold(c.Name, name) {
+			match = c.Name
+		}
+	}
+	if match != "" {
+		return match, nil
+	}
+
+	key := categoryKey(name)
+	var candidates []string
+	for _, c := range categories {
+		if key != "" && categoryKey(c.Name) == key {
+			candidates = append(candidates, c.Name)
+		}
+	}
+	if len(candidates) == 1 {
+		return candidates[0], nil
+	}
+	return "", fmt.Errorf("%w %q", errUnknownCategory, name)
+}
+
+// applyCategory resolves the request's category for the group, writing the error response when it can't.
+// Without a category the categorizer picks one.
+func applyCategory(w http.ResponseWriter, groupID any, req *CreateExpenseRequest) bool {
+	if err := autoCategory(db.DB, groupID, req); err != nil {
+		fmt.Println("Error categorizing expense:", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return false
+	}
+	category, err := resolveCategory(db.DB, groupID, req.Category)
+	if errors.Is(err, errUnknownCategory) {
+		http.Error(w, err.Error()+", create it first", http.StatusBadRequest)
+		return false
+	}
+	if err != nil {
+		fmt.Println("Error resolving category:", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return false
+	}
+	req.Category = category
+	return true
+}
+
+// validate checks a create/update request against the categories the group can already see
+func (req *CategoryRequest) validate(q queryer, groupID int, selfID int) error {
+	req.Name = strings.TrimSpace(req.Name)
+	req.Icon = strings.TrimSpace(req.Icon)
+	if req.Name == "" || len(req.Name) > 50 {
+		return errors.New("Category name must be 1-50 characters")
+	}
+	if len(req.Icon) > 32 {
+		return errors.New("Icon must be at most 32 characters")
+	}
+	if req.Color != "" && !colorPattern.MatchString(req.Color) {
+		return errors.New("Color must look like #RRGGBB")
+	}
+
+	categories, err := visibleCategories(q, groupID)
+	if err != nil {
+		return err
+	}
+	byID := make(map[int]category)
+	for _, c := range categories {
+		if c.ID != selfID && strings.EqualFold(c.Name, req.Name) {
+			return fmt.Errorf("Category %q already exists", c.Name)
+		}
+		byID[c.ID] = c
+	}
+
+	// Walk up from the new parent; reaching ourselves would make a loop
+	if req.ParentID != nil {
+		seen := make(map[int]bool)
+		for id := req.ParentID; id != nil; id = byID[*id].ParentID {
+			if _, ok := byID[*id]; !ok {
+				return errors.New("Parent category not found")
+			}
+			if *id == selfID || seen[*id] {
+				return errors.New("A category cannot be its own parent")
+			}
+			seen[*id] = true
+		}
+	}
+	return nil
+}
+
+// loadGroupCategoryForMember loads a category the caller may change: only custom ones, only by group members
+func loadGroupCategoryForMember(w http.ResponseWriter, q queryer, categoryID any, userID int) (*category, bool) {
+	var c category
+	err := q.QueryRow(`SELECT id, group_id, name, parent_id FROM categories WHERE id = $1`, categoryID).
+		Scan(&c.ID, &c.GroupID, &c.Name, &c.ParentID)
+	if err != nil {
+		http.Error(w, "Category not found", http.StatusNotFound)
+		return nil, false
+	}
+	if c.GroupID == nil {
+		http.Error(w, "Default categories cannot be changed", http.StatusForbidden)
+		return nil, false
+	}
+	if !isGroupMember(*c.GroupID, userID) {
+		http.Error(w, "Not a member of this group", http.StatusForbidden)
+		return nil, false
+	}
+	return &c, true
+}
+
+// renameCategoryUsages rewrites expenses, recurring and expense templates, category rules and learned
+// corrections of a group from one category name to another, and remembers the rename for reverts.
+// Each expense gets a revision; trashed ones are rewritten too so they restore into an existing
+// category, and the restore records the state it brings back.
+func renameCategoryUsages(tx *sql.Tx, groupID int, from, to string, userID int) error {
+	rows, err := tx.Query(`
+		UPDATE expenses SET category = $3, version = version + 1
+		WHERE group_id = $1 AND LOWER(category) = LOWER($2)
+		RETURNING id, deleted_at IS NULL`, groupID, from, to)
+	if err != nil {
+		return err
+	}
+	var live []int
+	for rows.Next() {
+		var id int
+		var active bool
+		if err := rows.Scan(&id, &active); err != nil {
+			rows.Close()
+			return err
+		}
+		if active {
+			live = append(live, id)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, id := range live {
+		after, err := loadExpenseSnapshot(tx, id, false)
+		if err != nil {
+			return err
+		}
+		before := *after
+		before.Category = from
+		if err := recordRevision(tx, id, revisionRecategorize, userID, &before, after); err != nil {
+			return fmt.Errorf("recording revision: %w", err)
+		}
+	}
+
+	_, err = tx.Exec(`INSERT INTO category_renames (group_id, from_name, to_name) VALUES ($1, $2, $3)`, groupID, from, to)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+		UPDATE recurring_expenses
+		SET template = jsonb_set(template, '{category}', to_jsonb($3::text))
+		WHERE group_id = $1 AND LOWER(template->>'category') = LOWER($2)`, groupID, from, to)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+		UPDATE expense_templates
+		SET template = jsonb_set(template, '{category}', to_jsonb($3::text))
+		WHERE group_id = $1 AND LOWER(template->>'category') = LOWER($2)`, groupID, from, to)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`UPDATE category_rules SET category = $3 WHERE group_id = $1 AND LOWER(category) = LOWER($2)`, groupID, from, to)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`UPDATE category_corrections SET category = $3 WHERE group_id = $1 AND LOWER(category) = LOWER($2)`, groupID, from, to)
+	return err
+}
+
+// renamedCategory follows the renames and merges made to a category since a point in time,
+// returning the name it has now ("" when it was never renamed)
+func renamedCategory(q queryer, groupID int, name string, since time.Time) (string, error) {
+	current, lastID := "", 0
+	for {
+		var id int
+		var next string
+		err := q.QueryRow(`
+			SELECT id, to_name FROM category_renames
+			WHERE group_id = $1 AND LOWER(from_name) = LOWER($2) AND renamed_at >= $3 AND id > $4
+			ORDER BY id
+			LIMIT 1`, groupID, name, since, lastID).Scan(&id, &next)
+		if errors.Is(err, sql.ErrNoRows) {
+			return current, nil
+		}
+		if err != nil {
+			return "", err
+		}
+		current, name, lastID = next, next, id
+	}
+}
+
+// --- HANDLERS ---
+
+func GetGroupCategories(w http.ResponseWriter, r *http.Request) {
+	groupID := r.PathValue("id")
+	userID := r.Context().Value(middleware.UserIDKey).(int)
+
+	if !isGroupMember(groupID, userID) {
+		http.Error(w, "Not a member of this group", http.StatusForbidden)
+		return
+	}
+
+	rows, err := db.DB.Query(`
+		SELECT c.id, c.group_id, c.name, c.icon, c.color, c.parent_id,
+		       (SELECT COUNT(*) FROM expenses e
+		        WHERE e.group_id = $1 AND e.deleted_at IS NULL AND LOWER(e.category) = LOWER(c.name))
+		FROM categories c
+		WHERE c.group_id IS NULL OR c.group_id = $1
+		ORDER BY c.group_id NULLS FIRST, c.name`, groupID)
+	if err != nil {
+		fmt.Println("Error fetching categories:", err)
+		http.Error(w, "Failed to fetch categories", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	list := []CategoryResponse{}
+	for rows.Next() {
+		var c CategoryResponse
+		if err := rows.Scan(&c.ID, &c.GroupID, &c.Name, &c.Icon, &c.Color, &c.ParentID, &c.ExpenseCount); err != nil {
+			continue
+		}
+		list = append(list, c)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+func CreateCategory(w http.ResponseWriter, r *http.Request) {
+	groupID := r.PathValue("id")
+	userID := r.Context().Value(middleware.UserIDKey).(int)
+
+	if !isGroupMember(groupID, userID) {
+		http.Error(w, "Not a member of this group", http.StatusForbidden)
+		return
+	}
+
+	var req CategoryRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	gid, err := strconv.Atoi(groupID)
+	if err != nil {
+		http.Error(w, "Group not found", http.StatusNotFound)
+		return
+	}
+	if err := req.validate(db.DB, gid, 0); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var c CategoryResponse
+	err = db.DB.QueryRow(`
+		INSERT INTO categories (group_id, name, icon, color, parent_id)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, group_id, name, icon, color, parent_id`, gid, req.Name, req.Icon, req.Color, req.ParentID).
+		Scan(&c.ID, &c.GroupID, &c.Name, &c.Icon, &c.Color, &c.ParentID)
+	if err != nil {
+		// The unique index catches a concurrent create with the same name
+		fmt.Println("Error creating category:", err)
+		http.Error(w, "Category already exists", http.StatusConflict)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(c)
+}
+
+// UpdateCategory edits a custom category; a rename is applied to every expense using it
+func UpdateCategory(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(middleware.UserIDKey).(int)
+
+	var req CategoryRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	current, ok := loadGroupCategoryForMember(w, tx, r.PathValue("id"), userID)
+	if !ok {
+		return
+	}
+	if err := req.validate(tx, *current.GroupID, current.ID); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var c CategoryResponse
+	err = tx.QueryRow(`
+		UPDATE categories SET name = $1, icon = $2, color = $3, parent_id = $4
+		WHERE id = $5
+		RETURNING id, group_id, name, icon, color, parent_id`, req.Name, req.Icon, req.Color, req.ParentID, current.ID).
+		Scan(&c.ID, &c.GroupID, &c.Name, &c.Icon, &c.Color, &c.ParentID)
+	if err != nil {
+		fmt.Println("Error updating category:", err)
+		http.Error(w, "Category already exists", http.StatusConflict)
+		return
+	}
+	if c.Name != current.Name {
+		if err := renameCategoryUsages(tx, *current.GroupID, current.Name, c.Name, userID); err != nil {
+			fmt.Println("Error renaming category usages:", err)
+			http.Error(w, "Failed to rename category", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(c)
+}
+
+// MergeCategory moves every expense (and sub-category) of a custom category into another one, then removes it
+func MergeCategory(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(middleware.UserIDKey).(int)
+
+	var req MergeCategoryRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.IntoID == 0 {
+		http.Error(w, "into_id is required", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	source, ok := loadGroupCategoryForMember(w, tx, r.PathValue("id"), userID)
+	if !ok {
+		return
+	}
+	if source.ID == req.IntoID {
+		http.Error(w, "Cannot merge a category into itself", http.StatusBadRequest)
+		return
+	}
+
+	var target category
+	err = tx.QueryRow(`
+		SELECT id, group_id, name, parent_id FROM categories
+		WHERE id = $1 AND (group_id IS NULL OR group_id = $2)`, req.IntoID, *source.GroupID).
+		Scan(&target.ID, &target.GroupID, &target.Name, &target.ParentID)
+	if err != nil {
+		http.Error(w, "Target category not found", http.StatusNotFound)
+		return
+	}
+
+	if err := renameCategoryUsages(tx, *source.GroupID, source.Name, target.Name, userID); err != nil {
+		fmt.Println("Error merging category usages:", err)
+		http.Error(w, "Failed to merge category", http.StatusInternalServerError)
+		return
+	}
+
+	// Children move under the target; if the target was one of them it takes the source's place instead
+	_, err = tx.Exec(`UPDATE categories SET parent_id = $3 WHERE id = $2 AND parent_id = $1`, source.ID, target.ID, source.ParentID)
+	if err == nil {
+		_, err = tx.Exec(`UPDATE categories SET parent_id = $2 WHERE parent_id = $1`, source.ID, target.ID)
+	}
+	if err == nil {
+		_, err = tx.Exec(`DELETE FROM categories WHERE id = $1`, source.ID)
+	}
+	if err != nil {
+		fmt.Println("Error merging category:", err)
+		http.Error(w, "Failed to merge category", http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"message": "Category merged",
+		"into":    target.Name,
+	})
+}
@@ -0,0 +1,464 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"unicode"
+
+	"money-splitter/pkg/db"
+	"money-splitter/pkg/middleware"
+)
+
+const (
+	ruleKeyword  = "keyword"  // whole words anywhere in the title or description, a plural "s" is fine
+	ruleMerchant = "merchant" // a shop or brand name, spaces and punctuation ignored ("McDonald's" = "mcdonalds")
+)
+
+// Where an automatic category comes from, best first: the group's own corrections, its rules, then the defaults.
+// Merchant rules are more specific than keywords, so they win at the same level.
+const (
+	rankLearnedTitle = iota
+	rankLearnedPhrase
+	rankGroupMerchant
+	rankGroupKeyword
+	rankDefaultMerchant
+	rankDefaultKeyword
+)
+
+type CategoryRuleRequest struct {
+	MatchType string `json:"match_type"` // keyword (default) or merchant
+	Pattern   string `json:"pattern"`
+	Category  string `json:"category"`
+}
+
+type CategoryRuleResponse struct {
+	ID        int    `json:"id"`
+	GroupID   *int   `json:"group_id"` // null for the global defaults
+	MatchType string `json:"match_type"`
+	Pattern   string `json:"pattern"`
+	Category  string `json:"category"`
+}
+
+type CategorizeRequest struct {
+	Title       string `json:"title"`
+	Description string `json:"description"`
+}
+
+// CategoryMatch explains why a category was picked
+type CategoryMatch struct {
+	Category  string `json:"category"`
+	Source    string `json:"source"`     // learned, group or default
+	MatchType string `json:"match_type"` // keyword, merchant, or title for a learned correction
+	Pattern   string `json:"pattern"`
+	RuleID    int    `json:"rule_id,omitempty"`
+	rank      int
+}
+
+type CategorizeResponse struct {
+	Category string          `json:"category"`
+	Match    *CategoryMatch  `json:"match"`   // null when nothing matched and General is used
+	Matches  []CategoryMatch `json:"matches"` // everything that matched, best first
+}
+
+type categoryCorrection struct {
+	key      string
+	category string
+}
+
+// categorizer holds a group's rules and learned corrections
+type categorizer struct {
+	categories  []category
+	rules       []CategoryRuleResponse
+	corrections []categoryCorrection
+}
+
+func loadCategorizer(q queryer, groupID any) (*categorizer, error) {
+	c := &categorizer{}
+	var err error
+	if c.categories, err = visibleCategories(q, groupID); err != nil {
+		return nil, err
+	}
+
+	rows, err := q.Query(`
+		SELECT id, group_id, match_type, pattern, category
+		FROM category_rules
+		WHERE group_id IS NULL OR group_id = $1
+		ORDER BY id`, groupID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var rule CategoryRuleResponse
+		if err := rows.Scan(&rule.ID, &rule.GroupID, &rule.MatchType, &rule.Pattern, &rule.Category); err != nil {
+			return nil, err
+		}
+		c.rules = append(c.rules, rule)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = q.Query(`SELECT title_key, category FROM category_corrections WHERE group_id = $1 ORDER BY updated_at DESC`, groupID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var correction categoryCorrection
+		if err := rows.Scan(&correction.key, &correction.category); err != nil {
+			return nil, err
+		}
+		c.corrections = append(c.corrections, correction)
+	}
+	return c, rows.Err()
+}
+
+// matches lists every correction and rule that fits the expense, best first
+func (c *categorizer) matches(title, description string) []CategoryMatch {
+	titleWords := categorizerWords(title)
+	words := append(categorizerWords(title), categorizerWords(description)...)
+	key := titleKey(title)
+
+	var found []CategoryMatch
+	add := func(m CategoryMatch) {
+		// Rules can outlive a renamed or removed category; those are skipped
+		name, err := matchCategory(c.categories, m.Category)
+		if err != nil {
+			return
+		}
+		m.Category = name
+		found = append(found, m)
+	}
+
+	for _, correction := range c.corrections {
+		switch {
+		case correction.key == key:
+			add(CategoryMatch{Category: correction.category, Source: "learned", MatchType: "title", Pattern: correction.key, rank: rankLearnedTitle})
+		case containsPhrase(titleWords, strings.Fields(correction.key), false):
+			add(CategoryMatch{Category: correction.category, Source: "learned", MatchType: "title", Pattern: correction.key, rank: rankLearnedPhrase})
+		}
+	}
+
+	for _, rule := range c.rules {
+		matched := false
+		pattern := categorizerWords(rule.Pattern)
+		if rule.MatchType == ruleMerchant {
+			matched = containsMerchant(words, strings.Join(pattern, ""))
+		} else {
+			matched = containsPhrase(words, pattern, true)
+		}
+		if !matched {
+			continue
+		}
+
+		m := CategoryMatch{Category: rule.Category, Source: "default", MatchType: rule.MatchType, Pattern: rule.Pattern, RuleID: rule.ID}
+		switch {
+		case rule.GroupID != nil && rule.MatchType == ruleMerchant:
+			m.Source, m.rank = "group", rankGroupMerchant
+		case rule.GroupID != nil:
+			m.Source, m.rank = "group", rankGroupKeyword
+		case rule.MatchType == ruleMerchant:
+			m.rank = rankDefaultMerchant
+		default:
+			m.rank = rankDefaultKeyword
+		}
+		add(m)
+	}
+
+	// The longer pattern is the more specific one ("Uber Eats" over "Uber")
+	sort.SliceStable(found, func(i, j int) bool {
+		if found[i].rank != found[j].rank {
+			return found[i].rank < found[j].rank
+		}
+		return len(found[i].Pattern) > len(found[j].Pattern)
+	})
+	return found
+}
+
+// categorize picks the category for an expense saved without one; General when nothing matches
+func (c *categorizer) categorize(title, description string) (string, *CategoryMatch) {
+	if found := c.matches(title, description); len(found) > 0 {
+		return found[0].Category, &found[0]
+	}
+	return defaultCategory, nil
+}
+
+// autoCategory fills in the category of a request that doesn't name one
+func autoCategory(q queryer, groupID any, req *CreateExpenseRequest) error {
+	if strings.TrimSpace(req.Category) != "" {
+		return nil
+	}
+	c, err := loadCategorizer(q, groupID)
+	if err != nil {
+		return err
+	}
+	req.Category, _ = c.categorize(req.Title, req.Description)
+	return nil
+}
+
+// categorizerWords lower-cases text and splits it into words; apostrophes are dropped ("joe's" -> "joes")
+func categorizerWords(text string) []string {
+	text = strings.NewReplacer("'", "", "’", "").Replace(strings.ToLower(text))
+	return strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// titleKey is how corrections remember a title: its words without numbers ("Uber 12/03" -> "uber")
+func titleKey(title string) string {
+	var words []string
+	for _, word := range categorizerWords(title) {
+		if strings.IndexFunc(word, unicode.IsLetter) >= 0 {
+			words = append(words, word)
+		}
+	}
+	key := []rune(strings.Join(words, " "))
+	if len(key) > 200 {
+		key = key[:200]
+	}
+	return string(key)
+}
+
+// containsPhrase reports whether the words appear in order in text; plural allows "tickets" for "ticket"
+func containsPhrase(text, phrase []string, plural bool) bool {
+	if len(phrase) == 0 {
+		return false
+	}
+	last := len(phrase) - 1
+	for i := 0; i+len(phrase) <= len(text); i++ {
+		ok := true
+		for j, word := range phrase {
+			got := text[i+j]
+			if got == word || (plural && j == last && (got == word+"s" || got == word+"es")) {
+				continue
+			}
+			ok = false
+			break
+		}
+		if ok {
+			return true
+		}
+	}
+	return false
+}
+
+// containsMerchant looks for the merchant name starting at a word boundary, ignoring the spaces
+// between words, so "Whole Foods", "wholefoods" and "WHOLE-FOODS" all match
+func containsMerchant(text []string, merchant string) bool {
+	if merchant == "" {
+		return false
+	}
+	joined := strings.Join(text, "")
+	offset := 0
+	for _, word := range text {
+		// The name has to end where a word ends: "bolt" is not "boltzmann"
+		if strings.HasPrefix(joined[offset:], merchant) && wordEnds(text, offset+len(merchant)) {
+			return true
+		}
+		offset += len(word)
+	}
+	return false
+}
+
+// wordEnds reports whether a word of text ends at byte offset end of the joined words
+func wordEnds(text []string, end int) bool {
+	pos := 0
+	for _, word := range text {
+		pos += len(word)
+		if pos == end {
+			return true
+		}
+		if pos > end {
+			return false
+		}
+	}
+	return false
+}
+
+// learnCategoryCorrection remembers that a member moved an expense to another category by hand,
+// so the next expense with the same title gets it automatically. Must run before the update.
+func learnCategoryCorrection(tx *sql.Tx, groupID, expenseID any, title, newCategory string) error {
+	var current string
+	if err := tx.QueryRow(`SELECT category FROM expenses WHERE id = $1`, expenseID).Scan(&current); err != nil {
+		return err
+	}
+	key := titleKey(title)
+	if key == "" || strings.EqualFold(current, newCategory) {
+		return nil
+	}
+	_, err := tx.Exec(`
+		INSERT INTO category_corrections (group_id, title_key, category)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (group_id, title_key) DO UPDATE
+		SET times = CASE WHEN category_corrections.category = EXCLUDED.category THEN category_corrections.times + 1 ELSE 1 END,
+		    category = EXCLUDED.category,
+		    updated_at = CURRENT_TIMESTAMP`, groupID, key, newCategory)
+	return err
+}
+
+// validate checks a new rule and resolves its category for the group
+func (req *CategoryRuleRequest) validate(groupID any) error {
+	req.Pattern = strings.TrimSpace(req.Pattern)
+	if req.MatchType == "" {
+		req.MatchType = ruleKeyword
+	}
+	if req.MatchType != ruleKeyword && req.MatchType != ruleMerchant {
+		return errors.New("match_type must be keyword or merchant")
+	}
+	if len(req.Pattern) > 100 || len(categorizerWords(req.Pattern)) == 0 {
+		return errors.New("Pattern must be 1-100 characters with at least one letter or digit")
+	}
+	if strings.TrimSpace(req.Category) == "" {
+		return errors.New("category is required")
+	}
+	category, err := resolveCategory(db.DB, groupID, req.Category)
+	if err != nil {
+		return err
+	}
+	req.Category = category
+	return nil
+}
+
+// --- HANDLERS ---
+
+// GetCategoryRules lists the group's rules followed by the defaults
+func GetCategoryRules(w http.ResponseWriter, r *http.Request) {
+	groupID := r.PathValue("id")
+	userID := r.Context().Value(middleware.UserIDKey).(int)
+
+	if !isGroupMember(groupID, userID) {
+		http.Error(w, "Not a member of this group", http.StatusForbidden)
+		return
+	}
+
+	rows, err := db.DB.Query(`
+		SELECT id, group_id, match_type, pattern, category
+		FROM category_rules
+		WHERE group_id IS NULL OR group_id = $1
+		ORDER BY group_id NULLS LAST, match_type, LOWER(pattern)`, groupID)
+	if err != nil {
+		fmt.Println("Error fetching category rules:", err)
+		http.Error(w, "Failed to fetch rules", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	list := []CategoryRuleResponse{}
+	for rows.Next() {
+		var rule CategoryRuleResponse
+		if err := rows.Scan(&rule.ID, &rule.GroupID, &rule.MatchType, &rule.Pattern, &rule.Category); err != nil {
+			continue
+		}
+		list = append(list, rule)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+func CreateCategoryRule(w http.ResponseWriter, r *http.Request) {
+	groupID := r.PathValue("id")
+	userID := r.Context().Value(middleware.UserIDKey).(int)
+
+	if !isGroupMember(groupID, userID) {
+		http.Error(w, "Not a member of this group", http.StatusForbidden)
+		return
+	}
+
+	var req CategoryRuleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	if err := req.validate(groupID); err != nil {
+		if errors.Is(err, errUnknownCategory) {
+			http.Error(w, err.Error()+", create it first", http.StatusBadRequest)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var rule CategoryRuleResponse
+	err := db.DB.QueryRow(`
+		INSERT INTO category_rules (group_id, match_type, pattern, category, created_by)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, group_id, match_type, pattern, category`, groupID, req.MatchType, req.Pattern, req.Category, userID).
+		Scan(&rule.ID, &rule.GroupID, &rule.MatchType, &rule.Pattern, &rule.Category)
+	if err != nil {
+		// The unique index catches the same pattern twice
+		fmt.Println("Error creating category rule:", err)
+		http.Error(w, "Rule already exists", http.StatusConflict)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(rule)
+}
+
+// DeleteCategoryRule removes one of the group's rules; the defaults can't be removed
+func DeleteCategoryRule(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(middleware.UserIDKey).(int)
+
+	var groupID *int
+	err := db.DB.QueryRow(`SELECT group_id FROM category_rules WHERE id = $1`, r.PathValue("id")).Scan(&groupID)
+	if err != nil {
+		http.Error(w, "Rule not found", http.StatusNotFound)
+		return
+	}
+	if groupID == nil {
+		http.Error(w, "Default rules cannot be changed", http.StatusForbidden)
+		return
+	}
+	if !isGroupMember(*groupID, userID) {
+		http.Error(w, "Not a member of this group", http.StatusForbidden)
+		return
+	}
+
+	if _, err := db.DB.Exec(`DELETE FROM category_rules WHERE id = $1`, r.PathValue("id")); err != nil {
+		http.Error(w, "Failed to delete rule", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Rule deleted"})
+}
+
+// TestCategorize shows the category an expense would get and which rule or correction decided it
+func TestCategorize(w http.ResponseWriter, r *http.Request) {
+	groupID := r.PathValue("id")
+	userID := r.Context().Value(middleware.UserIDKey).(int)
+
+	if !isGroupMember(groupID, userID) {
+		http.Error(w, "Not a member of this group", http.StatusForbidden)
+		return
+	}
+
+	var req CategorizeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	c, err := loadCategorizer(db.DB, groupID)
+	if err != nil {
+		fmt.Println("Error loading categorizer:", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+	resp := CategorizeResponse{Matches: c.matches(req.Title, req.Description)}
+	resp.Category, resp.Match = c.categorize(req.Title, req.Description)
+	if resp.Matches == nil {
+		resp.Matches = []CategoryMatch{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestContainsPhrase(t *testing.T) {
+	tests := []struct {
+		text, phrase string
+		plural       bool
+		want         bool
+	}{
+		{"Train ticket to Lyon", "train ticket", false, true},
+		{"Train tickets to Lyon", "train ticket", false, false},
+		{"Train tickets to Lyon", "train ticket", true, true},
+		{"Two train ticket", "train ticket", true, true},
+		{"Bus passes", "bus pass", true, true},
+		{"Ticket train", "train ticket", true, false},
+		{"Train and ticket", "train ticket", true, false},
+		{"Trainticket", "train ticket", true, false},
+		{"Trains ticket", "train ticket", true, false},
+		{"Gas", "gas", false, true},
+		{"Gasoline", "gas", true, false},
+		{"Gas", "", false, false},
+		{"", "gas", true, false},
+		{"Joe's Pizza", "joes pizza", false, true},
+	}
+	for _, tt := range tests {
+		if got := containsPhrase(categorizerWords(tt.text), strings.Fields(tt.phrase), tt.plural); got != tt.want {
+			t.Errorf("containsPhrase(%q, %q, %v) = %v, want %v", tt.text, tt.phrase, tt.plural, got, tt.want)
+		}
+	}
+}
+
+func TestContainsMerchant(t *testing.T) {
+	tests := []struct {
+		text, merchant string
+		want           bool
+	}{
+		{"Whole Foods Market", "wholefoods", true},
+		{"wholefoods", "wholefoods", true},
+		{"WHOLE-FOODS run", "wholefoods", true},
+		{"Lunch at McDonald's", "mcdonalds", true},
+		{"Groceries Whole Foods", "wholefoods", true},
+		{"Wholefoodsmarket", "wholefoods", false},
+		{"Bolt ride", "bolt", true},
+		{"Boltzmann lecture", "bolt", false},
+		{"Thunderbolt", "bolt", false},
+		{"Hole foods", "wholefoods", false},
+		{"Uber 12/03", "uber", true},
+		{"Anything", "", false},
+		{"", "bolt", false},
+	}
+	for _, tt := range tests {
+		if got := containsMerchant(categorizerWords(tt.text), tt.merchant); got != tt.want {
+			t.Errorf("containsMerchant(%q, %q) = %v, want %v", tt.text, tt.merchant, got, tt.want)
+		}
+	}
+}
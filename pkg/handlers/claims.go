@@ -0,0 +1,313 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+
+	"money-splitter/pkg/db"
+	"money-splitter/pkg/middleware"
+)
+
+type ClaimItem struct {
+	ExpenseItem
+	Unclaimed bool `json:"unclaimed"`
+}
+
+type ClaimsResponse struct {
+	ExpenseID      int         `json:"expense_id"`
+	Status         string      `json:"status"`
+	Items          []ClaimItem `json:"items"`
+	UnclaimedCount int         `json:"unclaimed_count"`
+}
+
+// GetExpenseClaims shows who claimed what on a draft receipt and flags unclaimed items
+func GetExpenseClaims(w http.ResponseWriter, r *http.Request) {
+	expenseID := r.PathValue("id")
+	userID := r.Context().Value(middleware.UserIDKey).(int)
+
+	groupID, err := expenseGroupID(expenseID)
+	if err != nil {
+		http.Error(w, "Expense not found", http.StatusNotFound)
+		return
+	}
+	if !isGroupMember(groupID, userID) {
+		http.Error(w, "Not a member of this group", http.StatusForbidden)
+		return
+	}
+
+	var resp ClaimsResponse
+	err = db.DB.QueryRow(`SELECT id, status FROM expenses WHERE id = $1 AND deleted_at IS NULL`, expenseID).Scan(&resp.ExpenseID, &resp.Status)
+	if err != nil {
+		http.Error(w, "Expense not found", http.StatusNotFound)
+		return
+	}
+
+	items, err := loadItems(db.DB, expenseID)
+	if err != nil {
+		fmt.Println("Error fetching items:", err)
+		http.Error(w, "Failed to fetch items", http.StatusInternalServerError)
+		return
+	}
+
+	resp.Items = []ClaimItem{}
+	for _, item := range items {
+		unclaimed := len(item.SharedBy) == 0
+		if unclaimed {
+			resp.UnclaimedCount++
+		}
+		resp.Items = append(resp.Items, ClaimItem{ExpenseItem: item, Unclaimed: unclaimed})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// ClaimExpenseItem adds the caller to the sharers of a receipt item. Several members can claim the same item.
+func ClaimExpenseItem(w http.ResponseWriter, r *http.Request) {
+	changeClaim(w, r, true)
+}
+
+// UnclaimExpenseItem removes the caller from the sharers of a receipt item
+func UnclaimExpenseItem(w http.ResponseWriter, r *http.Request) {
+	changeClaim(w, r, false)
+}
+
+func changeClaim(w http.ResponseWriter, r *http.Request, claim bool) {
+	expenseID := r.PathValue("id")
+	itemID := r.PathValue("itemId")
+	userID := r.Context().Value(middleware.UserIDKey).(int)
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		http.Error(w, "Server Error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	// 1. The item must belong to a draft the caller can see.
+	// FOR SHARE keeps a concurrent finalize from swallowing this claim.
+	var groupID int
+	var status string
+	err = tx.QueryRow(`
+		SELECT e.group_id, e.status
+		FROM expense_items i
+		JOIN expenses e ON i.expense_id = e.id
+		WHERE i.id = $1 AND e.id = $2 AND e.deleted_at IS NULL
+		FOR SHARE OF e`, itemID, expenseID).Scan(&groupID, &status)
+	if err != nil {
+		http.Error(w, "Item not found", http.StatusNotFound)
+		return
+	}
+	if !isGroupMember(groupID, userID) {
+		http.Error(w, "Not a member of this group", http.StatusForbidden)
+		return
+	}
+	if status != statusClaiming {
+		http.Error(w, "Claiming is closed for this expense", http.StatusConflict)
+		return
+	}
+
+	// 2. Add or remove the claim
+	if claim {
+		_, err = tx.Exec(`INSERT INTO expense_item_shares (item_id, user_id) VALUES ($1, $2) ON CONFLICT DO NOTHING`, itemID, userID)
+	} else {
+		_, err = tx.Exec(`DELETE FROM expense_item_shares WHERE item_id = $1 AND user_id = $2`, itemID, userID)
+	}
+	if err != nil {
+		fmt.Println("Error updating claim:", err)
+		http.Error(w, "Failed to update claim", http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
+		return
+	}
+
+	message := "Item claimed"
+	if !claim {
+		message = "Claim removed"
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": message})
+}
+
+// FinalizeExpenseClaims turns the claims on a draft into expense_splits.
+// Any member can finalize once every item is claimed; a payer can close claiming early,
+// in which case unclaimed items are shared by everyone who claimed something
+// (or by the payers, if nobody did).
+func FinalizeExpenseClaims(w http.ResponseWriter, r *http.Request) {
+	expenseID := r.PathValue("id")
+	userID := r.Context().Value(middleware.UserIDKey).(int)
+
+	groupID, err := expenseGroupID(expenseID)
+	if err != nil {
+		http.Error(w, "Expense not found", http.StatusNotFound)
+		return
+	}
+	if !isGroupMember(groupID, userID) {
+		http.Error(w, "Not a member of this group", http.StatusForbidden)
+		return
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		http.Error(w, "Server Error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	// 1. Lock the draft so concurrent claims/finalizes serialize
+	var status string
+	var tax, tip, serviceCharge float64
+	err = tx.QueryRow(`SELECT status, tax, tip, service_charge FROM expenses WHERE id = $1 AND deleted_at IS NULL FOR UPDATE`, expenseID).
+		Scan(&status, &tax, &tip, &serviceCharge)
+	if err != nil {
+		http.Error(w, "Expense not found", http.StatusNotFound)
+		return
+	}
+	if status != statusClaiming {
+		http.Error(w, "Expense is not open for claiming", http.StatusConflict)
+		return
+	}
+
+	before, err := loadExpenseSnapshot(tx, expenseID, false)
+	if err != nil {
+		http.Error(w, "Failed to fetch expense", http.StatusInternalServerError)
+		return
+	}
+
+	items, err := loadItems(tx, expenseID)
+	if err != nil {
+		fmt.Println("Error fetching items:", err)
+		http.Error(w, "Failed to fetch items", http.StatusInternalServerError)
+		return
+	}
+
+	payers, err := expensePayerIDs(tx, expenseID)
+	if err != nil {
+		http.Error(w, "Failed to fetch payers", http.StatusInternalServerError)
+		return
+	}
+
+	// 2. Deal with unclaimed items
+	var claimants []int
+	var unclaimed []string
+	for _, item := range items {
+		if len(item.SharedBy) == 0 {
+			unclaimed = append(unclaimed, item.Name)
+		}
+		for _, uid := range item.SharedBy {
+			if !slices.Contains(claimants, uid) {
+				claimants = append(claimants, uid)
+			}
+		}
+	}
+
+	if len(unclaimed) > 0 {
+		if !slices.Contains(payers, userID) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(map[string]any{
+				"message":   "Some items are still unclaimed; only a payer can close claiming",
+				"unclaimed": unclaimed,
+			})
+			return
+		}
+
+		fallback := claimants
+		if len(fallback) == 0 {
+			fallback = payers
+		}
+		for i := range items {
+			if len(items[i].SharedBy) == 0 {
+				items[i].SharedBy = fallback
+			}
+		}
+	}
+
+	// 3. Generate the splits
+	splits, _, err := itemizedSplits(items, tax+tip+serviceCharge)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := saveItems(tx, expenseID, items); err != nil {
+		fmt.Println("Error saving items:", err)
+		http.Error(w, "Failed to save items", http.StatusInternalServerError)
+		return
+	}
+
+	if _, err := tx.Exec(`DELETE FROM expense_splits WHERE expense_id = $1`, expenseID); err != nil {
+		http.Error(w, "Failed to clear old splits", http.StatusInternalServerError)
+		return
+	}
+	// In groups that require confirmation the finished receipt still has to be accepted by everyone
+	newStatus, err := expenseStatus(tx, groupID, &CreateExpenseRequest{})
+	if err != nil {
+		http.Error(w, "Failed to finalize expense", http.StatusInternalServerError)
+		return
+	}
+	for _, split := range splits {
+		_, err := tx.Exec(`INSERT INTO expense_splits (expense_id, user_id, amount_owed, confirmation) VALUES ($1, $2, $3, $4)`,
+			expenseID, split.UserID, split.Amount, confirmationFor(newStatus, split, userID, nil).confirmation)
+		if err != nil {
+			fmt.Println("Error inserting split:", err)
+			http.Error(w, "Failed to save splits", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if _, err := tx.Exec(`UPDATE expenses SET status = $1, version = version + 1 WHERE id = $2`, newStatus, expenseID); err != nil {
+		http.Error(w, "Failed to finalize expense", http.StatusInternalServerError)
+		return
+	}
+	if newStatus == statusPending {
+		if _, err := settleConfirmation(tx, expenseID); err != nil {
+			http.Error(w, "Failed to finalize expense", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	after, err := loadExpenseSnapshot(tx, expenseID, false)
+	if err == nil {
+		err = recordRevision(tx, expenseID, revisionFinalize, userID, before, after)
+	}
+	if err != nil {
+		fmt.Println("Error recording revision:", err)
+		http.Error(w, "Failed to finalize expense", http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"message": "Expense finalized",
+		"splits":  splits,
+	})
+}
+
+// expensePayerIDs lists who paid for an expense
+func expensePayerIDs(q queryer, expenseID any) ([]int, error) {
+	rows, err := q.Query(`SELECT user_id FROM expense_payers WHERE expense_id = $1 ORDER BY user_id`, expenseID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
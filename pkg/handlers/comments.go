@@ -0,0 +1,454 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"money-splitter/pkg/db"
+	"money-splitter/pkg/middleware"
+
+	"github.com/lib/pq"
+)
+
+const (
+	maxCommentLength    = 2000
+	defaultCommentLimit = 20
+	maxCommentLimit     = 100
+)
+
+type CommentRequest struct {
+	Body string `json:"body"`
+}
+
+type CommentMention struct {
+	UserID int    `json:"user_id"`
+	Name   string `json:"name"`
+}
+
+type CommentResponse struct {
+	ID         int              `json:"id"`
+	ExpenseID  int              `json:"expense_id"`
+	AuthorID   int              `json:"author_id"`
+	AuthorName string           `json:"author_name"`
+	Body       string           `json:"body"`
+	Mentions   []CommentMention `json:"mentions"`
+	CreatedAt  string           `json:"created_at"`
+	UpdatedAt  *string          `json:"updated_at"`
+}
+
+type CommentListResponse struct {
+	Comments []CommentResponse `json:"comments"`
+	Total    int               `json:"total"`
+	Limit    int               `json:"limit"`
+	Offset   int               `json:"offset"`
+}
+
+// --- HANDLERS ---
+
+// GetExpenseComments lists a thread oldest first: GET /expenses/{id}/comments?limit=20&offset=0
+func GetExpenseComments(w http.ResponseWriter, r *http.Request) {
+	expenseID := r.PathValue("id")
+	userID := r.Context().Value(middleware.UserIDKey).(int)
+
+	if _, ok := requireExpenseMember(w, expenseID, userID); !ok {
+		return
+	}
+
+	limit, offset := pageParams(r, defaultCommentLimit, maxCommentLimit)
+
+	resp := CommentListResponse{Comments: []CommentResponse{}, Limit: limit, Offset: offset}
+	err := db.DB.QueryRow(`SELECT COUNT(*) FROM expense_comments WHERE expense_id = $1`, expenseID).Scan(&resp.Total)
+	if err != nil {
+		fmt.Println("Error counting comments:", err)
+		http.Error(w, "Failed to fetch comments", http.StatusInternalServerError)
+		return
+	}
+
+	rows, err := db.DB.Query(`
+		SELECT `+commentColumns+`
+		FROM expense_comments c
+		JOIN users u ON c.author_id = u.id
+		WHERE c.expense_id = $1
+		ORDER BY c.created_at, c.id
+		LIMIT $2 OFFSET $3`, expenseID, limit, offset)
+	if err != nil {
+		http.Error(w, "Failed to fetch comments", http.StatusInternalServerError)
+		return
+	}
+	for rows.Next() {
+		c, err := scanComment(rows)
+		if err != nil {
+			continue
+		}
+		resp.Comments = append(resp.Comments, *c)
+	}
+	rows.Close()
+
+	// All mentions of the page in one query rather than one per comment
+	if err := loadMentions(resp.Comments); err != nil {
+		fmt.Println("Error fetching comment mentions:", err)
+		http.Error(w, "Failed to fetch comments", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func CreateComment(w http.ResponseWriter, r *http.Request) {
+	expenseID := r.PathValue("id")
+	userID := r.Context().Value(middleware.UserIDKey).(int)
+
+	groupID, ok := requireExpenseMember(w, expenseID, userID)
+	if !ok {
+		return
+	}
+
+	body, ok := decodeCommentBody(w, r)
+	if !ok {
+		return
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		http.Error(w, "Server Error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	var commentID int
+	err = tx.QueryRow(`INSERT INTO expense_comments (expense_id, author_id, body) VALUES ($1, $2, $3) RETURNING id`,
+		expenseID, userID, body).Scan(&commentID)
+	if err != nil {
+		fmt.Println("Error inserting comment:", err)
+		http.Error(w, "Failed to save comment", http.StatusInternalServerError)
+		return
+	}
+
+	if err := saveMentions(tx, commentID, groupID, body); err != nil {
+		fmt.Println("Error saving mentions:", err)
+		http.Error(w, "Failed to save comment", http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
+		return
+	}
+
+	writeComment(w, commentID, http.StatusCreated)
+}
+
+// UpdateComment lets the author edit their comment; mentions are re-resolved
+func UpdateComment(w http.ResponseWriter, r *http.Request) {
+	commentID := r.PathValue("id")
+	userID := r.Context().Value(middleware.UserIDKey).(int)
+
+	groupID, ok := requireCommentAuthor(w, commentID, userID)
+	if !ok {
+		return
+	}
+
+	body, ok := decodeCommentBody(w, r)
+	if !ok {
+		return
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		http.Error(w, "Server Error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	var id int
+	err = tx.QueryRow(`UPDATE expense_comments SET body = $1, updated_at = $2 WHERE id = $3 RETURNING id`,
+		body, time.Now(), commentID).Scan(&id)
+	if err != nil {
+		http.Error(w, "Failed to update comment", http.StatusInternalServerError)
+		return
+	}
+
+	if err := saveMentions(tx, id, groupID, body); err != nil {
+		fmt.Println("Error saving mentions:", err)
+		http.Error(w, "Failed to update comment", http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
+		return
+	}
+
+	writeComment(w, id, http.StatusOK)
+}
+
+func DeleteComment(w http.ResponseWriter, r *http.Request) {
+	commentID := r.PathValue("id")
+	userID := r.Context().Value(middleware.UserIDKey).(int)
+
+	if _, ok := requireCommentAuthor(w, commentID, userID); !ok {
+		return
+	}
+
+	if _, err := db.DB.Exec(`DELETE FROM expense_comments WHERE id = $1`, commentID); err != nil {
+		http.Error(w, "Failed to delete comment", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Comment deleted"})
+}
+
+// --- HELPERS ---
+
+// requireExpenseMember resolves the expense's group and rejects non-members
+func requireExpenseMember(w http.ResponseWriter, expenseID any, userID int) (int, bool) {
+	groupID, err := expenseGroupID(expenseID)
+	if err != nil {
+		http.Error(w, "Expense not found", http.StatusNotFound)
+		return 0, false
+	}
+	if !isGroupMember(groupID, userID) {
+		http.Error(w, "Not a member of this group", http.StatusForbidden)
+		return 0, false
+	}
+	return groupID, true
+}
+
+// requireCommentAuthor only lets the author (who must still be a member) change a comment
+func requireCommentAuthor(w http.ResponseWriter, commentID any, userID int) (int, bool) {
+	var groupID, authorID int
+	err := db.DB.QueryRow(`
+		SELECT e.group_id, c.author_id
+		FROM expense_comments c
+		JOIN expenses e ON c.expense_id = e.id
+		WHERE c.id = $1 AND e.deleted_at IS NULL`, commentID).Scan(&groupID, &authorID)
+	if err != nil {
+		http.Error(w, "Comment not found", http.StatusNotFound)
+		return 0, false
+	}
+	if authorID != userID || !isGroupMember(groupID, userID) {
+		http.Error(w, "Only the author can change this comment", http.StatusForbidden)
+		return 0, false
+	}
+	return groupID, true
+}
+
+func decodeCommentBody(w http.ResponseWriter, r *http.Request) (string, bool) {
+	var req CommentRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return "", false
+	}
+	body := strings.TrimSpace(req.Body)
+	if body == "" {
+		http.Error(w, "Comment cannot be empty", http.StatusBadRequest)
+		return "", false
+	}
+	if utf8.RuneCountInString(body) > maxCommentLength {
+		http.Error(w, fmt.Sprintf("Comment is too long (max %d characters)", maxCommentLength), http.StatusBadRequest)
+		return "", false
+	}
+	return body, true
+}
+
+// pageParams reads limit/offset query parameters with sane bounds
+func pageParams(r *http.Request, defaultLimit, maxLimit int) (int, int) {
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 {
+		limit = defaultLimit
+	}
+	if limit > maxLimit {
+		limit = maxLimit
+	}
+	offset, err := strconv.Atoi(r.URL.Query().Get("offset"))
+	if err != nil || offset < 0 {
+		offset = 0
+	}
+	return limit, offset
+}
+
+func writeComment(w http.ResponseWriter, commentID int, status int) {
+	c, err := loadComment(commentID)
+	if err != nil {
+		http.Error(w, "Failed to fetch comment", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(c)
+}
+
+const commentColumns = `c.id, c.expense_id, c.author_id, u.name, c.body, c.created_at, c.updated_at`
+
+func scanComment(row rowScanner) (*CommentResponse, error) {
+	var c CommentResponse
+	var createdAt time.Time
+	var updatedAt sql.NullTime
+	if err := row.Scan(&c.ID, &c.ExpenseID, &c.AuthorID, &c.AuthorName, &c.Body, &createdAt, &updatedAt); err != nil {
+		return nil, err
+	}
+	c.CreatedAt = createdAt.Format(time.RFC3339)
+	if updatedAt.Valid {
+		updated := updatedAt.Time.Format(time.RFC3339)
+		c.UpdatedAt = &updated
+	}
+	c.Mentions = []CommentMention{}
+	return &c, nil
+}
+
+func loadComment(commentID int) (*CommentResponse, error) {
+	c, err := scanComment(db.DB.QueryRow(`
+		SELECT `+commentColumns+`
+		FROM expense_comments c
+		JOIN users u ON c.author_id = u.id
+		WHERE c.id = $1`, commentID))
+	if err != nil {
+		return nil, err
+	}
+	comments := []CommentResponse{*c}
+	if err := loadMentions(comments); err != nil {
+		return nil, err
+	}
+	return &comments[0], nil
+}
+
+// loadMentions fills in the mentions of all the comments with a single query
+func loadMentions(comments []CommentResponse) error {
+	if len(comments) == 0 {
+		return nil
+	}
+	index := make(map[int]int, len(comments))
+	ids := make([]int64, len(comments))
+	for i, c := range comments {
+		index[c.ID] = i
+		ids[i] = int64(c.ID)
+	}
+
+	rows, err := db.DB.Query(`
+		SELECT m.comment_id, u.id, u.name FROM comment_mentions m
+		JOIN users u ON m.user_id = u.id
+		WHERE m.comment_id = ANY($1)
+		ORDER BY u.name`, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var commentID int
+		var m CommentMention
+		if err := rows.Scan(&commentID, &m.UserID, &m.Name); err == nil {
+			c := &comments[index[commentID]]
+			c.Mentions = append(c.Mentions, m)
+		}
+	}
+	return rows.Err()
+}
+
+// saveMentions replaces the @mentions stored for a comment
+func saveMentions(tx *sql.Tx, commentID, groupID int, body string) error {
+	members, err := mentionableMembers(tx, groupID)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(`DELETE FROM comment_mentions WHERE comment_id = $1`, commentID); err != nil {
+		return err
+	}
+	for _, uid := range resolveMentions(body, members) {
+		if _, err := tx.Exec(`INSERT INTO comment_mentions (comment_id, user_id) VALUES ($1, $2) ON CONFLICT DO NOTHING`, commentID, uid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type mentionable struct {
+	ID    int
+	Name  string
+	Email string
+}
+
+func mentionableMembers(q queryer, groupID int) ([]mentionable, error) {
+	rows, err := q.Query(`
+		SELECT u.id, u.name, COALESCE(u.email, '')
+		FROM users u
+		JOIN group_members gm ON u.id = gm.user_id
+		WHERE gm.group_id = $1`, groupID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var members []mentionable
+	for rows.Next() {
+		var m mentionable
+		if err := rows.Scan(&m.ID, &m.Name, &m.Email); err != nil {
+			return nil, err
+		}
+		members = append(members, m)
+	}
+	return members, rows.Err()
+}
+
+// resolveMentions finds "@Name" or "@email" references to group members.
+// Names may contain spaces, so the longest member name that matches wins ("@Sam Lee" over "@Sam").
+func resolveMentions(body string, members []mentionable) []int {
+	// Longest handles first so multi-word names beat their prefixes
+	type handle struct {
+		text string
+		id   int
+	}
+	var handles []handle
+	for _, m := range members {
+		if m.Name != "" {
+			handles = append(handles, handle{strings.ToLower(m.Name), m.ID})
+		}
+		if m.Email != "" {
+			handles = append(handles, handle{strings.ToLower(m.Email), m.ID})
+		}
+	}
+	sort.SliceStable(handles, func(i, j int) bool { return len(handles[i].text) > len(handles[j].text) })
+
+	lower := strings.ToLower(body)
+	seen := make(map[int]bool)
+	var ids []int
+
+	for i := 0; i < len(lower); i++ {
+		if lower[i] != '@' {
+			continue
+		}
+		// "@" inside a word (like an email address) isn't a mention
+		if i > 0 {
+			prev, _ := utf8.DecodeLastRuneInString(lower[:i])
+			if unicode.IsLetter(prev) || unicode.IsDigit(prev) {
+				continue
+			}
+		}
+
+		rest := lower[i+1:]
+		for _, h := range handles {
+			if !strings.HasPrefix(rest, h.text) {
+				continue
+			}
+			if next, _ := utf8.DecodeRuneInString(rest[len(h.text):]); unicode.IsLetter(next) || unicode.IsDigit(next) {
+				continue
+			}
+			if !seen[h.id] {
+				seen[h.id] = true
+				ids = append(ids, h.id)
+			}
+			break
+		}
+	}
+	return ids
+}
@@ -0,0 +1,40 @@
+package handlers
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestResolveMentions(t *testing.T) {
+	members := []mentionable{
+		{ID: 1, Name: "Sam", Email: "sam@example.com"},
+		{ID: 2, Name: "Sam Lee", Email: "lee@example.com"},
+		{ID: 3, Name: "Jo", Email: "jo@example.com"},
+	}
+	tests := []struct {
+		name string
+		body string
+		want []int
+	}{
+		{"none", "Who had the extra pizza?", nil},
+		{"first name", "@Jo had the extra pizza", []int{3}},
+		{"longest name wins", "@Sam Lee had it", []int{2}},
+		{"prefix of a longer name", "@Sam had it, not @Samuel", []int{1}},
+		{"by email", "ask @lee@example.com", []int{2}},
+		{"case-insensitive", "@JO and @sam lee", []int{3, 2}},
+		{"duplicates once, in order", "@Jo @Sam @Jo, @jo!", []int{3, 1}},
+		{"at the end of the body", "I think it was @Jo", []int{3}},
+		{"followed by punctuation", "Was it @Jo?", []int{3}},
+		{"unknown names", "@Chris and @Alex weren't there", nil},
+		{"unknown next to a known one", "@Chris or @Jo", []int{3}},
+		{"email address is not a mention", "mail jo@example.com about it", nil},
+		{"bare at sign", "@ @", nil},
+		// The author is a member like any other; mentioning themselves is recorded too
+		{"author mentions themselves", "@Sam paid, I'm Sam", []int{1}},
+	}
+	for _, tt := range tests {
+		if got := resolveMentions(tt.body, members); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: resolveMentions(%q) = %v, want %v", tt.name, tt.body, got, tt.want)
+		}
+	}
+}
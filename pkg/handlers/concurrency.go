@@ -0,0 +1,48 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+var errVersionMismatch = errors.New("expense was changed by someone else")
+
+// expenseETag is the HTTP entity tag for an expense version
+func expenseETag(version int) string {
+	return `"` + strconv.Itoa(version) + `"`
+}
+
+// ifMatchVersion reads the If-Match header; 0 means no precondition (header missing or "*")
+func ifMatchVersion(r *http.Request) (int, error) {
+	header := strings.TrimSpace(r.Header.Get("If-Match"))
+	if header == "" || header == "*" {
+		return 0, nil
+	}
+	tag := strings.Trim(strings.TrimPrefix(header, "W/"), `"`)
+	version, err := strconv.Atoi(tag)
+	if err != nil || version <= 0 {
+		return 0, errors.New("If-Match must be an ETag returned by GET /expenses/{id}")
+	}
+	return version, nil
+}
+
+// writePreconditionFailed answers a stale If-Match with 412 and the current state of the expense
+func writePreconditionFailed(w http.ResponseWriter, expenseID any) {
+	current, err := loadExpenseDetail(expenseID)
+	if err != nil {
+		fmt.Println("Error fetching expense:", err)
+		http.Error(w, errVersionMismatch.Error(), http.StatusPreconditionFailed)
+		return
+	}
+	w.Header().Set("ETag", expenseETag(current.Version))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusPreconditionFailed)
+	json.NewEncoder(w).Encode(map[string]any{
+		"error":   errVersionMismatch.Error(),
+		"current": current,
+	})
+}
@@ -0,0 +1,309 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"money-splitter/pkg/db"
+	"money-splitter/pkg/middleware"
+)
+
+// Answers a participant can give to their share of a pending expense
+const (
+	confirmPending  = "pending"
+	confirmAccepted = "accepted"
+	confirmDisputed = "disputed"
+)
+
+type DisputeRequest struct {
+	Reason string `json:"reason"`
+}
+
+type GroupSettingsRequest struct {
+	RequireConfirmation bool `json:"require_confirmation"`
+}
+
+// splitAnswer is what a participant said about their share before the splits were rewritten
+type splitAnswer struct {
+	amount       float64
+	confirmation string
+	reason       sql.NullString
+	respondedAt  sql.NullTime
+}
+
+// expenseStatus is the lifecycle state a validated request is saved in. In groups that require
+// confirmation a finished expense is pending until every participant has accepted it.
+func expenseStatus(q queryer, groupID any, req *CreateExpenseRequest) (string, error) {
+	if req.Claiming {
+		return statusClaiming, nil
+	}
+	var required bool
+	if err := q.QueryRow(`SELECT require_confirmation FROM groups WHERE id = $1`, groupID).Scan(&required); err != nil {
+		return "", err
+	}
+	if required {
+		return statusPending, nil
+	}
+	return statusFinal, nil
+}
+
+// previousAnswers reads the current confirmations of an expense, keyed by user
+func previousAnswers(tx *sql.Tx, expenseID any) (map[int]splitAnswer, error) {
+	rows, err := tx.Query(`SELECT user_id, amount_owed, confirmation, dispute_reason, responded_at FROM expense_splits WHERE expense_id = $1`, expenseID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	answers := make(map[int]splitAnswer)
+	for rows.Next() {
+		var userID int
+		var a splitAnswer
+		if err := rows.Scan(&userID, &a.amount, &a.confirmation, &a.reason, &a.respondedAt); err != nil {
+			return nil, err
+		}
+		answers[userID] = a
+	}
+	return answers, rows.Err()
+}
+
+// confirmationFor decides how a rewritten split starts out. Whoever saved the expense agrees with it;
+// everybody else keeps their previous answer as long as their amount didn't change.
+func confirmationFor(status string, split Split, actor int, previous map[int]splitAnswer) splitAnswer {
+	if status != statusPending || split.UserID == actor {
+		return splitAnswer{confirmation: confirmAccepted}
+	}
+	if prev, ok := previous[split.UserID]; ok && math.Abs(prev.amount-split.Amount) < 0.005 {
+		return prev
+	}
+	return splitAnswer{confirmation: confirmPending}
+}
+
+// settleConfirmation makes a pending expense final once every share has been accepted
+func settleConfirmation(tx *sql.Tx, expenseID any) (bool, error) {
+	result, err := tx.Exec(`
+		UPDATE expenses SET status = 'final'
+		WHERE id = $1 AND status = 'pending'
+		  AND NOT EXISTS (SELECT 1 FROM expense_splits s WHERE s.expense_id = expenses.id AND s.confirmation <> 'accepted')`, expenseID)
+	if err != nil {
+		return false, err
+	}
+	settled, _ := result.RowsAffected()
+	return settled > 0, nil
+}
+
+// notify stores an in-app notification
+func notify(tx *sql.Tx, userID int, kind string, groupID, expenseID any, actorID int, message string) error {
+	_, err := tx.Exec(`
+		INSERT INTO notifications (user_id, kind, group_id, expense_id, actor_id, message)
+		VALUES ($1, $2, $3, $4, $5, $6)`, userID, kind, groupID, expenseID, actorID, message)
+	return err
+}
+
+// AcceptExpenseShare confirms the caller's share of a pending expense
+func AcceptExpenseShare(w http.ResponseWriter, r *http.Request) {
+	answerExpenseShare(w, r, confirmAccepted, "")
+}
+
+// DisputeExpenseShare rejects the caller's share of a pending expense; the creator is notified
+func DisputeExpenseShare(w http.ResponseWriter, r *http.Request) {
+	var req DisputeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	req.Reason = strings.TrimSpace(req.Reason)
+	if req.Reason == "" {
+		http.Error(w, "A reason is required", http.StatusBadRequest)
+		return
+	}
+	answerExpenseShare(w, r, confirmDisputed, req.Reason)
+}
+
+func answerExpenseShare(w http.ResponseWriter, r *http.Request, answer, reason string) {
+	expenseID := r.PathValue("id")
+	userID := r.Context().Value(middleware.UserIDKey).(int)
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	// 1. Lock the expense; only pending expenses take answers
+	var groupID, createdBy int
+	var status, title string
+	err = tx.QueryRow(`
+		SELECT group_id, status, title, COALESCE(created_by, 0)
+		FROM expenses
+		WHERE id = $1 AND deleted_at IS NULL
+		FOR UPDATE`, expenseID).Scan(&groupID, &status, &title, &createdBy)
+	if err != nil {
+		http.Error(w, "Expense not found", http.StatusNotFound)
+		return
+	}
+	if status != statusPending {
+		http.Error(w, "Expense is not waiting for confirmation", http.StatusConflict)
+		return
+	}
+
+	// 2. Record the answer on the caller's own split
+	result, err := tx.Exec(`
+		UPDATE expense_splits SET confirmation = $3, dispute_reason = $4, responded_at = CURRENT_TIMESTAMP
+		WHERE expense_id = $1 AND user_id = $2`, expenseID, userID, answer, nullIfEmpty(reason))
+	if err != nil {
+		http.Error(w, "Failed to save answer", http.StatusInternalServerError)
+		return
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		http.Error(w, "You have no share in this expense", http.StatusForbidden)
+		return
+	}
+	if _, err := tx.Exec(`UPDATE expenses SET version = version + 1 WHERE id = $1`, expenseID); err != nil {
+		http.Error(w, "Failed to save answer", http.StatusInternalServerError)
+		return
+	}
+
+	// 3. Accepting may complete the expense, disputing tells the creator
+	settled := false
+	if answer == confirmAccepted {
+		settled, err = settleConfirmation(tx, expenseID)
+	} else if createdBy != 0 && createdBy != userID {
+		var name string
+		tx.QueryRow(`SELECT name FROM users WHERE id = $1`, userID).Scan(&name)
+		err = notify(tx, createdBy, "expense_disputed", groupID, expenseID, userID,
+			fmt.Sprintf("%s disputed their share of %q: %s", name, title, reason))
+	}
+	if err != nil {
+		fmt.Println("Error answering expense share:", err)
+		http.Error(w, "Failed to save answer", http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
+		return
+	}
+
+	status = statusPending
+	if settled {
+		status = statusFinal
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"confirmation": answer, "status": status})
+}
+
+// UpdateGroupSettings turns group-wide options on or off
+func UpdateGroupSettings(w http.ResponseWriter, r *http.Request) {
+	groupID := r.PathValue("id")
+	userID := r.Context().Value(middleware.UserIDKey).(int)
+
+	if !isGroupMember(groupID, userID) {
+		http.Error(w, "Not a member of this group", http.StatusForbidden)
+		return
+	}
+
+	var req GroupSettingsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	// Expenses already recorded stay final; the setting applies to what is saved from now on.
+	// Switching it off lets everything still waiting for confirmation count right away.
+	if _, err := tx.Exec(`UPDATE groups SET require_confirmation = $1 WHERE id = $2`, req.RequireConfirmation, groupID); err != nil {
+		http.Error(w, "Failed to update settings", http.StatusInternalServerError)
+		return
+	}
+	if !req.RequireConfirmation {
+		_, err := tx.Exec(`UPDATE expenses SET status = 'final', version = version + 1 WHERE group_id = $1 AND status = 'pending'`, groupID)
+		if err != nil {
+			http.Error(w, "Failed to update settings", http.StatusInternalServerError)
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(req)
+}
+
+type NotificationResponse struct {
+	ID        int    `json:"id"`
+	Kind      string `json:"kind"`
+	GroupID   int    `json:"group_id,omitempty"`
+	ExpenseID int    `json:"expense_id,omitempty"`
+	ActorID   int    `json:"actor_id,omitempty"`
+	Message   string `json:"message"`
+	CreatedAt string `json:"created_at"`
+	Read      bool   `json:"read"`
+}
+
+// GetNotifications lists the caller's notifications, newest first (?unread=true for unread only)
+func GetNotifications(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(middleware.UserIDKey).(int)
+	limit, offset := pageParams(r, 50, 200)
+
+	rows, err := db.DB.Query(`
+		SELECT id, kind, COALESCE(group_id, 0), COALESCE(expense_id, 0), COALESCE(actor_id, 0), message, created_at, read_at IS NOT NULL
+		FROM notifications
+		WHERE user_id = $1 AND ($2 = FALSE OR read_at IS NULL)
+		ORDER BY created_at DESC, id DESC
+		LIMIT $3 OFFSET $4`, userID, r.URL.Query().Get("unread") == "true", limit, offset)
+	if err != nil {
+		fmt.Println("Error fetching notifications:", err)
+		http.Error(w, "Failed to fetch notifications", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	notifications := []NotificationResponse{}
+	for rows.Next() {
+		var n NotificationResponse
+		var createdAt time.Time
+		if err := rows.Scan(&n.ID, &n.Kind, &n.GroupID, &n.ExpenseID, &n.ActorID, &n.Message, &createdAt, &n.Read); err != nil {
+			continue
+		}
+		n.CreatedAt = createdAt.Format(time.RFC3339)
+		notifications = append(notifications, n)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(notifications)
+}
+
+// MarkNotificationRead marks one of the caller's notifications as read
+func MarkNotificationRead(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(middleware.UserIDKey).(int)
+
+	result, err := db.DB.Exec(`
+		UPDATE notifications SET read_at = COALESCE(read_at, CURRENT_TIMESTAMP)
+		WHERE id = $1 AND user_id = $2`, r.PathValue("id"), userID)
+	if err != nil {
+		http.Error(w, "Failed to update notification", http.StatusInternalServerError)
+		return
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		http.Error(w, "Notification not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Notification marked as read"})
+}
@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"money-splitter/pkg/db"
+	"money-splitter/pkg/rates"
+)
+
+const defaultCurrency = "USD"
+
+// isoCurrencies are the ISO 4217 codes in use; text that only looks like a code ("BBQ", "ATM") isn't one
+var isoCurrencies = map[string]bool{
+	"AED": true, "AFN": true, "ALL": true, "AMD": true, "ANG": true, "AOA": true, "ARS": true, "AUD": true,
+	"AWG": true, "AZN": true, "BAM": true, "BBD": true, "BDT": true, "BGN": true, "BHD": true, "BIF": true,
+	"BMD": true, "BND": true, "BOB": true, "BRL": true, "BSD": true, "BTN": true, "BWP": true, "BYN": true,
+	"BZD": true, "CAD": true, "CDF": true, "CHF": true, "CLP": true, "CNY": true, "COP": true, "CRC": true,
+	"CUP": true, "CVE": true, "CZK": true, "DJF": true, "DKK": true, "DOP": true, "DZD": true, "EGP": true,
+	"ERN": true, "ETB": true, "EUR": true, "FJD": true, "FKP": true, "GBP": true, "GEL": true, "GHS": true,
+	"GIP": true, "GMD": true, "GNF": true, "GTQ": true, "GYD": true, "HKD": true, "HNL": true, "HTG": true,
+	"HUF": true, "IDR": true, "ILS": true, "INR": true, "IQD": true, "IRR": true, "ISK": true, "JMD": true,
+	"JOD": true, "JPY": true, "KES": true, "KGS": true, "KHR": true, "KMF": true, "KPW": true, "KRW": true,
+	"KWD": true, "KYD": true, "KZT": true, "LAK": true, "LBP": true, "LKR": true, "LRD": true, "LSL": true,
+	"LYD": true, "MAD": true, "MDL": true, "MGA": true, "MKD": true, "MMK": true, "MNT": true, "MOP": true,
+	"MRU": true, "MUR": true, "MVR": true, "MWK": true, "MXN": true, "MYR": true, "MZN": true, "NAD": true,
+	"NGN": true, "NIO": true, "NOK": true, "NPR": true, "NZD": true, "OMR": true, "PAB": true, "PEN": true,
+	"PGK": true, "PHP": true, "PKR": true, "PLN": true, "PYG": true, "QAR": true, "RON": true, "RSD": true,
+	"RUB": true, "RWF": true, "SAR": true, "SBD": true, "SCR": true, "SDG": true, "SEK": true, "SGD": true,
+	"SHP": true, "SLE": true, "SOS": true, "SRD": true, "SSP": true, "STN": true, "SVC": true, "SYP": true,
+	"SZL": true, "THB": true, "TJS": true, "TMT": true, "TND": true, "TOP": true, "TRY": true, "TTD": true,
+	"TWD": true, "TZS": true, "UAH": true, "UGX": true, "USD": true, "UYU": true, "UZS": true, "VES": true,
+	"VND": true, "VUV": true, "WST": true, "XAF": true, "XCD": true, "XOF": true, "XPF": true, "YER": true,
+	"ZAR": true, "ZMW": true, "ZWL": true,
+}
+
+// normalizeCurrency upper-cases an ISO 4217 code and checks it has the right shape
+func normalizeCurrency(code string) (string, bool) {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	if len(code) != 3 {
+		return "", false
+	}
+	for _, c := range code {
+		if c < 'A' || c > 'Z' {
+			return "", false
+		}
+	}
+	return code, true
+}
+
+// groupCurrency returns the base currency a group settles in
+func groupCurrency(groupID any) (string, error) {
+	var currency string
+	err := db.DB.QueryRow(`SELECT currency FROM groups WHERE id = $1`, groupID).Scan(&currency)
+	return currency, err
+}
+
+// applyCurrency fills in the expense currency and exchange rate relative to the group currency.
+// An explicit exchange_rate wins; otherwise the rate for the expense's date is looked up.
+func applyCurrency(req *CreateExpenseRequest, baseCurrency string, on time.Time) error {
+	currency, rate, err := resolveCurrency(req.Currency, req.ExchangeRate, baseCurrency, on)
+	if err != nil {
+		return err
+	}
+	req.Currency, req.ExchangeRate = currency, rate
+	return nil
+}
+
+// resolveCurrency is applyCurrency for anything that carries a currency and an optional rate
+func resolveCurrency(code string, explicitRate float64, baseCurrency string, on time.Time) (string, float64, error) {
+	if code == "" {
+		code = baseCurrency
+	}
+	currency, ok := normalizeCurrency(code)
+	if !ok {
+		return "", 0, fmt.Errorf("invalid currency code %q", code)
+	}
+
+	if currency == baseCurrency {
+		return currency, 1, nil
+	}
+	if explicitRate > 0 {
+		return currency, explicitRate, nil
+	}
+
+	rate, err := lookupRate(currency, baseCurrency, on)
+	if errors.Is(err, rates.ErrRateNotFound) {
+		return "", 0, fmt.Errorf("no %s to %s rate known for %s, provide exchange_rate", currency, baseCurrency, on.Format("2006-01-02"))
+	}
+	if err != nil {
+		return "", 0, err
+	}
+	return currency, rate, nil
+}
@@ -0,0 +1,321 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"money-splitter/pkg/db"
+	"money-splitter/pkg/middleware"
+
+	"github.com/lib/pq"
+)
+
+const (
+	// Expenses this many days apart (or closer) can still be the same purchase
+	duplicateWindowDays = 2
+
+	// Bigram similarity from which two titles count as the same
+	similarTitleThreshold = 0.6
+
+	// Lower bar for titles of the same payer: the same amount the same person paid twice
+	// under related titles ("Tesco" and "Tesco Express") is probably one purchase,
+	// under unrelated ones (a daily coffee and a bus ticket) it isn't
+	relatedTitleThreshold = 0.3
+)
+
+type DuplicateExpense struct {
+	ID       int     `json:"id"`
+	Title    string  `json:"title"`
+	Amount   float64 `json:"amount"`
+	Currency string  `json:"currency"`
+	Date     string  `json:"date"`
+	PayerIDs []int   `json:"payer_ids"`
+}
+
+type DuplicateMatch struct {
+	DuplicateExpense
+	Reasons []string `json:"reasons"`
+}
+
+type DuplicateCluster struct {
+	Expenses []DuplicateExpense `json:"expenses"`
+	Reasons  []string           `json:"reasons"`
+}
+
+// duplicateReasons explains why b looks like a, or returns nil when it doesn't.
+// Both already share the amount and are within the date window; on top of that
+// the titles must be similar, or related with a payer in common.
+func duplicateReasons(a, b DuplicateExpense) []string {
+	reasons := []string{"same amount"}
+
+	dayA, _ := time.Parse(dateLayout, a.Date)
+	dayB, _ := time.Parse(dateLayout, b.Date)
+	if days := int(math.Abs(dayA.Sub(dayB).Hours()) / 24); days == 0 {
+		reasons = append(reasons, "same date")
+	} else {
+		reasons = append(reasons, fmt.Sprintf("dates %d day(s) apart", days))
+	}
+
+	score := titleSimilarity(a.Title, b.Title)
+	similar := score >= similarTitleThreshold
+	if similar {
+		reasons = append(reasons, "similar title")
+	}
+	overlap := false
+	for _, p := range a.PayerIDs {
+		for _, q := range b.PayerIDs {
+			overlap = overlap || p == q
+		}
+	}
+	if overlap {
+		reasons = append(reasons, "same payer")
+	}
+
+	if !similar {
+		if !overlap || score < relatedTitleThreshold {
+			return nil
+		}
+		reasons = append(reasons, "related title")
+	}
+	return reasons
+}
+
+// titleSimilarity compares titles by letters and digits only: 1 when one contains the other,
+// otherwise the Dice coefficient over character bigrams
+func titleSimilarity(a, b string) float64 {
+	a, b = normalizeTitle(a), normalizeTitle(b)
+	if a == "" || b == "" {
+		return 0
+	}
+	if strings.Contains(a, b) || strings.Contains(b, a) {
+		return 1
+	}
+
+	bigrams := func(s string) map[string]int {
+		grams := make(map[string]int)
+		runes := []rune(s)
+		for i := 0; i+1 < len(runes); i++ {
+			grams[string(runes[i:i+2])]++
+		}
+		return grams
+	}
+	gramsA, gramsB := bigrams(a), bigrams(b)
+	var common, total int
+	for gram, n := range gramsA {
+		common += min(n, gramsB[gram])
+		total += n
+	}
+	for _, n := range gramsB {
+		total += n
+	}
+	if total == 0 {
+		return 0
+	}
+	return float64(2*common) / float64(total)
+}
+
+func normalizeTitle(title string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(title) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// findDuplicates looks for expenses in the group that the validated request probably repeats
+func findDuplicates(groupID any, req *CreateExpenseRequest) ([]DuplicateMatch, error) {
+	rows, err := db.DB.Query(`
+		SELECT e.id, e.title, e.amount, e.currency, TO_CHAR(e.expense_date, 'YYYY-MM-DD'),
+		       COALESCE(ARRAY_AGG(ep.user_id) FILTER (WHERE ep.user_id IS NOT NULL), '{}')
+		FROM expenses e
+		LEFT JOIN expense_payers ep ON ep.expense_id = e.id
+		WHERE e.group_id = $1 AND e.deleted_at IS NULL AND e.type = $2
+		  AND ((e.currency = $3 AND ABS(e.amount - $4) < 0.005) OR ABS(ROUND(e.amount * e.exchange_rate, 2) - ROUND($5, 2)) < 0.01)
+		  AND e.expense_date BETWEEN $6::date - $7::int AND $6::date + $7::int
+		GROUP BY e.id
+		ORDER BY e.expense_date DESC, e.id DESC`,
+		groupID, req.Type, req.Currency, req.Amount, req.Amount*req.ExchangeRate, req.Date, duplicateWindowDays)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	candidate := DuplicateExpense{Title: req.Title, Amount: req.Amount, Currency: req.Currency, Date: req.Date}
+	for _, p := range req.Payers {
+		candidate.PayerIDs = append(candidate.PayerIDs, p.UserID)
+	}
+
+	var matches []DuplicateMatch
+	for rows.Next() {
+		existing, err := scanDuplicateExpense(rows)
+		if err != nil {
+			return nil, err
+		}
+		if reasons := duplicateReasons(existing, candidate); reasons != nil {
+			matches = append(matches, DuplicateMatch{DuplicateExpense: existing, Reasons: reasons})
+		}
+	}
+	return matches, rows.Err()
+}
+
+func scanDuplicateExpense(rows interface{ Scan(...any) error }) (DuplicateExpense, error) {
+	var e DuplicateExpense
+	var payers pq.Int64Array
+	if err := rows.Scan(&e.ID, &e.Title, &e.Amount, &e.Currency, &e.Date, &payers); err != nil {
+		return e, err
+	}
+	e.PayerIDs = make([]int, len(payers))
+	for i, p := range payers {
+		e.PayerIDs[i] = int(p)
+	}
+	return e, nil
+}
+
+// writeDuplicateWarning rejects a probable duplicate; the client can repeat with ?allow_duplicate=true
+func writeDuplicateWarning(w http.ResponseWriter, matches []DuplicateMatch) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusConflict)
+	json.NewEncoder(w).Encode(map[string]any{
+		"error":      "This looks like an expense that is already recorded",
+		"duplicates": matches,
+		"hint":       "Repeat the request with ?allow_duplicate=true to save it anyway",
+	})
+}
+
+// GetGroupDuplicates lists clusters of expenses that look like the same purchase logged more than once
+func GetGroupDuplicates(w http.ResponseWriter, r *http.Request) {
+	groupID := r.PathValue("id")
+	userID := r.Context().Value(middleware.UserIDKey).(int)
+
+	if !isGroupMember(groupID, userID) {
+		http.Error(w, "Not a member of this group", http.StatusForbidden)
+		return
+	}
+
+	// 1. Pairs with the same amount close together; titles and payers are compared below
+	rows, err := db.DB.Query(`
+		SELECT a.id, b.id
+		FROM expenses a
+		JOIN expenses b ON b.group_id = a.group_id AND b.id > a.id AND b.type = a.type AND b.deleted_at IS NULL
+		 AND ((b.currency = a.currency AND ABS(b.amount - a.amount) < 0.005)
+		      OR ABS(ROUND(b.amount * b.exchange_rate, 2) - ROUND(a.amount * a.exchange_rate, 2)) < 0.01)
+		 AND ABS(b.expense_date - a.expense_date) <= $2
+		WHERE a.group_id = $1 AND a.deleted_at IS NULL`, groupID, duplicateWindowDays)
+	if err != nil {
+		fmt.Println("Error finding duplicates:", err)
+		http.Error(w, "Failed to find duplicates", http.StatusInternalServerError)
+		return
+	}
+	var pairs [][2]int
+	var ids pq.Int64Array
+	seen := make(map[int]bool)
+	for rows.Next() {
+		var pair [2]int
+		if err := rows.Scan(&pair[0], &pair[1]); err != nil {
+			continue
+		}
+		pairs = append(pairs, pair)
+		for _, id := range pair {
+			if !seen[id] {
+				seen[id] = true
+				ids = append(ids, int64(id))
+			}
+		}
+	}
+	rows.Close()
+
+	// 2. Load the expenses involved
+	expenses := make(map[int]DuplicateExpense)
+	if len(ids) > 0 {
+		rows, err = db.DB.Query(`
+			SELECT e.id, e.title, e.amount, e.currency, TO_CHAR(e.expense_date, 'YYYY-MM-DD'),
+			       COALESCE(ARRAY_AGG(ep.user_id) FILTER (WHERE ep.user_id IS NOT NULL), '{}')
+			FROM expenses e
+			LEFT JOIN expense_payers ep ON ep.expense_id = e.id
+			WHERE e.id = ANY($1)
+			GROUP BY e.id`, ids)
+		if err != nil {
+			http.Error(w, "Failed to find duplicates", http.StatusInternalServerError)
+			return
+		}
+		for rows.Next() {
+			e, err := scanDuplicateExpense(rows)
+			if err != nil {
+				continue
+			}
+			expenses[e.ID] = e
+		}
+		rows.Close()
+	}
+
+	// 3. Join matching pairs into clusters (union-find)
+	parent := make(map[int]int)
+	var find func(int) int
+	find = func(id int) int {
+		if p, ok := parent[id]; ok && p != id {
+			parent[id] = find(p)
+			return parent[id]
+		}
+		parent[id] = id
+		return id
+	}
+	reasons := make(map[int]map[string]bool)
+	for _, pair := range pairs {
+		match := duplicateReasons(expenses[pair[0]], expenses[pair[1]])
+		if match == nil {
+			continue
+		}
+		a, b := find(pair[0]), find(pair[1])
+		if a != b {
+			parent[b] = a
+		}
+		for _, id := range pair {
+			if reasons[id] == nil {
+				reasons[id] = make(map[string]bool)
+			}
+			for _, reason := range match {
+				reasons[id][reason] = true
+			}
+		}
+	}
+
+	members := make(map[int][]int)
+	for id := range reasons {
+		root := find(id)
+		members[root] = append(members[root], id)
+	}
+
+	clusters := []DuplicateCluster{}
+	for _, group := range members {
+		sort.Ints(group)
+		var cluster DuplicateCluster
+		why := make(map[string]bool)
+		for _, id := range group {
+			cluster.Expenses = append(cluster.Expenses, expenses[id])
+			for reason := range reasons[id] {
+				why[reason] = true
+			}
+		}
+		for reason := range why {
+			cluster.Reasons = append(cluster.Reasons, reason)
+		}
+		sort.Strings(cluster.Reasons)
+		clusters = append(clusters, cluster)
+	}
+	// Most recently recorded clusters first
+	sort.Slice(clusters, func(i, j int) bool {
+		a, b := clusters[i].Expenses, clusters[j].Expenses
+		return a[len(a)-1].ID > b[len(b)-1].ID
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(clusters)
+}
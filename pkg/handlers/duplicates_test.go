@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestTitleSimilarity(t *testing.T) {
+	tests := []struct {
+		a, b    string
+		similar bool
+	}{
+		{"Groceries", "groceries!", true},
+		{"Tesco", "Tesco Express", true},
+		{"Dinner at Luigi's", "Dinner Luigis", true},
+		{"Taxi to airport", "Taxi to the airport", true},
+		{"Coffee", "Bus ticket", false},
+		{"Rent", "Netflix", false},
+		{"", "Rent", false},
+		{"!!!", "???", false},
+	}
+	for _, tt := range tests {
+		score := titleSimilarity(tt.a, tt.b)
+		if (score >= similarTitleThreshold) != tt.similar {
+			t.Errorf("titleSimilarity(%q, %q) = %.2f, similar want %v", tt.a, tt.b, score, tt.similar)
+		}
+		if back := titleSimilarity(tt.b, tt.a); back != score {
+			t.Errorf("titleSimilarity isn't symmetric for %q and %q: %.2f vs %.2f", tt.a, tt.b, score, back)
+		}
+	}
+}
+
+func TestDuplicateReasons(t *testing.T) {
+	existing := DuplicateExpense{Title: "Dinner at Luigi's", Date: "2024-05-10", PayerIDs: []int{1}}
+	tests := []struct {
+		name   string
+		other  DuplicateExpense
+		reason string // one of the reasons expected, "" when it isn't a duplicate
+	}{
+		{"similar title, other payer", DuplicateExpense{Title: "dinner luigis", Date: "2024-05-10", PayerIDs: []int{2}}, "similar title"},
+		{"similar title a day later", DuplicateExpense{Title: "Dinner at Luigis", Date: "2024-05-11", PayerIDs: []int{2}}, "dates 1 day(s) apart"},
+		{"same payer, related title", DuplicateExpense{Title: "Luigi pizzeria", Date: "2024-05-10", PayerIDs: []int{1}}, "related title"},
+		{"same payer, unrelated title", DuplicateExpense{Title: "Bus ticket", Date: "2024-05-10", PayerIDs: []int{1}}, ""},
+		{"other payer, unrelated title", DuplicateExpense{Title: "Bus ticket", Date: "2024-05-10", PayerIDs: []int{2}}, ""},
+	}
+	for _, tt := range tests {
+		reasons := duplicateReasons(existing, tt.other)
+		switch {
+		case tt.reason == "" && reasons != nil:
+			t.Errorf("%s: flagged as a duplicate: %v", tt.name, reasons)
+		case tt.reason != "" && !slices.Contains(reasons, tt.reason):
+			t.Errorf("%s: reasons = %v, want %q among them", tt.name, reasons, tt.reason)
+		}
+	}
+}
@@ -1,13 +1,17 @@
 package handlers
 
 import (
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"math"
 	"net/http"
+	"strings"
 	"time"
 
 	"money-splitter/pkg/db"
+	"money-splitter/pkg/middleware"
 )
 
 // --- STRUCTS ---
@@ -15,6 +19,10 @@ import (
 type Split struct {
 	UserID int     `json:"user_id"`
 	Amount float64 `json:"amount"`
+
+	// Weights for the percent and shares split modes; Amount is then computed
+	Percent float64 `json:"percent,omitempty"`
+	Shares  float64 `json:"shares,omitempty"`
 }
 
 type PayerSplit struct {
@@ -23,28 +31,67 @@ type PayerSplit struct {
 }
 
 type CreateExpenseRequest struct {
-	Title       string       `json:"title"`
-	Description string       `json:"description"`
-	Amount      float64      `json:"amount"`
-	Category    string       `json:"category"`
-	Payers      []PayerSplit `json:"payers"`
-	Splits      []Split      `json:"splits"`
+	Title        string       `json:"title"`
+	Description  string       `json:"description"`
+	Amount       float64      `json:"amount"`
+	Category     string       `json:"category"`
+	Currency     string       `json:"currency"`
+	ExchangeRate float64      `json:"exchange_rate"`
+	Payers       []PayerSplit `json:"payers"`
+	Splits       []Split      `json:"splits"`
+	SplitMode    string       `json:"split_mode,omitempty"` // exact (default), equal, percent, shares; itemized with Items
+
+	// When the expense happened: YYYY-MM-DD (defaults to today), optional HH:MM and IANA timezone
+	Date     string `json:"date,omitempty"`
+	Time     string `json:"time,omitempty"`
+	Timezone string `json:"timezone,omitempty"`
+
+	// Itemized receipts: when Items are given, Splits are generated from them
+	Items         []ExpenseItem `json:"items,omitempty"`
+	Tax           float64       `json:"tax,omitempty"`
+	Tip           float64       `json:"tip,omitempty"`
+	ServiceCharge float64       `json:"service_charge,omitempty"`
+
+	// Claiming starts a draft: members claim Items themselves and splits are generated on finalize
+	Claiming bool `json:"claiming,omitempty"`
+
+	// Type is expense (default), refund or income. A refund gives back part of the expense RefundOf;
+	// income has a single payer, the member who received the money, and is split like an expense.
+	Type     string `json:"type,omitempty"`
+	RefundOf int    `json:"refund_of,omitempty"`
 }
 
 type ExpenseResponse struct {
-	ID          int     `json:"id"`
-	Title       string  `json:"title"`
-	Description string  `json:"description"`
-	Amount      float64 `json:"amount"`
-	PayerName   string  `json:"payer_name"`
-	Date        string  `json:"date"`
-	Category    string  `json:"category"`
+	ID              int     `json:"id"`
+	Title           string  `json:"title"`
+	Description     string  `json:"description"`
+	Amount          float64 `json:"amount"`
+	Currency        string  `json:"currency"`
+	ExchangeRate    float64 `json:"exchange_rate"`
+	ConvertedAmount float64 `json:"converted_amount"` // negative for refunds and income, see convertedAmount
+	PayerName       string  `json:"payer_name"`
+	Date            string  `json:"date"`
+	Time            string  `json:"time,omitempty"`
+	Timezone        string  `json:"timezone,omitempty"`
+	CreatedAt       string  `json:"created_at"`
+	Category        string  `json:"category"`
+	Status          string  `json:"status"`
+	Version         int     `json:"version"`
+	Type            string  `json:"type"`
+	RefundOf        int     `json:"refund_of,omitempty"`
+	CommentCount    int     `json:"comment_count"`
 }
 
 type SplitDetail struct {
 	UserID   int     `json:"user_id"`
 	UserName string  `json:"user_name"`
 	Amount   float64 `json:"amount"`
+	Percent  float64 `json:"percent,omitempty"`
+	Shares   float64 `json:"shares,omitempty"`
+
+	// Confirmation is pending, accepted or disputed (with the reason)
+	Confirmation  string `json:"confirmation"`
+	DisputeReason string `json:"dispute_reason,omitempty"`
 }
 
 type PayerDetail struct {
@@ -54,40 +101,273 @@ type PayerDetail struct {
 }
 
 type ExpenseDetailResponse struct {
-	ID          int           `json:"id"`
-	Title       string        `json:"title"`
-	Description string        `json:"description"`
-	Amount      float64       `json:"amount"`
-	Category    string        `json:"category"`
-	Date        string        `json:"date"`
-	Payers      []PayerDetail `json:"payers"`
-	PayerName   string        `json:"payer_name"`
-	PayerID     int           `json:"payer_id"`
-	Splits      []SplitDetail `json:"splits"`
+	ID              int                  `json:"id"`
+	Title           string               `json:"title"`
+	Description     string               `json:"description"`
+	Amount          float64              `json:"amount"`
+	Currency        string               `json:"currency"`
+	ExchangeRate    float64              `json:"exchange_rate"`
+	ConvertedAmount float64              `json:"converted_amount"`
+	GroupCurrency   string               `json:"group_currency"`
+	Category        string               `json:"category"`
+	Date            string               `json:"date"`
+	Time            string               `json:"time,omitempty"`
+	Timezone        string               `json:"timezone,omitempty"`
+	CreatedAt       string               `json:"created_at"`
+	Status          string               `json:"status"`
+	Version         int                  `json:"version"`
+	SplitMode       string               `json:"split_mode"`
+	Type            string               `json:"type"`
+	RefundOf        int                  `json:"refund_of,omitempty"`
+	RefundedAmount  float64              `json:"refunded_amount,omitempty"`
+	Payers          []PayerDetail        `json:"payers"`
+	PayerName       string               `json:"payer_name"`
+	PayerID         int                  `json:"payer_id"`
+	Splits          []SplitDetail        `json:"splits"`
+	Items           []ExpenseItem        `json:"items"`
+	Tax             float64              `json:"tax"`
+	Tip             float64              `json:"tip"`
+	ServiceCharge   float64              `json:"service_charge"`
+	Attachments     []AttachmentResponse `json:"attachments"`
+}
+
+// Expense lifecycle: drafts being claimed don't count towards balances until finalized,
+// pending expenses only once every participant has confirmed their share
+const (
+	statusFinal    = "final"
+	statusClaiming = "claiming"
+	statusPending  = "pending"
+)
+
+// Entry types. Refunds and income count against balances with the opposite sign:
+// whoever receives the money owes it to the people it is split between.
+const (
+	typeExpense = "expense"
+	typeRefund  = "refund"
+	typeIncome  = "income"
+)
+
+// balanceSign flips refunds and income in balance sums; e is the expenses alias
+const balanceSign = `(CASE WHEN e.type IN ('refund', 'income') THEN -1 ELSE 1 END)`
+
+// entrySign is balanceSign in Go: refunds and income bring money in, so they count negative
+func entrySign(kind string) float64 {
+	if kind == typeRefund || kind == typeIncome {
+		return -1
+	}
+	return 1
+}
+
+// convertedAmount is what an entry does to the group total, in the group currency and with entrySign.
+// Lists, details, the timeline and the PDF all show it this way.
+func convertedAmount(kind string, amount, rate float64) float64 {
+	return entrySign(kind) * math.Round(amount*rate*100) / 100
+}
+
+// prepareExpense validates a create/update request and generates splits for itemized receipts
+func prepareExpense(req *CreateExpenseRequest) error {
+	switch req.Type {
+	case "":
+		req.Type = typeExpense
+	case typeExpense:
+	case typeRefund:
+		if req.RefundOf == 0 {
+			return errors.New("A refund needs refund_of, the expense being refunded")
+		}
+		if req.Claiming {
+			return errors.New("Refunds cannot use claiming")
+		}
+	case typeIncome:
+		if req.Claiming || len(req.Items) > 0 {
+			return errors.New("Income is split with split_mode, not receipt items")
+		}
+		if len(req.Payers) != 1 {
+			return errors.New("Income needs exactly one receiver in payers")
+		}
+		if req.Payers[0].PaidAmount == 0 {
+			req.Payers[0].PaidAmount = req.Amount
+		} else if math.Abs(req.Payers[0].PaidAmount-req.Amount) > 0.01 {
+			return errors.New("The receiver must receive the whole amount")
+		}
+	default:
+		return fmt.Errorf("unknown type %q", req.Type)
+	}
+	if req.Type != typeRefund {
+		req.RefundOf = 0
+	}
+
+	if req.Claiming {
+		if len(req.Items) == 0 {
+			return errors.New("Claiming requires receipt items")
+		}
+		if req.Tax < 0 || req.Tip < 0 || req.ServiceCharge < 0 {
+			return errors.New("Tax, tip and service charge cannot be negative")
+		}
+		if err := validateItems(req.Items); err != nil {
+			return err
+		}
+		total := itemsTotal(req.Items) + req.Tax + req.Tip + req.ServiceCharge
+		if req.Amount == 0 {
+			req.Amount = total
+		} else if math.Abs(req.Amount-total) > 0.01 {
+			return errors.New("Items plus tax, tip and service charge do not match total amount")
+		}
+		// Splits are generated once claiming is finalized
+		req.Splits = nil
+		req.SplitMode = splitItemized
+		return nil
+	}
+
+	if len(req.Items) > 0 {
+		if req.Tax < 0 || req.Tip < 0 || req.ServiceCharge < 0 {
+			return errors.New("Tax, tip and service charge cannot be negative")
+		}
+		splits, total, err := itemizedSplits(req.Items, req.Tax+req.Tip+req.ServiceCharge)
+		if err != nil {
+			return err
+		}
+		if req.Amount == 0 {
+			req.Amount = total
+		} else if math.Abs(req.Amount-total) > 0.01 {
+			return errors.New("Items plus tax, tip and service charge do not match total amount")
+		}
+		req.Splits = splits
+		req.SplitMode = splitItemized
+	} else {
+		req.Tax, req.Tip, req.ServiceCharge = 0, 0, 0
+		if err := applySplitMode(req); err != nil {
+			return err
+		}
+	}
+
+	var totalSplit float64
+	for _, s := range req.Splits {
+		totalSplit += s.Amount
+	}
+	if math.Abs(totalSplit-req.Amount) > 0.01 {
+		return errors.New("Split amounts do not match total amount")
+	}
+	return nil
+}
+
+// resolveExpenseDate validates the expense date, time and timezone and returns the day the expense happened.
+// A missing date means today (in the given timezone, if any).
+func resolveExpenseDate(req *CreateExpenseRequest, now time.Time) (time.Time, error) {
+	if req.Timezone != "" {
+		loc, err := time.LoadLocation(req.Timezone)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("unknown timezone %q", req.Timezone)
+		}
+		now = now.In(loc)
+	}
+	if req.Date == "" {
+		req.Date = now.Format(dateLayout)
+	}
+	day, err := time.Parse(dateLayout, req.Date)
+	if err != nil {
+		return time.Time{}, errors.New("date must be YYYY-MM-DD")
+	}
+	if req.Time != "" {
+		t, err := time.Parse("15:04", req.Time)
+		if err != nil {
+			return time.Time{}, errors.New("time must be HH:MM")
+		}
+		req.Time = t.Format("15:04")
+	}
+	return day, nil
+}
+
+// dateParam reads an optional YYYY-MM-DD query parameter
+func dateParam(r *http.Request, name string) (*time.Time, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return nil, nil
+	}
+	parsed, err := time.Parse(dateLayout, value)
+	if err != nil {
+		return nil, fmt.Errorf("%s must be YYYY-MM-DD", name)
+	}
+	return &parsed, nil
+}
+
+// nullIfEmpty stores optional text columns as NULL
+func nullIfEmpty(s string) any {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// nullIfZero stores optional references as NULL
+func nullIfZero(id int) any {
+	if id == 0 {
+		return nil
+	}
+	return id
 }
 
 // --- HANDLERS ---
 
 func CreateExpense(w http.ResponseWriter, r *http.Request) {
 	groupID := r.PathValue("id")
+	userID := r.Context().Value(middleware.UserIDKey).(int)
 
 	var req CreateExpenseRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "Invalid request", http.StatusBadRequest)
 		return
 	}
+	createExpense(w, r, groupID, userID, &req)
+}
 
-	// 1. Validate Total Split
-	var totalSplit float64
-	for _, s := range req.Splits {
-		totalSplit += s.Amount
+// createExpense validates and saves a new expense and writes the response.
+// Shared by CreateExpense and the endpoints that build the request for the client (templates, quick entry).
+func createExpense(w http.ResponseWriter, r *http.Request, groupID any, userID int, req *CreateExpenseRequest) {
+	// 1. Validate Total Split (itemized receipts generate their splits here)
+	if err := prepareExpense(req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
-	if math.Abs(totalSplit-req.Amount) > 0.01 {
-		http.Error(w, "Split amounts do not match total amount", http.StatusBadRequest)
+	day, err := resolveExpenseDate(req, time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := checkRefund(groupID, req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := checkGroupParticipants(groupID, req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !applyCategory(w, groupID, req) {
+		return
+	}
+
+	// 2. Resolve currency against the group's base currency (at the rate of the expense date)
+	baseCurrency, err := groupCurrency(groupID)
+	if err != nil {
+		http.Error(w, "Group not found", http.StatusNotFound)
+		return
+	}
+	if err := applyCurrency(req, baseCurrency, day); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	// 2. Start Transaction
+	// Warn about an expense someone else already logged, unless the client insists
+	if r.URL.Query().Get("allow_duplicate") != "true" {
+		matches, err := findDuplicates(groupID, req)
+		if err != nil {
+			fmt.Println("Error checking duplicates:", err)
+		} else if len(matches) > 0 {
+			writeDuplicateWarning(w, matches)
+			return
+		}
+	}
+
+	// 3. Start Transaction
 	tx, err := db.DB.Begin()
 	if err != nil {
 		http.Error(w, "Server Error", http.StatusInternalServerError)
@@ -95,82 +375,213 @@ func CreateExpense(w http.ResponseWriter, r *http.Request) {
 	}
 	defer tx.Rollback()
 
+	// 4. Insert Expense, Payers, Splits and Items
+	expenseID, err := insertExpense(tx, groupID, req, time.Now(), userID)
+	if isRefundRejected(err) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		fmt.Println("Error inserting Expense:", err)
+		http.Error(w, "Failed to save Expense", http.StatusInternalServerError)
+		return
+	}
+
+	// 5. Commit
+	if err = tx.Commit(); err != nil {
+		http.Error(w, "Failed to Commit transaction", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("ETag", expenseETag(1))
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]any{
+		"message":    "Expense added successfully",
+		"expense_id": expenseID,
+	})
+}
+
+// insertExpense writes a validated expense with its payers, splits and receipt items.
+// Everything that creates expenses (API, recurring scheduler) goes through here.
+func insertExpense(tx *sql.Tx, groupID any, req *CreateExpenseRequest, createdAt time.Time, createdBy int) (int, error) {
 	var expenseID int
 
-	// 3. Insert Expense Record
+	if err := lockRefundLimit(tx, req, 0); err != nil {
+		return 0, err
+	}
+	status, err := expenseStatus(tx, groupID, req)
+	if err != nil {
+		return 0, err
+	}
+
 	// Note: We insert created_at manually to ensure accuracy
 	queryExpense := `
-		INSERT INTO expenses (group_id, amount, title, description, category, created_at) 
-		VALUES ($1, $2, $3, $4, $5, $6) 
+		INSERT INTO expenses (group_id, amount, title, description, category, currency, exchange_rate, tax, tip, service_charge, status, created_by, created_at,
+		                      expense_date, expense_time, expense_timezone, split_mode, type, refund_of) 
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19) 
 		RETURNING id`
 
-	err = tx.QueryRow(queryExpense, groupID, req.Amount, req.Title, req.Description, req.Category, time.Now()).Scan(&expenseID)
+	err = tx.QueryRow(queryExpense, groupID, req.Amount, req.Title, req.Description, req.Category, req.Currency, req.ExchangeRate,
+		req.Tax, req.Tip, req.ServiceCharge, status, createdBy, createdAt,
+		req.Date, nullIfEmpty(req.Time), req.Timezone, req.SplitMode, req.Type, nullIfZero(req.RefundOf)).Scan(&expenseID)
 	if err != nil {
-		fmt.Println("Error inserting Expense:", err)
-		http.Error(w, "Failed to save Expense", http.StatusInternalServerError)
-		return
+		return 0, err
 	}
 
-	// 4. Insert Payers
-	queryPayer := `INSERT INTO expense_payers (expense_id, user_id, paid_amount) VALUES ($1, $2, $3)`
-	stmtPayer, _ := tx.Prepare(queryPayer)
-	defer stmtPayer.Close()
+	if err := saveExpenseParts(tx, expenseID, req, status, createdBy); err != nil {
+		return 0, err
+	}
+
+	after, err := loadExpenseSnapshot(tx, expenseID, false)
+	if err != nil {
+		return 0, err
+	}
+	if err := recordRevision(tx, expenseID, revisionCreate, createdBy, nil, after); err != nil {
+		return 0, fmt.Errorf("recording revision: %w", err)
+	}
+	return expenseID, nil
+}
+
+// updateExpense replaces an expense's fields, payers, splits and items and records the revision.
+// UpdateExpense and reverting to an older revision both go through here.
+// A non-zero expectedVersion (from If-Match) must match the stored version or errVersionMismatch is returned.
+// Returns the new version.
+func updateExpense(tx *sql.Tx, expenseID any, req *CreateExpenseRequest, userID int, action string, expectedVersion int) (int, error) {
+	// The refunded expense is locked before the refund itself, the same order as when creating one
+	if err := lockRefundLimit(tx, req, expenseID); err != nil {
+		return 0, err
+	}
+	// Lock the row and keep the previous state for the history
+	before, err := loadExpenseSnapshot(tx, expenseID, true)
+	if err != nil {
+		return 0, err
+	}
+	if err := checkRefundedTotal(tx, req, expenseID); err != nil {
+		return 0, err
+	}
+	var groupID int
+	if err := tx.QueryRow(`SELECT group_id FROM expenses WHERE id = $1`, expenseID).Scan(&groupID); err != nil {
+		return 0, err
+	}
+	status, err := expenseStatus(tx, groupID, req)
+	if err != nil {
+		return 0, err
+	}
+
+	queryUpdate := `
+		UPDATE expenses 
+		SET description=$1, amount=$2, category=$3, title=$4, currency=$5, exchange_rate=$6,
+		    tax=$7, tip=$8, service_charge=$9, status=$10,
+		    expense_date=$11, expense_time=$12, expense_timezone=$13, split_mode=$16, version = version + 1
+		WHERE id=$14 AND ($15 = 0 OR version = $15)
+		RETURNING version
+	`
+	var version int
+	err = tx.QueryRow(queryUpdate, req.Description, req.Amount, req.Category, req.Title, req.Currency, req.ExchangeRate,
+		req.Tax, req.Tip, req.ServiceCharge, status,
+		req.Date, nullIfEmpty(req.Time), req.Timezone, expenseID, expectedVersion, req.SplitMode).Scan(&version)
+	if errors.Is(err, sql.ErrNoRows) {
+		// The row is locked and exists, so only the version can have failed
+		return 0, errVersionMismatch
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	if err := saveExpenseParts(tx, expenseID, req, status, userID); err != nil {
+		return 0, err
+	}
 
+	after, err := loadExpenseSnapshot(tx, expenseID, false)
+	if err != nil {
+		return 0, err
+	}
+	if err := recordRevision(tx, expenseID, action, userID, before, after); err != nil {
+		return 0, fmt.Errorf("recording revision: %w", err)
+	}
+	return version, nil
+}
+
+// saveExpenseParts replaces payers, splits and receipt items (Delete Old -> Insert New).
+// For a pending expense the splits keep their confirmations where the amount didn't change,
+// and the expense becomes final right away if nobody else has to confirm.
+func saveExpenseParts(tx *sql.Tx, expenseID any, req *CreateExpenseRequest, status string, actor int) error {
+	if _, err := tx.Exec(`DELETE FROM expense_payers WHERE expense_id=$1`, expenseID); err != nil {
+		return fmt.Errorf("clearing payers: %w", err)
+	}
+	queryPayer := `INSERT INTO expense_payers (expense_id, user_id, paid_amount) VALUES ($1, $2, $3)`
 	for _, payer := range req.Payers {
-		_, err := stmtPayer.Exec(expenseID, payer.UserID, payer.PaidAmount)
-		if err != nil {
-			fmt.Println("Error inserting payer:", err)
-			http.Error(w, "Failed to save payers", http.StatusInternalServerError)
-			return
+		if _, err := tx.Exec(queryPayer, expenseID, payer.UserID, payer.PaidAmount); err != nil {
+			return fmt.Errorf("inserting payer: %w", err)
 		}
 	}
 
-	// 5. Insert Splits
-	querySplits := `INSERT INTO expense_splits (expense_id, user_id, amount_owed) VALUES ($1, $2, $3)`
-	stmtSplits, _ := tx.Prepare(querySplits)
-	defer stmtSplits.Close()
-
+	previous, err := previousAnswers(tx, expenseID)
+	if err != nil {
+		return fmt.Errorf("reading confirmations: %w", err)
+	}
+	if _, err := tx.Exec(`DELETE FROM expense_splits WHERE expense_id=$1`, expenseID); err != nil {
+		return fmt.Errorf("clearing splits: %w", err)
+	}
+	querySplits := `
+		INSERT INTO expense_splits (expense_id, user_id, amount_owed, weight, confirmation, dispute_reason, responded_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)`
 	for _, split := range req.Splits {
-		_, err := stmtSplits.Exec(expenseID, split.UserID, split.Amount)
+		answer := confirmationFor(status, split, actor, previous)
+		_, err := tx.Exec(querySplits, expenseID, split.UserID, split.Amount, splitWeight(req.SplitMode, split),
+			answer.confirmation, answer.reason, answer.respondedAt)
 		if err != nil {
-			fmt.Println("Error inserting split:", err)
-			http.Error(w, "Failed to save splits", http.StatusInternalServerError)
-			return
+			return fmt.Errorf("inserting split: %w", err)
+		}
+	}
+	if status == statusPending {
+		if _, err := settleConfirmation(tx, expenseID); err != nil {
+			return fmt.Errorf("settling confirmation: %w", err)
 		}
 	}
 
-	// 6. Commit
-	if err = tx.Commit(); err != nil {
-		http.Error(w, "Failed to Commit transaction", http.StatusInternalServerError)
-		return
+	if err := saveItems(tx, expenseID, req.Items); err != nil {
+		return fmt.Errorf("saving items: %w", err)
 	}
+	return nil
+}
 
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(map[string]any{
-		"message":    "Expense added successfully",
-		"expense_id": expenseID,
-	})
+type ExpensePage struct {
+	Expenses   []ExpenseResponse `json:"expenses"`
+	NextCursor string            `json:"next_cursor,omitempty"`
 }
 
+// GetGroupExpenses lists a group's expenses. See parseExpenseQuery for the filters;
+// with ?limit or ?cursor the response is an ExpensePage instead of a plain list.
 func GetGroupExpenses(w http.ResponseWriter, r *http.Request) {
 	groupID := r.PathValue("id")
 
+	filter, err := parseExpenseQuery(r, groupID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	// 1. Fetch Expenses
 	// We use a subquery to get the first payer's name, since we don't have payer_id in the expenses table anymore.
 	query := `
-		SELECT e.id, e.title, e.description, e.amount, e.category, e.created_at,
+		SELECT e.id, e.title, e.description, e.amount, e.currency, e.exchange_rate, e.category, e.status, e.version,
+		       e.type, COALESCE(e.refund_of, 0),
+		       TO_CHAR(e.expense_date, 'YYYY-MM-DD'), COALESCE(TO_CHAR(e.expense_time, 'HH24:MI'), ''), e.expense_timezone, e.created_at,
 		       COALESCE((
 		           SELECT u.name 
 		           FROM expense_payers ep 
 		           JOIN users u ON ep.user_id = u.id 
 		           WHERE ep.expense_id = e.id 
 		           LIMIT 1
-		       ), 'Unknown') as payer_name
+		       ), 'Unknown') as payer_name,
+		       (SELECT COUNT(*) FROM expense_comments c WHERE c.expense_id = e.id) as comment_count,
+		       ` + filter.sortKey() + ` as sort_key
 		FROM expenses e
-		WHERE e.group_id = $1
-		ORDER BY e.created_at DESC
-	`
-	rows, err := db.DB.Query(query, groupID)
+		WHERE ` + filter.whereClause() + `
+		ORDER BY ` + filter.orderClause() + `
+		` + filter.limitClause()
+	rows, err := db.DB.Query(query, filter.args...)
 	if err != nil {
 		fmt.Println("Error fetching expenses:", err)
 		http.Error(w, "Failed to fetch Expenses", http.StatusInternalServerError)
@@ -179,56 +590,90 @@ func GetGroupExpenses(w http.ResponseWriter, r *http.Request) {
 	defer rows.Close()
 
 	var expenses []ExpenseResponse
+	var lastKey string
+	hasMore := false
 	for rows.Next() {
+		if filter.paginated && len(expenses) == filter.limit {
+			hasMore = true
+			break
+		}
+
 		var e ExpenseResponse
-		var createdAtStr string
-		
+		var createdAt time.Time
+		var sortKey string
+
 		// Scan matches the SELECT order
-		err := rows.Scan(&e.ID, &e.Title, &e.Description, &e.Amount, &e.Category, &createdAtStr, &e.PayerName)
+		err := rows.Scan(&e.ID, &e.Title, &e.Description, &e.Amount, &e.Currency, &e.ExchangeRate, &e.Category, &e.Status, &e.Version,
+			&e.Type, &e.RefundOf, &e.Date, &e.Time, &e.Timezone, &createdAt, &e.PayerName, &e.CommentCount, &sortKey)
 		if err != nil {
 			continue
 		}
+		e.ConvertedAmount = convertedAmount(e.Type, e.Amount, e.ExchangeRate)
 
-		// Format Date safely
-		if len(createdAtStr) > 10 {
-			e.Date = createdAtStr[:10]
-		} else {
-			e.Date = createdAtStr
-		}
+		e.CreatedAt = createdAt.Format(time.RFC3339)
 		expenses = append(expenses, e)
+		lastKey = sortKey
 	}
 
 	if expenses == nil {
 		expenses = []ExpenseResponse{}
 	}
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(expenses)
+	if !filter.paginated {
+		json.NewEncoder(w).Encode(expenses)
+		return
+	}
+
+	page := ExpensePage{Expenses: expenses}
+	if hasMore {
+		last := expenses[len(expenses)-1]
+		page.NextCursor = expenseCursor{Sort: filter.sort, Order: filter.order, Key: lastKey, ID: last.ID}.encode()
+	}
+	json.NewEncoder(w).Encode(page)
 }
 
 func GetExpenseDetails(w http.ResponseWriter, r *http.Request) {
-	expenseID := r.PathValue("id")
+	e, err := loadExpenseDetail(r.PathValue("id"))
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "Expense not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		fmt.Println("Error fetching expense:", err)
+		http.Error(w, "Failed to fetch expense", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("ETag", expenseETag(e.Version))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(e)
+}
 
+// loadExpenseDetail reads everything GET /expenses/{id} returns; sql.ErrNoRows if it doesn't exist
+func loadExpenseDetail(expenseID any) (*ExpenseDetailResponse, error) {
 	// 1. Get Basic Info
 	queryInfo := `
-		SELECT id, title, description, amount, category, created_at
-		FROM expenses
-		WHERE id = $1
+		SELECT e.id, e.title, e.description, e.amount, e.currency, e.exchange_rate, g.currency, e.category, e.created_at,
+		       TO_CHAR(e.expense_date, 'YYYY-MM-DD'), COALESCE(TO_CHAR(e.expense_time, 'HH24:MI'), ''), e.expense_timezone,
+		       e.tax, e.tip, e.service_charge, e.status, e.version, e.split_mode, e.type, COALESCE(e.refund_of, 0),
+		       (SELECT COALESCE(SUM(r.amount), 0) FROM expenses r WHERE r.refund_of = e.id AND r.type = 'refund' AND r.deleted_at IS NULL)
+		FROM expenses e
+		JOIN groups g ON e.group_id = g.id
+		WHERE e.id = $1 AND e.deleted_at IS NULL
 	`
 	var e ExpenseDetailResponse
-	var createdAtStr string
+	var createdAt time.Time
 
 	err := db.DB.QueryRow(queryInfo, expenseID).Scan(
-		&e.ID, &e.Title, &e.Description, &e.Amount, &e.Category, &createdAtStr,
+		&e.ID, &e.Title, &e.Description, &e.Amount, &e.Currency, &e.ExchangeRate, &e.GroupCurrency, &e.Category, &createdAt,
+		&e.Date, &e.Time, &e.Timezone,
+		&e.Tax, &e.Tip, &e.ServiceCharge, &e.Status, &e.Version, &e.SplitMode, &e.Type, &e.RefundOf, &e.RefundedAmount,
 	)
 	if err != nil {
-		http.Error(w, "Expense not found", http.StatusNotFound)
-		return
-	}
-	if len(createdAtStr) > 10 {
-		e.Date = createdAtStr[:10]
-	} else {
-		e.Date = createdAtStr
+		return nil, err
 	}
+	e.ConvertedAmount = convertedAmount(e.Type, e.Amount, e.ExchangeRate)
+	e.CreatedAt = createdAt.Format(time.RFC3339)
 
 	// 2. Get Payers
 	queryPayers := `
@@ -239,9 +684,7 @@ func GetExpenseDetails(w http.ResponseWriter, r *http.Request) {
 	`
 	rowsPayers, err := db.DB.Query(queryPayers, expenseID)
 	if err != nil {
-		fmt.Println("Error fetching payers:", err)
-		http.Error(w, "Failed to fetch payers", http.StatusInternalServerError)
-		return
+		return nil, fmt.Errorf("fetching payers: %w", err)
 	}
 	defer rowsPayers.Close()
 
@@ -265,119 +708,225 @@ func GetExpenseDetails(w http.ResponseWriter, r *http.Request) {
 
 	// 3. Get Splits (Who owes)
 	querySplits := `
-		SELECT s.user_id, u.name, s.amount_owed
+		SELECT s.user_id, u.name, s.amount_owed, COALESCE(s.weight, 0), s.confirmation, COALESCE(s.dispute_reason, '')
 		FROM expense_splits s
 		JOIN users u ON s.user_id = u.id
 		WHERE s.expense_id = $1
 	`
 	rows, err := db.DB.Query(querySplits, expenseID)
 	if err != nil {
-		http.Error(w, "Failed to fetch splits", http.StatusInternalServerError)
-		return
+		return nil, fmt.Errorf("fetching splits: %w", err)
 	}
 	defer rows.Close()
 
 	for rows.Next() {
 		var s SplitDetail
-		rows.Scan(&s.UserID, &s.UserName, &s.Amount)
+		var weight float64
+		rows.Scan(&s.UserID, &s.UserName, &s.Amount, &weight, &s.Confirmation, &s.DisputeReason)
+		switch e.SplitMode {
+		case splitPercent:
+			s.Percent = weight
+		case splitShares:
+			s.Shares = weight
+		}
 		e.Splits = append(e.Splits, s)
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(e)
+	// 4. Get Receipt Items
+	e.Items, err = loadItems(db.DB, expenseID)
+	if err != nil {
+		return nil, fmt.Errorf("fetching items: %w", err)
+	}
+	if e.Items == nil {
+		e.Items = []ExpenseItem{}
+	}
+
+	// 5. Get Attachments (with short-lived download links)
+	e.Attachments, err = loadAttachments(expenseID)
+	if err != nil {
+		return nil, fmt.Errorf("fetching attachments: %w", err)
+	}
+	return &e, nil
 }
 
 func DeleteExpense(w http.ResponseWriter, r *http.Request) {
 	expenseID := r.PathValue("id")
-	query := `DELETE FROM expenses WHERE id = $1`
+	userID := r.Context().Value(middleware.UserIDKey).(int)
 
-	result, err := db.DB.Exec(query, expenseID)
+	expectedVersion, err := ifMatchVersion(r)
 	if err != nil {
-		http.Error(w, "Failed to delete expense", http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		http.Error(w, "Server error", http.StatusInternalServerError)
 		return
 	}
+	defer tx.Rollback()
 
-	rowsAffected, _ := result.RowsAffected()
-	if rowsAffected == 0 {
+	// Keep the final state in the history; the row itself only moves to the trash
+	before, err := loadExpenseSnapshot(tx, expenseID, true)
+	if err != nil {
 		http.Error(w, "Expense not found", http.StatusNotFound)
 		return
 	}
 
+	// Refunds only make sense next to their expense
+	var refunds int
+	err = tx.QueryRow(`SELECT COUNT(*) FROM expenses WHERE refund_of = $1 AND type = 'refund' AND deleted_at IS NULL`, expenseID).Scan(&refunds)
+	if err != nil {
+		http.Error(w, "Failed to delete expense", http.StatusInternalServerError)
+		return
+	}
+	if refunds > 0 {
+		http.Error(w, fmt.Sprintf("Expense has %d refund(s); delete them first", refunds), http.StatusConflict)
+		return
+	}
+
+	if err := recordRevision(tx, expenseID, revisionDelete, userID, before, nil); err != nil {
+		fmt.Println("Error recording revision:", err)
+		http.Error(w, "Failed to delete expense", http.StatusInternalServerError)
+		return
+	}
+
+	query := `
+		UPDATE expenses SET deleted_at = CURRENT_TIMESTAMP, deleted_by = $2, version = version + 1
+		WHERE id = $1 AND ($3 = 0 OR version = $3)`
+	result, err := tx.Exec(query, expenseID, userID, expectedVersion)
+	if err != nil {
+		http.Error(w, "Failed to delete expense", http.StatusInternalServerError)
+		return
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		tx.Rollback()
+		writePreconditionFailed(w, expenseID)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
+		return
+	}
+
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(map[string]string{"message": "Expense deleted"})
+	json.NewEncoder(w).Encode(map[string]string{"message": "Expense moved to trash"})
 }
 
 func UpdateExpense(w http.ResponseWriter, r *http.Request) {
 	expenseID := r.PathValue("id")
+	userID := r.Context().Value(middleware.UserIDKey).(int)
+
+	expectedVersion, err := ifMatchVersion(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
 	var req CreateExpenseRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "Invalid input", http.StatusBadRequest)
 		return
 	}
-
-	tx, err := db.DB.Begin()
+	groupID, baseCurrency, err := storedExpenseDefaults(expenseID, &req)
 	if err != nil {
-		http.Error(w, "Server error", http.StatusInternalServerError)
+		http.Error(w, "Expense not found", http.StatusNotFound)
 		return
 	}
-
-	// 1. Update Main Expense Table
-	queryUpdate := `
-		UPDATE expenses 
-		SET description=$1, amount=$2, category=$3, title=$4
-		WHERE id=$5
-	`
-	_, err = tx.Exec(queryUpdate, req.Description, req.Amount, req.Category, req.Title, expenseID)
+	if err := prepareExpense(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	day, err := resolveExpenseDate(&req, time.Now())
 	if err != nil {
-		tx.Rollback()
-		http.Error(w, "Failed to update expense", http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := checkRefund(groupID, &req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := checkGroupParticipants(groupID, &req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	manualCategory := strings.TrimSpace(req.Category) != ""
+	if !applyCategory(w, groupID, &req) {
+		return
+	}
+	if err := applyCurrency(&req, baseCurrency, day); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	// 2. Refresh Payers (Delete Old -> Insert New)
-	_, err = tx.Exec(`DELETE FROM expense_payers WHERE expense_id=$1`, expenseID)
+	tx, err := db.DB.Begin()
 	if err != nil {
-		tx.Rollback()
-		http.Error(w, "Failed to clear old payers", http.StatusInternalServerError)
+		http.Error(w, "Server error", http.StatusInternalServerError)
 		return
 	}
+	defer tx.Rollback()
 
-	queryPayer := `INSERT INTO expense_payers (expense_id, user_id, paid_amount) VALUES ($1, $2, $3)`
-	stmtPayer, _ := tx.Prepare(queryPayer)
-	defer stmtPayer.Close()
-
-	for _, payer := range req.Payers {
-		_, err := stmtPayer.Exec(expenseID, payer.UserID, payer.PaidAmount)
-		if err != nil {
-			tx.Rollback()
-			http.Error(w, "Failed to save new payers", http.StatusInternalServerError)
+	// A category changed by hand is remembered for the next expense with this title
+	if manualCategory {
+		if err := learnCategoryCorrection(tx, groupID, expenseID, req.Title, req.Category); err != nil {
+			fmt.Println("Error learning category correction:", err)
+			http.Error(w, "Failed to update expense", http.StatusInternalServerError)
 			return
 		}
 	}
 
-	// 3. Refresh Splits (Delete Old -> Insert New)
-	_, err = tx.Exec(`DELETE FROM expense_splits WHERE expense_id=$1`, expenseID)
+	// Update the expense, refresh payers/splits/items and record the revision
+	version, err := updateExpense(tx, expenseID, &req, userID, revisionUpdate, expectedVersion)
 	if err != nil {
-		tx.Rollback()
-		http.Error(w, "Failed to clear old splits", http.StatusInternalServerError)
-		return
-	}
-
-	querySplit := `INSERT INTO expense_splits (expense_id, user_id, amount_owed) VALUES ($1, $2, $3)`
-	stmtSplit, _ := tx.Prepare(querySplit)
-	defer stmtSplit.Close()
-
-	for _, split := range req.Splits {
-		_, err := stmtSplit.Exec(expenseID, split.UserID, split.Amount)
-		if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "Expense not found", http.StatusNotFound)
+			return
+		}
+		if errors.Is(err, errVersionMismatch) {
 			tx.Rollback()
-			http.Error(w, "Failed to save new splits", http.StatusInternalServerError)
+			writePreconditionFailed(w, expenseID)
 			return
 		}
+		if isRefundRejected(err) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		fmt.Println("Error updating expense:", err)
+		http.Error(w, "Failed to update expense", http.StatusInternalServerError)
+		return
 	}
 
-	tx.Commit()
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("ETag", expenseETag(version))
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(map[string]string{"message": "Expense updated"})
-}
\ No newline at end of file
+}
+
+// storedExpenseDefaults returns the expense's group and its currency, and keeps the stored date, time and
+// timezone when an update leaves the date out (older clients don't send it).
+// The type and the refunded expense can't be changed by an update, so this runs before prepareExpense.
+func storedExpenseDefaults(expenseID any, req *CreateExpenseRequest) (int, string, error) {
+	var groupID int
+	var baseCurrency, date, clock, timezone string
+	err := db.DB.QueryRow(`
+		SELECT g.id, g.currency, TO_CHAR(e.expense_date, 'YYYY-MM-DD'), COALESCE(TO_CHAR(e.expense_time, 'HH24:MI'), ''), e.expense_timezone,
+		       e.type, COALESCE(e.refund_of, 0)
+		FROM expenses e
+		JOIN groups g ON e.group_id = g.id
+		WHERE e.id = $1 AND e.deleted_at IS NULL`, expenseID).Scan(&groupID, &baseCurrency, &date, &clock, &timezone,
+		&req.Type, &req.RefundOf)
+	if err != nil {
+		return 0, "", err
+	}
+	if req.Date == "" {
+		req.Date = date
+		if req.Time == "" && req.Timezone == "" {
+			req.Time, req.Timezone = clock, timezone
+		}
+	}
+	return groupID, baseCurrency, nil
+}
@@ -19,8 +19,11 @@ type Member struct {
 
 type ExpenseMatrixRow struct {
 	Title, Date, Payer string
-	TotalAmount        float64
+	TotalAmount        float64 // In the group currency
+	Original           string  // Original amount + currency, empty when already in the group currency
 	UserImpacts        map[int]float64
+	Income             bool
+	Settlement         bool // a recorded payment between members, not an expense
 }
 
 type SuggestedPayment struct {
@@ -33,9 +36,9 @@ type SuggestedPayment struct {
 func ExportGroupPDF(w http.ResponseWriter, r *http.Request) {
 	groupID := r.PathValue("id")
 
-	// 1. FETCH GROUP NAME
-	var groupName string
-	err := db.DB.QueryRow("SELECT name FROM groups WHERE id=$1", groupID).Scan(&groupName)
+	// 1. FETCH GROUP NAME & CURRENCY
+	var groupName, baseCurrency string
+	err := db.DB.QueryRow("SELECT name, currency FROM groups WHERE id=$1", groupID).Scan(&groupName, &baseCurrency)
 	if err != nil {
 		fmt.Println("Error fetching group:", err)
 		http.Error(w, "Group not found", http.StatusNotFound)
@@ -65,10 +68,10 @@ func ExportGroupPDF(w http.ResponseWriter, r *http.Request) {
 
 	// 3. FETCH EXPENSES
 	rowsExp, err := db.DB.Query(`
-        SELECT id, title, amount, created_at 
+        SELECT id, title, amount, currency, exchange_rate, TO_CHAR(expense_date, 'YYYY-MM-DD'), type
         FROM expenses 
-        WHERE group_id = $1 
-        ORDER BY created_at DESC`, groupID)
+        WHERE group_id = $1 AND status = 'final' AND deleted_at IS NULL
+        ORDER BY expense_date DESC, expense_time DESC NULLS LAST, created_at DESC`, groupID)
 
 	if err != nil {
 		http.Error(w, "Error fetching expenses", http.StatusInternalServerError)
@@ -80,27 +83,42 @@ func ExportGroupPDF(w http.ResponseWriter, r *http.Request) {
 	grandTotals := make(map[int]float64)
 
 	type ExpTemp struct {
-		ID        int
-		Title     string
-		Amount    float64
-		CreatedAt string
+		ID           int
+		Title        string
+		Amount       float64
+		Currency     string
+		ExchangeRate float64
+		Date         string
+		Type         string
 	}
 	var rawExpenses []ExpTemp
 	for rowsExp.Next() {
 		var e ExpTemp
-		rowsExp.Scan(&e.ID, &e.Title, &e.Amount, &e.CreatedAt)
+		rowsExp.Scan(&e.ID, &e.Title, &e.Amount, &e.Currency, &e.ExchangeRate, &e.Date, &e.Type)
 		rawExpenses = append(rawExpenses, e)
 	}
 
 	for _, raw := range rawExpenses {
+		// All impacts are converted into the group currency so the columns add up.
+		// Refunds and income work the other way round: the payer column received money
+		// and the splits are owed it. Their totals are negative, income gets its own colour.
+		sign := entrySign(raw.Type)
 		e := ExpenseMatrixRow{
 			Title:       raw.Title,
-			TotalAmount: raw.Amount,
+			TotalAmount: sign * raw.Amount * raw.ExchangeRate,
 			UserImpacts: make(map[int]float64),
+			Income:      raw.Type == typeIncome,
 		}
-		if len(raw.CreatedAt) >= 10 {
-			e.Date = raw.CreatedAt[:10]
+		if raw.Type == typeRefund && !strings.HasPrefix(raw.Title, "Refund") {
+			e.Title = "Refund: " + raw.Title
 		}
+		if raw.Type == typeIncome {
+			e.Title = "Income: " + raw.Title
+		}
+		if raw.Currency != baseCurrency {
+			e.Original = fmt.Sprintf("%.2f %s", sign*raw.Amount, raw.Currency)
+		}
+		e.Date = raw.Date
 
 		// A. Who Paid?
 		rowsPayers, err := db.DB.Query(`
@@ -120,7 +138,7 @@ func ExportGroupPDF(w http.ResponseWriter, r *http.Request) {
 			var amt float64
 			rowsPayers.Scan(&pName, &uID, &amt)
 			payerNames = append(payerNames, pName)
-			e.UserImpacts[uID] += amt 
+			e.UserImpacts[uID] += sign * amt * raw.ExchangeRate
 		}
 		rowsPayers.Close()
 		e.Payer = strings.Join(payerNames, ", ")
@@ -135,7 +153,7 @@ func ExportGroupPDF(w http.ResponseWriter, r *http.Request) {
 			var uID int
 			var amt float64
 			rowsSplits.Scan(&uID, &amt)
-			e.UserImpacts[uID] -= amt 
+			e.UserImpacts[uID] -= sign * amt * raw.ExchangeRate
 		}
 		rowsSplits.Close()
 
@@ -147,6 +165,47 @@ func ExportGroupPDF(w http.ResponseWriter, r *http.Request) {
 		matrixRows = append(matrixRows, e)
 	}
 
+	// 3b. FETCH RECORDED SETTLEMENTS
+	// A payment shows up like an expense paid by the sender and owed by the receiver, in its own colour
+	rowsSet, err := db.DB.Query(`
+        SELECT s.from_user, fu.name, s.to_user, tu.name, s.amount, s.currency, s.exchange_rate, TO_CHAR(s.settled_on, 'YYYY-MM-DD')
+        FROM settlements s
+        JOIN users fu ON fu.id = s.from_user
+        JOIN users tu ON tu.id = s.to_user
+        WHERE s.group_id = $1`, groupID)
+
+	if err != nil {
+		http.Error(w, "Error fetching settlements", http.StatusInternalServerError)
+		return
+	}
+	defer rowsSet.Close()
+
+	for rowsSet.Next() {
+		var fromID, toID int
+		var fromName, toName, currency, date string
+		var amount, rate float64
+		if err := rowsSet.Scan(&fromID, &fromName, &toID, &toName, &amount, &currency, &rate, &date); err != nil {
+			continue
+		}
+		e := ExpenseMatrixRow{
+			Title:       "Payment to " + toName,
+			Date:        date,
+			Payer:       fromName,
+			TotalAmount: amount * rate,
+			UserImpacts: map[int]float64{fromID: amount * rate, toID: -amount * rate},
+			Settlement:  true,
+		}
+		if currency != baseCurrency {
+			e.Original = fmt.Sprintf("%.2f %s", amount, currency)
+		}
+		for uid, impact := range e.UserImpacts {
+			grandTotals[uid] += impact
+		}
+		matrixRows = append(matrixRows, e)
+	}
+	// Expenses came sorted; settlements slot in by date
+	sort.SliceStable(matrixRows, func(i, j int) bool { return matrixRows[i].Date > matrixRows[j].Date })
+
 	// 4. CALCULATE SETTLEMENTS
 	var creditors, debtors []struct {
 		ID      int
@@ -231,7 +290,8 @@ func ExportGroupPDF(w http.ResponseWriter, r *http.Request) {
 	titleColW := 45.0
 	payerColW := 30.0
 	totalColW := 25.0
-	fixedWidth := dateColW + titleColW + payerColW + totalColW
+	originalColW := 25.0
+	fixedWidth := dateColW + titleColW + payerColW + totalColW + originalColW
 	
 	// Member Columns (Ensure at least 25mm per person so names fit)
 	minMemberW := 25.0 
@@ -269,6 +329,8 @@ func ExportGroupPDF(w http.ResponseWriter, r *http.Request) {
 	lightOrange  := []int{255, 248, 242}  // Subtle Orange
 	headerBg     := []int{255, 240, 230}  // Darker Orange Header
 	netBalBg     := []int{255, 230, 215}  // Minimalist Net Balance
+	incomeBg     := []int{235, 248, 238}  // Subtle Green for income rows
+	settlementBg := []int{232, 240, 254}  // Subtle Blue for recorded payments
 	textColor    := []int{40, 40, 40}
 	lineColor    := []int{230, 230, 230}
 
@@ -285,7 +347,7 @@ func ExportGroupPDF(w http.ResponseWriter, r *http.Request) {
 	pdf.SetFont("Arial", "", 12)
 	pdf.SetTextColor(100, 100, 100)
 	pdf.Cell(10, 10, "")
-	pdf.Cell(0, 8, fmt.Sprintf("%s  |  %s", groupName, time.Now().Format("Jan 02, 2006")))
+	pdf.Cell(0, 8, fmt.Sprintf("%s  |  %s  |  All amounts in %s", groupName, time.Now().Format("Jan 02, 2006"), baseCurrency))
 	pdf.Ln(15)
 
 	// --- 4. TABLE HEADER ---
@@ -300,6 +362,7 @@ func ExportGroupPDF(w http.ResponseWriter, r *http.Request) {
 		{"Description", titleColW, "L"},
 		{"Paid By", payerColW, "L"},
 		{"Total", totalColW, "R"},
+		{"Original", originalColW, "R"},
 	}
 
 	for _, h := range headers {
@@ -323,7 +386,11 @@ func ExportGroupPDF(w http.ResponseWriter, r *http.Request) {
 	fillRow := false 
 
 	for _, row := range matrixRows {
-		if fillRow {
+		if row.Settlement {
+			pdf.SetFillColor(settlementBg[0], settlementBg[1], settlementBg[2])
+		} else if row.Income {
+			pdf.SetFillColor(incomeBg[0], incomeBg[1], incomeBg[2])
+		} else if fillRow {
 			pdf.SetFillColor(lightOrange[0], lightOrange[1], lightOrange[2])
 		} else {
 			pdf.SetFillColor(255, 255, 255)
@@ -346,6 +413,12 @@ func ExportGroupPDF(w http.ResponseWriter, r *http.Request) {
 		pdf.CellFormat(totalColW, 9, fmt.Sprintf("%.2f", row.TotalAmount), "B", 0, "R", true, 0, "")
 		pdf.SetFont("Arial", "", 9)
 
+		original := row.Original
+		if original == "" {
+			original = "-"
+		}
+		pdf.CellFormat(originalColW, 9, original, "B", 0, "R", true, 0, "")
+
 		for _, m := range members {
 			impact := row.UserImpacts[m.ID]
 			txt := "-"
@@ -374,7 +447,7 @@ func ExportGroupPDF(w http.ResponseWriter, r *http.Request) {
 	pdf.SetFillColor(netBalBg[0], netBalBg[1], netBalBg[2]) 
 	pdf.SetTextColor(0, 0, 0)
 
-	pdf.CellFormat(dateColW+titleColW+payerColW+totalColW, 10, "NET BALANCE  ", "0", 0, "R", true, 0, "")
+	pdf.CellFormat(fixedWidth, 10, "NET BALANCE  ", "0", 0, "R", true, 0, "")
 
 	for _, m := range members {
 		total := grandTotals[m.ID]
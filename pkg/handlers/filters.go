@@ -0,0 +1,215 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultExpensePage = 50
+	maxExpensePage     = 200
+)
+
+// expenseSorts maps ?sort= to the SQL key it orders by and the type that key is compared as.
+// Every sort breaks ties on e.id, which makes (key, id) a stable keyset for cursors.
+var expenseSorts = map[string]struct {
+	expr         string
+	cast         string
+	defaultOrder string
+}{
+	"date":    {"(e.expense_date + COALESCE(e.expense_time, TIME '00:00'))", "timestamp", "desc"},
+	"created": {"e.created_at", "timestamp", "desc"},
+	"amount":  {"(e.amount * e.exchange_rate)", "numeric", "desc"},
+	"title":   {"LOWER(e.title)", "text", "asc"},
+}
+
+// expenseCursor marks the last row of a page; it is handed out base64-encoded and opaque to clients
+type expenseCursor struct {
+	Sort  string `json:"s"`
+	Order string `json:"o"`
+	Key   string `json:"k"`
+	ID    int    `json:"id"`
+}
+
+func (c expenseCursor) encode() string {
+	raw, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func decodeExpenseCursor(s string) (*expenseCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+	var c expenseCursor
+	if err := json.Unmarshal(raw, &c); err != nil || c.ID == 0 {
+		return nil, errors.New("invalid cursor")
+	}
+	return &c, nil
+}
+
+// expenseQuery is a parsed GET /groups/{id}/expenses request
+type expenseQuery struct {
+	where     []string
+	args      []any
+	sort      string
+	order     string
+	limit     int
+	paginated bool
+}
+
+func (q *expenseQuery) arg(value any) string {
+	q.args = append(q.args, value)
+	return fmt.Sprintf("$%d", len(q.args))
+}
+
+// parseExpenseQuery turns the list filters into SQL conditions on expenses e.
+// Supported: from, to, category (includes sub-categories), type, payer, participant,
+// min_amount, max_amount (in the group currency), q, sort, order, limit, cursor.
+func parseExpenseQuery(r *http.Request, groupID any) (*expenseQuery, error) {
+	params := r.URL.Query()
+	q := &expenseQuery{}
+	group := q.arg(groupID)
+	q.where = append(q.where, "e.group_id = "+group, "e.deleted_at IS NULL")
+
+	from, err := dateParam(r, "from")
+	if err != nil {
+		return nil, err
+	}
+	if from != nil {
+		q.where = append(q.where, "e.expense_date >= "+q.arg(nullableDate(from)))
+	}
+	to, err := dateParam(r, "to")
+	if err != nil {
+		return nil, err
+	}
+	if to != nil {
+		q.where = append(q.where, "e.expense_date <= "+q.arg(nullableDate(to)))
+	}
+
+	if category := strings.TrimSpace(params.Get("category")); category != "" {
+		name := q.arg(category)
+		q.where = append(q.where, `LOWER(e.category) IN (
+			WITH RECURSIVE tree AS (
+				SELECT id, name FROM categories
+				WHERE (group_id IS NULL OR group_id = `+group+`) AND LOWER(name) = LOWER(`+name+`)
+				UNION
+				SELECT c.id, c.name FROM categories c JOIN tree t ON c.parent_id = t.id
+				WHERE c.group_id IS NULL OR c.group_id = `+group+`
+			)
+			SELECT LOWER(name) FROM tree
+			UNION SELECT LOWER(`+name+`))`)
+	}
+
+	if kind := params.Get("type"); kind != "" {
+		if kind != typeExpense && kind != typeRefund && kind != typeIncome {
+			return nil, fmt.Errorf("unknown type %q", kind)
+		}
+		q.where = append(q.where, "e.type = "+q.arg(kind))
+	}
+
+	for _, param := range []string{"payer", "participant"} {
+		value := params.Get(param)
+		if value == "" {
+			continue
+		}
+		userID, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s must be a user id", param)
+		}
+		user := q.arg(userID)
+		condition := "EXISTS (SELECT 1 FROM expense_payers ep WHERE ep.expense_id = e.id AND ep.user_id = " + user + ")"
+		if param == "participant" {
+			condition = "(" + condition + " OR EXISTS (SELECT 1 FROM expense_splits es WHERE es.expense_id = e.id AND es.user_id = " + user + "))"
+		}
+		q.where = append(q.where, condition)
+	}
+
+	for _, bound := range []struct{ param, op string }{{"min_amount", ">="}, {"max_amount", "<="}} {
+		param, op := bound.param, bound.op
+		value := params.Get(param)
+		if value == "" {
+			continue
+		}
+		amount, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s must be a number", param)
+		}
+		q.where = append(q.where, "e.amount * e.exchange_rate "+op+" "+q.arg(amount))
+	}
+
+	if text := strings.TrimSpace(params.Get("q")); text != "" {
+		pattern := q.arg("%" + escapeLike(text) + "%")
+		q.where = append(q.where, "(e.title ILIKE "+pattern+" OR COALESCE(e.description, '') ILIKE "+pattern+")")
+	}
+
+	// Sorting
+	q.sort = params.Get("sort")
+	if q.sort == "" {
+		q.sort = "date"
+	}
+	sort, ok := expenseSorts[q.sort]
+	if !ok {
+		return nil, errors.New("sort must be one of date, created, amount, title")
+	}
+	q.order = strings.ToLower(params.Get("order"))
+	if q.order == "" {
+		q.order = sort.defaultOrder
+	}
+	if q.order != "asc" && q.order != "desc" {
+		return nil, errors.New("order must be asc or desc")
+	}
+
+	// Pagination only kicks in when asked for, so existing clients keep getting the full list
+	if params.Get("limit") != "" || params.Get("cursor") != "" {
+		q.paginated = true
+		q.limit, _ = pageParams(r, defaultExpensePage, maxExpensePage)
+	}
+	if value := params.Get("cursor"); value != "" {
+		cursor, err := decodeExpenseCursor(value)
+		if err != nil {
+			return nil, err
+		}
+		if cursor.Sort != q.sort || cursor.Order != q.order {
+			return nil, errors.New("cursor does not match the requested sort")
+		}
+		op := "<"
+		if q.order == "asc" {
+			op = ">"
+		}
+		q.where = append(q.where, fmt.Sprintf("(%s, e.id) %s (%s::%s, %s)",
+			sort.expr, op, q.arg(cursor.Key), sort.cast, q.arg(cursor.ID)))
+	}
+	return q, nil
+}
+
+// sortKey is the SQL expression selected (as text) to build the next cursor
+func (q *expenseQuery) sortKey() string {
+	return expenseSorts[q.sort].expr + "::text"
+}
+
+func (q *expenseQuery) whereClause() string {
+	return strings.Join(q.where, "\n		  AND ")
+}
+
+func (q *expenseQuery) orderClause() string {
+	direction := strings.ToUpper(q.order)
+	return fmt.Sprintf("%s %s, e.id %s", expenseSorts[q.sort].expr, direction, direction)
+}
+
+// limitClause fetches one extra row so we know whether there is a next page
+func (q *expenseQuery) limitClause() string {
+	if !q.paginated {
+		return ""
+	}
+	return "LIMIT " + q.arg(q.limit+1)
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
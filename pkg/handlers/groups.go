@@ -10,7 +10,8 @@ import (
 )
 
 type CreateGroupRequest struct {
-	Name string `json:"name"`
+	Name     string `json:"name"`
+	Currency string `json:"currency"`
 }
 
 func CreateGroup(w http.ResponseWriter, r *http.Request) {
@@ -25,6 +26,14 @@ func CreateGroup(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Bad Request", http.StatusBadRequest)
 		return
 	}
+	if req.Currency == "" {
+		req.Currency = defaultCurrency
+	}
+	currency, valid := normalizeCurrency(req.Currency)
+	if !valid {
+		http.Error(w, "Invalid currency code", http.StatusBadRequest)
+		return
+	}
 	tx, err := db.DB.Begin()
 	if err != nil {
 		http.Error(w, "Server Error", http.StatusInternalServerError)
@@ -33,8 +42,8 @@ func CreateGroup(w http.ResponseWriter, r *http.Request) {
 	defer tx.Rollback()
 
 	var groupID int
-	queryGroup := `INSERT INTO groups (name,created_by) VALUES ($1,$2) RETURNING id`
-	err = tx.QueryRow(queryGroup, req.Name, userId).Scan(&groupID)
+	queryGroup := `INSERT INTO groups (name,created_by,currency) VALUES ($1,$2,$3) RETURNING id`
+	err = tx.QueryRow(queryGroup, req.Name, userId, currency).Scan(&groupID)
 	if err != nil {
 		fmt.Println(" GROUP INSERT ERROR:", err)
 		http.Error(w, "Failed to create Group", http.StatusInternalServerError)
@@ -55,6 +64,7 @@ func CreateGroup(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"message":  "Group created successfully",
 		"group_Id": groupID,
+		"currency": currency,
 	})
 	fmt.Printf("User %d created Group %d\n", userId, groupID)
 
@@ -73,10 +83,24 @@ func AddMember(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	tx, err := db.DB.Begin()
+	if err != nil {
+		http.Error(w, "Server Error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	// Lock the group so concurrent adds of the same person serialize on the membership check
+	var lockedID int
+	if err := tx.QueryRow(`SELECT id FROM groups WHERE id = $1 FOR UPDATE`, groupId).Scan(&lockedID); err != nil {
+		http.Error(w, "Group not found", http.StatusNotFound)
+		return
+	}
+
 	var memberID int
 
 	queryFind := `SELECT id from users WHERE email=$1`
-	err := db.DB.QueryRow(queryFind, req.Email).Scan(&memberID)
+	err = tx.QueryRow(queryFind, req.Email).Scan(&memberID)
 
 	if err != nil {
 		if req.Name == "" {
@@ -88,7 +112,7 @@ func AddMember(w http.ResponseWriter, r *http.Request) {
 
 		queryCreateGhost := `INSERT INTO users (name, email, is_ghost) VALUES ($1, $2, TRUE) RETURNING id`
 
-		err = db.DB.QueryRow(queryCreateGhost, req.Name, req.Email).Scan(&memberID)
+		err = tx.QueryRow(queryCreateGhost, req.Name, req.Email).Scan(&memberID)
 		if err != nil {
 			fmt.Println("Error creating ghost:", err)
 			http.Error(w, "Failed to create ghost user", http.StatusInternalServerError)
@@ -98,17 +122,21 @@ func AddMember(w http.ResponseWriter, r *http.Request) {
 
 	var exists bool
 	checkQuery := `SELECT EXISTS(SELECT 1 FROM group_members WHERE group_id=$1 AND user_id=$2)`
-	_ = db.DB.QueryRow(checkQuery, groupId, memberID).Scan(&exists)
+	_ = tx.QueryRow(checkQuery, groupId, memberID).Scan(&exists)
 	if exists {
 		http.Error(w, "User is already in the group", http.StatusConflict)
 		return
 	}
 
-	_, err = db.DB.Exec("INSERT INTO group_members (group_id, user_id) VALUES ($1, $2)", groupId, memberID)
+	_, err = tx.Exec("INSERT INTO group_members (group_id, user_id) VALUES ($1, $2)", groupId, memberID)
 	if err != nil {
 		http.Error(w, "Failed to add member", http.StatusInternalServerError)
 		return
 	}
+	if err = tx.Commit(); err != nil {
+		http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
+		return
+	}
 
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(map[string]any{
@@ -122,7 +150,7 @@ func AddMember(w http.ResponseWriter, r *http.Request) {
 func GetGroups(w http.ResponseWriter, r *http.Request) {
 	userID := r.Context().Value(middleware.UserIDKey).(int)
 	query := `
-		SELECT g.id, g.name 
+		SELECT g.id, g.name, g.currency, g.require_confirmation
 		FROM groups g
 		JOIN group_members gm ON g.id = gm.group_id
 		WHERE gm.user_id = $1
@@ -136,9 +164,10 @@ func GetGroups(w http.ResponseWriter, r *http.Request) {
 	var groups []map[string]interface{}
 	for rows.Next() {
 		var id int
-		var name string
-		rows.Scan(&id, &name)
-		groups = append(groups, map[string]any{"id": id, "name": name})
+		var name, currency string
+		var requireConfirmation bool
+		rows.Scan(&id, &name, &currency, &requireConfirmation)
+		groups = append(groups, map[string]any{"id": id, "name": name, "currency": currency, "require_confirmation": requireConfirmation})
 	}
 
 	if groups == nil {
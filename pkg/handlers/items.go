@@ -0,0 +1,190 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/lib/pq"
+)
+
+// ExpenseItem is one line of an itemized receipt, shared equally by SharedBy
+type ExpenseItem struct {
+	ID       int     `json:"id,omitempty"`
+	Name     string  `json:"name"`
+	Price    float64 `json:"price"`
+	SharedBy []int   `json:"shared_by"`
+}
+
+// toCents avoids float drift while distributing money
+func toCents(amount float64) int64 {
+	return int64(math.Round(amount * 100))
+}
+
+func fromCents(cents int64) float64 {
+	return float64(cents) / 100
+}
+
+// validateItems checks the receipt lines themselves, regardless of who shares them
+func validateItems(items []ExpenseItem) error {
+	for i, item := range items {
+		if item.Name == "" {
+			return fmt.Errorf("item %d needs a name", i+1)
+		}
+		if item.Price <= 0 {
+			return fmt.Errorf("item %q needs a positive price", item.Name)
+		}
+		seen := make(map[int]bool, len(item.SharedBy))
+		for _, uid := range item.SharedBy {
+			if seen[uid] {
+				return fmt.Errorf("item %q lists user %d more than once", item.Name, uid)
+			}
+			seen[uid] = true
+		}
+	}
+	return nil
+}
+
+// itemsTotal is the receipt subtotal before tax, tip and service charge
+func itemsTotal(items []ExpenseItem) float64 {
+	var cents int64
+	for _, item := range items {
+		cents += toCents(item.Price)
+	}
+	return fromCents(cents)
+}
+
+// itemizedSplits turns receipt lines into per-user shares.
+// Each item is split equally among its sharers; tax, tip and service charge are then
+// distributed proportionally to each person's subtotal. Leftover cents go to the largest
+// fractional remainders so the splits always add up to the exact total.
+func itemizedSplits(items []ExpenseItem, extras float64) ([]Split, float64, error) {
+	if err := validateItems(items); err != nil {
+		return nil, 0, err
+	}
+
+	subtotals := make(map[int]int64)
+	var subtotal int64
+
+	for _, item := range items {
+		if len(item.SharedBy) == 0 {
+			return nil, 0, fmt.Errorf("item %q is not shared by anyone", item.Name)
+		}
+
+		sharers := append([]int(nil), item.SharedBy...)
+		sort.Ints(sharers)
+
+		cents := toCents(item.Price)
+		each := cents / int64(len(sharers))
+		remainder := cents % int64(len(sharers))
+		for j, uid := range sharers {
+			subtotals[uid] += each
+			if int64(j) < remainder {
+				subtotals[uid]++
+			}
+		}
+		subtotal += cents
+	}
+
+	extraCents := toCents(extras)
+	if extraCents < 0 {
+		return nil, 0, fmt.Errorf("tax, tip and service charge cannot be negative")
+	}
+
+	// Proportional share of the extras, largest remainder method
+	userIDs := make([]int, 0, len(subtotals))
+	for uid := range subtotals {
+		userIDs = append(userIDs, uid)
+	}
+	sort.Ints(userIDs)
+
+	shares := make(map[int]int64)
+	fractions := make(map[int]float64)
+	var allocated int64
+	for _, uid := range userIDs {
+		exact := float64(extraCents) * float64(subtotals[uid]) / float64(subtotal)
+		shares[uid] = int64(math.Floor(exact))
+		fractions[uid] = exact - math.Floor(exact)
+		allocated += shares[uid]
+	}
+
+	byFraction := append([]int(nil), userIDs...)
+	sort.SliceStable(byFraction, func(i, j int) bool { return fractions[byFraction[i]] > fractions[byFraction[j]] })
+	for i := 0; allocated < extraCents; i++ {
+		shares[byFraction[i%len(byFraction)]]++
+		allocated++
+	}
+
+	splits := make([]Split, 0, len(userIDs))
+	for _, uid := range userIDs {
+		splits = append(splits, Split{UserID: uid, Amount: fromCents(subtotals[uid] + shares[uid])})
+	}
+	return splits, fromCents(subtotal + extraCents), nil
+}
+
+// saveItems replaces the receipt lines of an expense
+func saveItems(tx *sql.Tx, expenseID any, items []ExpenseItem) error {
+	if _, err := tx.Exec(`DELETE FROM expense_items WHERE expense_id = $1`, expenseID); err != nil {
+		return err
+	}
+
+	for i, item := range items {
+		var itemID int
+		err := tx.QueryRow(
+			`INSERT INTO expense_items (expense_id, name, price, position) VALUES ($1, $2, $3, $4) RETURNING id`,
+			expenseID, item.Name, item.Price, i,
+		).Scan(&itemID)
+		if err != nil {
+			return err
+		}
+
+		for _, uid := range item.SharedBy {
+			_, err := tx.Exec(
+				`INSERT INTO expense_item_shares (item_id, user_id) VALUES ($1, $2) ON CONFLICT DO NOTHING`,
+				itemID, uid,
+			)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// queryer is satisfied by both *sql.DB and *sql.Tx
+type queryer interface {
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// loadItems returns the receipt lines of an expense in their original order
+func loadItems(q queryer, expenseID any) ([]ExpenseItem, error) {
+	rows, err := q.Query(`
+		SELECT i.id, i.name, i.price,
+		       COALESCE(ARRAY_AGG(s.user_id ORDER BY s.user_id) FILTER (WHERE s.user_id IS NOT NULL), '{}')
+		FROM expense_items i
+		LEFT JOIN expense_item_shares s ON s.item_id = i.id
+		WHERE i.expense_id = $1
+		GROUP BY i.id
+		ORDER BY i.position, i.id`, expenseID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var items []ExpenseItem
+	for rows.Next() {
+		var item ExpenseItem
+		var sharedBy pq.Int64Array
+		if err := rows.Scan(&item.ID, &item.Name, &item.Price, &sharedBy); err != nil {
+			return nil, err
+		}
+		item.SharedBy = make([]int, len(sharedBy))
+		for i, uid := range sharedBy {
+			item.SharedBy[i] = int(uid)
+		}
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestItemizedSplits(t *testing.T) {
+	items := []ExpenseItem{
+		{Name: "Pizza", Price: 20, SharedBy: []int{7, 5}},
+		{Name: "Wine", Price: 10.01, SharedBy: []int{5, 7, 9}},
+	}
+	splits, total, err := itemizedSplits(items, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 33.01 {
+		t.Fatalf("total = %.2f, want 33.01", total)
+	}
+
+	got := map[int]float64{}
+	var sum int64
+	for _, s := range splits {
+		got[s.UserID] = s.Amount
+		sum += toCents(s.Amount)
+	}
+	if sum != 3301 {
+		t.Fatalf("splits add up to %d cents, want 3301", sum)
+	}
+	// Subtotals 13.34, 13.34 and 3.33 (the wine's spare cents go to the lowest ids); the 3.00 extra
+	// is spread by subtotal and its leftover cent goes to the largest remainder
+	if got[5] != 14.68 || got[7] != 14.67 || got[9] != 3.66 {
+		t.Fatalf("splits = %v", got)
+	}
+}
+
+func TestItemizedSplitsRejectsRepeatedSharers(t *testing.T) {
+	items := []ExpenseItem{{Name: "Pizza", Price: 20, SharedBy: []int{5, 5, 7}}}
+	_, _, err := itemizedSplits(items, 0)
+	if err == nil || !strings.Contains(err.Error(), "more than once") {
+		t.Fatalf("err = %v, want a repeated sharer error", err)
+	}
+}
+
+func TestCheckParticipants(t *testing.T) {
+	members := map[int]bool{1: true, 2: true}
+	tests := []struct {
+		name string
+		req  CreateExpenseRequest
+		ok   bool
+	}{
+		{"members only", CreateExpenseRequest{
+			Payers: []PayerSplit{{UserID: 1}}, Splits: []Split{{UserID: 2}},
+			Items: []ExpenseItem{{Name: "Tea", SharedBy: []int{1, 2}}},
+		}, true},
+		{"outside payer", CreateExpenseRequest{Payers: []PayerSplit{{UserID: 3}}}, false},
+		{"outside split", CreateExpenseRequest{Splits: []Split{{UserID: 3}}}, false},
+		{"outside sharer", CreateExpenseRequest{Items: []ExpenseItem{{Name: "Tea", SharedBy: []int{1, 3}}}}, false},
+	}
+	for _, tt := range tests {
+		if err := checkParticipants(members, &tt.req); (err == nil) != tt.ok {
+			t.Errorf("%s: err = %v", tt.name, err)
+		}
+	}
+}
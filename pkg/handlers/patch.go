@@ -0,0 +1,229 @@
+package handlers
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"mime"
+	"net/http"
+	"time"
+
+	"money-splitter/pkg/db"
+	"money-splitter/pkg/middleware"
+)
+
+const mergePatchMediaType = "application/merge-patch+json"
+
+// PatchExpense applies a JSON Merge Patch (RFC 7386) to an expense.
+// Fields that aren't in the patch keep their stored values. A new amount is split again with the
+// stored split mode (equal, percent, shares) and single or multiple payers are scaled to it;
+// exact splits can't be guessed, so the patch must then carry new splits as well.
+func PatchExpense(w http.ResponseWriter, r *http.Request) {
+	expenseID := r.PathValue("id")
+	userID := r.Context().Value(middleware.UserIDKey).(int)
+
+	if mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil ||
+		(mediaType != mergePatchMediaType && mediaType != "application/json") {
+		http.Error(w, "Content-Type must be "+mergePatchMediaType, http.StatusUnsupportedMediaType)
+		return
+	}
+	expectedVersion, err := ifMatchVersion(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+	var patch map[string]any
+	if err := json.Unmarshal(body, &patch); err != nil {
+		http.Error(w, "Patch must be a JSON object", http.StatusBadRequest)
+		return
+	}
+
+	if _, ok := requireExpenseMember(w, expenseID, userID); !ok {
+		return
+	}
+
+	// 1. Apply the patch to the stored expense. The merge replaces the whole expense, so without
+	// If-Match the version read here is expected on save: a change made in between fails the
+	// update instead of being overwritten. It's read before the snapshot so it can't be newer.
+	var storedVersion int
+	err = db.DB.QueryRow(`SELECT version FROM expenses WHERE id = $1 AND deleted_at IS NULL`, expenseID).Scan(&storedVersion)
+	if err != nil {
+		http.Error(w, "Expense not found", http.StatusNotFound)
+		return
+	}
+	ifMatch := expectedVersion != 0
+	if !ifMatch {
+		expectedVersion = storedVersion
+	}
+	current, err := loadExpenseSnapshot(db.DB, expenseID, false)
+	if err != nil {
+		http.Error(w, "Expense not found", http.StatusNotFound)
+		return
+	}
+	req, err := mergeExpensePatch(current, patch)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// 2. Validate like a full update
+	groupID, baseCurrency, err := storedExpenseDefaults(expenseID, req)
+	if err != nil {
+		http.Error(w, "Expense not found", http.StatusNotFound)
+		return
+	}
+	if err := prepareExpense(req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	day, err := resolveExpenseDate(req, time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := checkRefund(groupID, req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := checkGroupParticipants(groupID, req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !applyCategory(w, groupID, req) {
+		return
+	}
+	if err := applyCurrency(req, baseCurrency, day); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// 3. Save
+	tx, err := db.DB.Begin()
+	if err != nil {
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	if patch["category"] != nil {
+		if err := learnCategoryCorrection(tx, groupID, expenseID, req.Title, req.Category); err != nil {
+			fmt.Println("Error learning category correction:", err)
+			http.Error(w, "Failed to update expense", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	version, err := updateExpense(tx, expenseID, req, userID, revisionUpdate, expectedVersion)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "Expense not found", http.StatusNotFound)
+			return
+		}
+		if errors.Is(err, errVersionMismatch) && !ifMatch {
+			http.Error(w, "The expense changed while the patch was applied, try again", http.StatusConflict)
+			return
+		}
+		if errors.Is(err, errVersionMismatch) {
+			tx.Rollback()
+			writePreconditionFailed(w, expenseID)
+			return
+		}
+		if isRefundRejected(err) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		fmt.Println("Error patching expense:", err)
+		http.Error(w, "Failed to update expense", http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("ETag", expenseETag(version))
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"message": "Expense updated"})
+}
+
+// mergeExpensePatch merges the patch into the current snapshot and decides what has to be recomputed
+func mergeExpensePatch(current *CreateExpenseRequest, patch map[string]any) (*CreateExpenseRequest, error) {
+	merged, err := json.Marshal(mergePatch(snapshotFields(current), patch))
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(merged))
+	dec.DisallowUnknownFields()
+	var req CreateExpenseRequest
+	if err := dec.Decode(&req); err != nil {
+		return nil, fmt.Errorf("Invalid patch: %v", err)
+	}
+
+	_, patchedSplits := patch["splits"]
+	_, patchedItems := patch["items"]
+	_, patchedPayers := patch["payers"]
+	_, patchedRate := patch["exchange_rate"]
+	_, patchedCurrency := patch["currency"]
+	_, patchedDate := patch["date"]
+
+	if math.Abs(req.Amount-current.Amount) > 0.001 {
+		if req.SplitMode == splitExact && len(req.Splits) > 0 && !patchedSplits && !patchedItems {
+			return nil, errors.New("The splits are exact amounts; send new splits together with the amount")
+		}
+		if !patchedPayers {
+			req.Payers = scalePayers(req.Payers, req.Amount)
+		}
+	}
+	// A different currency or day needs that day's rate, unless the patch brings its own
+	if (patchedCurrency || patchedDate) && !patchedRate {
+		req.ExchangeRate = 0
+	}
+	return &req, nil
+}
+
+// scalePayers spreads a new total over the payers in proportion to what they paid before
+func scalePayers(payers []PayerSplit, amount float64) []PayerSplit {
+	if len(payers) == 0 {
+		return payers
+	}
+	weights := make([]float64, len(payers))
+	for i, p := range payers {
+		weights[i] = p.PaidAmount
+	}
+	scaled := make([]PayerSplit, len(payers))
+	for i, cents := range distributeCents(toCents(amount), weights) {
+		scaled[i] = PayerSplit{UserID: payers[i].UserID, PaidAmount: fromCents(cents)}
+	}
+	return scaled
+}
+
+// mergePatch implements RFC 7386: objects merge recursively, null removes a member,
+// anything else (arrays included) replaces the target value.
+func mergePatch(target any, patch any) any {
+	patchObject, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+	targetObject, ok := target.(map[string]any)
+	if !ok {
+		targetObject = make(map[string]any)
+	}
+	for key, value := range patchObject {
+		if value == nil {
+			delete(targetObject, key)
+			continue
+		}
+		targetObject[key] = mergePatch(targetObject[key], value)
+	}
+	return targetObject
+}
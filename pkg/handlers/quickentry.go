@@ -0,0 +1,699 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"money-splitter/pkg/db"
+	"money-splitter/pkg/middleware"
+)
+
+// QuickEntryRequest is a one-line description of an expense, e.g.
+// "Dinner 84.50 paid by Sam split with Alex and Jo"
+type QuickEntryRequest struct {
+	Text     string `json:"text"`
+	Timezone string `json:"timezone,omitempty"` // what "today", "yesterday" and weekdays mean
+	Create   bool   `json:"create,omitempty"`   // save the expense right away when nothing is ambiguous
+}
+
+type ParseAmbiguity struct {
+	Field      string            `json:"field"` // title, amount, currency, date, payers or splits
+	Text       string            `json:"text,omitempty"`
+	Message    string            `json:"message"`
+	Candidates []MemberCandidate `json:"candidates,omitempty"`
+}
+
+type MemberCandidate struct {
+	UserID int    `json:"user_id"`
+	Name   string `json:"name"`
+}
+
+type QuickEntryResponse struct {
+	Draft       CreateExpenseRequest `json:"draft"`
+	Ambiguities []ParseAmbiguity     `json:"ambiguities"`
+}
+
+// Currencies a symbol can stand for; the group currency wins when it is one of them, otherwise the
+// symbol is ambiguous unless it only has one
+var currencySymbols = map[string][]string{
+	"$": {"USD", "CAD", "AUD", "NZD", "SGD", "HKD", "MXN"},
+	"€": {"EUR"},
+	"£": {"GBP"},
+	"¥": {"JPY", "CNY"},
+	"₹": {"INR"},
+}
+
+var (
+	amountPattern    = regexp.MustCompile(`^([$€£¥₹])?(\d[\d.,]*)([$€£¥₹]|[A-Za-z]{3})?$`)
+	slashDatePattern = regexp.MustCompile(`^(\d{1,2})/(\d{1,2})(?:/(\d{2}|\d{4}))?$`)
+)
+
+type quickToken struct {
+	raw  string // as typed, without surrounding punctuation
+	word string // lower case
+	sep  bool   // separates names in a list: ",", "and", "&", "+"
+	used bool   // taken as the amount, currency or date
+}
+
+// quickPhrase is a run of words after a keyword ("paid by", "with", "for") or the title words
+type quickPhrase struct {
+	mode    string
+	keyword string
+	tokens  []quickToken
+}
+
+const (
+	phraseTitle  = "title"
+	phrasePayers = "payers"
+	phraseWith   = "with" // the payers share too
+	phraseFor    = "for"  // only the people listed share
+)
+
+// quickParser turns text into a draft expense with fixed rules, so the same text always gives
+// the same draft. Anything it can't decide is reported instead of guessed:
+//   - one amount, with an optional symbol or ISO code ("84.50", "€12", "20 EUR"); "1,234" is ambiguous
+//   - an optional date: today, yesterday, (last) weekday, YYYY-MM-DD or d/m[/y] (ambiguous when both parts are ≤ 12)
+//   - "paid by" names the payers (default: the caller), "<names> paid" works too
+//   - "with" adds people to the payers, "for"/"between"/"among" lists everybody who shares;
+//     without either the whole group shares equally
+//   - names match a member's full name, first name or email; "me", "I" and "everyone" are understood
+//   - the remaining words are the title
+type quickParser struct {
+	members      []mentionable
+	me           int
+	today        time.Time
+	baseCurrency string
+
+	tokens      []quickToken
+	draft       CreateExpenseRequest
+	ambiguities []ParseAmbiguity
+}
+
+func (p *quickParser) parse(text string) QuickEntryResponse {
+	p.tokens = tokenizeQuickEntry(text)
+	p.ambiguities = []ParseAmbiguity{}
+	p.findDate()
+	p.findAmount()
+	p.findPeopleAndTitle()
+	return QuickEntryResponse{Draft: p.draft, Ambiguities: p.ambiguities}
+}
+
+func (p *quickParser) ambiguous(field, text, message string, candidates ...MemberCandidate) {
+	p.ambiguities = append(p.ambiguities, ParseAmbiguity{Field: field, Text: text, Message: message, Candidates: candidates})
+}
+
+func tokenizeQuickEntry(text string) []quickToken {
+	var tokens []quickToken
+	for _, field := range strings.Fields(text) {
+		raw := strings.TrimRight(field, ",;:!?")
+		comma := strings.ContainsAny(field[len(raw):], ",;")
+		raw = strings.Trim(strings.TrimSuffix(raw, "."), `"'()`)
+
+		if raw != "" {
+			word := strings.ToLower(raw)
+			sep := word == "and" || word == "&" || word == "+"
+			tokens = append(tokens, quickToken{raw: raw, word: word, sep: sep})
+		}
+		if comma {
+			tokens = append(tokens, quickToken{sep: true})
+		}
+	}
+	return tokens
+}
+
+// --- DATE ---
+
+func (p *quickParser) findDate() {
+	var days []time.Time
+	var texts []string
+	undecided := false
+	for i := range p.tokens {
+		t := &p.tokens[i]
+		if t.used || t.sep {
+			continue
+		}
+
+		day, matched, problem := p.parseDay(i)
+		if !matched {
+			continue
+		}
+		t.used = true
+		if i > 0 && p.tokens[i-1].word == "on" {
+			p.tokens[i-1].used = true
+		}
+		if problem != "" {
+			p.ambiguous("date", t.raw, problem)
+			undecided = true
+			continue
+		}
+		days = append(days, day)
+		texts = append(texts, t.raw)
+	}
+
+	switch {
+	case len(days) == 1:
+		p.draft.Date = days[0].Format(dateLayout)
+	case len(days) > 1:
+		p.ambiguous("date", strings.Join(texts, ", "), "More than one date was given")
+	case !undecided:
+		p.draft.Date = p.today.Format(dateLayout)
+	}
+}
+
+// parseDay reads the date at token i; problem is set when it looks like a date but can't be decided
+func (p *quickParser) parseDay(i int) (day time.Time, matched bool, problem string) {
+	t := p.tokens[i]
+	switch t.word {
+	case "today":
+		return p.today, true, ""
+	case "yesterday":
+		return p.today.AddDate(0, 0, -1), true, ""
+	}
+
+	for weekday := time.Sunday; weekday <= time.Saturday; weekday++ {
+		if t.word != strings.ToLower(weekday.String()) {
+			continue
+		}
+		back := (int(p.today.Weekday()) - int(weekday) + 7) % 7
+		if i > 0 && p.tokens[i-1].word == "last" {
+			p.tokens[i-1].used = true
+			if back == 0 {
+				back = 7
+			}
+		}
+		return p.today.AddDate(0, 0, -back), true, ""
+	}
+
+	if day, err := time.Parse(dateLayout, t.raw); err == nil {
+		return day, true, ""
+	}
+
+	m := slashDatePattern.FindStringSubmatch(t.raw)
+	if m == nil {
+		return time.Time{}, false, ""
+	}
+	a, _ := strconv.Atoi(m[1])
+	b, _ := strconv.Atoi(m[2])
+	var dayOfMonth, month int
+	switch {
+	case a > 12 && b <= 12:
+		dayOfMonth, month = a, b
+	case b > 12 && a <= 12:
+		dayOfMonth, month = b, a
+	case a == b:
+		dayOfMonth, month = a, b
+	case a <= 12 && b <= 12:
+		return time.Time{}, true, fmt.Sprintf("Could be %d/%d as day/month or as month/day; use YYYY-MM-DD", a, b)
+	default:
+		return time.Time{}, true, "Not a valid date"
+	}
+
+	year := p.today.Year()
+	if m[3] != "" {
+		year, _ = strconv.Atoi(m[3])
+		if year < 100 {
+			year += 2000
+		}
+	}
+	day = time.Date(year, time.Month(month), dayOfMonth, 0, 0, 0, 0, time.UTC)
+	if day.Day() != dayOfMonth {
+		return time.Time{}, true, "Not a valid date"
+	}
+	// Without a year the most recent such day is meant
+	if m[3] == "" && day.After(p.today) {
+		day = day.AddDate(-1, 0, 0)
+	}
+	return day, true, ""
+}
+
+// --- AMOUNT ---
+
+func (p *quickParser) findAmount() {
+	p.draft.Currency = p.baseCurrency
+	var found []int
+	for i, t := range p.tokens {
+		if !t.used && !t.sep && amountPattern.MatchString(t.raw) {
+			found = append(found, i)
+		}
+	}
+	if len(found) == 0 {
+		p.ambiguous("amount", "", "No amount found")
+		return
+	}
+	if len(found) > 1 {
+		var texts []string
+		for _, i := range found {
+			texts = append(texts, p.tokens[i].raw)
+		}
+		p.ambiguous("amount", strings.Join(texts, ", "), "More than one amount was given")
+		return
+	}
+
+	i := found[0]
+	t := &p.tokens[i]
+	t.used = true
+	m := amountPattern.FindStringSubmatch(t.raw)
+
+	amount, problem := parseQuickNumber(m[2])
+	if problem != "" {
+		p.ambiguous("amount", t.raw, problem)
+	} else {
+		p.draft.Amount = amount
+	}
+
+	// The currency is attached to the number or the token right before or after it
+	var currencies []string
+	for _, symbol := range []string{m[1], m[3]} {
+		if symbol != "" {
+			currencies = append(currencies, p.currencyFor(symbol))
+		}
+	}
+	for _, j := range []int{i - 1, i + 1} {
+		if j < 0 || j >= len(p.tokens) || p.tokens[j].used || p.tokens[j].sep {
+			continue
+		}
+		if code := p.tokens[j].raw; currencySymbols[code] != nil || isCurrencyCode(code) {
+			p.tokens[j].used = true
+			currencies = append(currencies, p.currencyFor(code))
+		}
+	}
+
+	if len(currencies) > 0 {
+		p.draft.Currency = currencies[0]
+	}
+	for _, c := range currencies {
+		if c != p.draft.Currency {
+			p.ambiguous("currency", strings.Join(currencies, ", "), "More than one currency was given")
+			break
+		}
+	}
+}
+
+// isCurrencyCode accepts a separate code only when written in capitals ("20 EUR") and a real ISO code,
+// so words like "for" or "BBQ" stay in the title
+func isCurrencyCode(s string) bool {
+	return len(s) == 3 && s == strings.ToUpper(s) && isoCurrencies[s]
+}
+
+// currencyFor resolves a symbol or code. A symbol used by several currencies ("$", "¥") only counts
+// as the group currency; otherwise it is reported, as is an attached code that isn't a currency ("12pcs").
+func (p *quickParser) currencyFor(symbol string) string {
+	if options, ok := currencySymbols[symbol]; ok {
+		for _, c := range options {
+			if c == p.baseCurrency {
+				return c
+			}
+		}
+		if len(options) > 1 {
+			p.ambiguous("currency", symbol, fmt.Sprintf("%s could be %s; write the currency code", symbol, strings.Join(options, ", ")))
+		}
+		return options[0]
+	}
+	code := strings.ToUpper(symbol)
+	if !isoCurrencies[code] {
+		p.ambiguous("currency", symbol, fmt.Sprintf("%s is not a known currency", symbol))
+	}
+	return code
+}
+
+// parseQuickNumber reads "84.50", "84,50", "1,234.50" or "1.234,50". A single separator followed by
+// exactly three digits could be either, so it is reported.
+func parseQuickNumber(s string) (float64, string) {
+	dot, comma := strings.LastIndex(s, "."), strings.LastIndex(s, ",")
+	switch {
+	case dot >= 0 && comma >= 0:
+		decimal, thousands := ".", ","
+		if comma > dot {
+			decimal, thousands = ",", "."
+		}
+		s = strings.ReplaceAll(s, thousands, "")
+		s = strings.Replace(s, decimal, ".", 1)
+	case dot >= 0 || comma >= 0:
+		sep := "."
+		if comma >= 0 {
+			sep = ","
+		}
+		parts := strings.Split(s, sep)
+		if len(parts) == 2 && len(parts[1]) == 3 {
+			return 0, fmt.Sprintf("%q could be thousands or decimals; write it as %s or %s",
+				s, parts[0]+parts[1], parts[0]+"."+parts[1])
+		}
+		if len(parts) == 2 {
+			s = parts[0] + "." + parts[1]
+		} else {
+			for _, part := range parts[1:] {
+				if len(part) != 3 {
+					return 0, fmt.Sprintf("%q is not a number", s)
+				}
+			}
+			s = strings.Join(parts, "")
+		}
+	}
+	amount, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, fmt.Sprintf("%q is not a number", s)
+	}
+	return amount, ""
+}
+
+// --- PEOPLE AND TITLE ---
+
+func (p *quickParser) findPeopleAndTitle() {
+	var phrases []quickPhrase
+	current := quickPhrase{mode: phraseTitle}
+	flush := func(mode, keyword string) {
+		if len(current.tokens) > 0 || current.mode != phraseTitle {
+			phrases = append(phrases, current)
+		}
+		current = quickPhrase{mode: mode, keyword: keyword}
+	}
+
+	for i := 0; i < len(p.tokens); i++ {
+		t := p.tokens[i]
+		next := ""
+		if i+1 < len(p.tokens) {
+			next = p.tokens[i+1].word
+		}
+
+		switch {
+		case t.used:
+			// An amount or date ends a list of names
+			flush(phraseTitle, "")
+		case t.word == "paid" && next == "by":
+			flush(phrasePayers, t.raw+" "+p.tokens[i+1].raw)
+			i++
+		case t.word == "paid":
+			// "Sam paid ...": the words so far are the payers if they all are members
+			if current.mode == phraseTitle && len(current.tokens) > 0 {
+				if _, problems, resolved := p.resolvePeople(current.tokens); resolved && len(problems) == 0 {
+					current.mode = phrasePayers
+				}
+			}
+			flush(phraseTitle, "")
+		case t.word == "by" && current.mode == phraseTitle:
+			flush(phrasePayers, t.raw)
+		case t.word == "with":
+			flush(phraseWith, t.raw)
+		case t.word == "for" || t.word == "between" || t.word == "among" || t.word == "amongst":
+			flush(phraseFor, t.raw)
+		case t.word == "split" || t.word == "shared" || t.word == "equally" || t.word == "evenly":
+			// How it is split is always equal; the words only lead into "with"/"between"
+		default:
+			current.tokens = append(current.tokens, t)
+		}
+	}
+	flush(phraseTitle, "")
+
+	var title []string
+	var payers, sharing []int
+	listed, withPayers := false, false
+	for _, phrase := range phrases {
+		if phrase.mode == phraseTitle {
+			title = append(title, phraseWords(phrase.tokens)...)
+			continue
+		}
+
+		ids, problems, resolved := p.resolvePeople(phrase.tokens)
+		// "Coffee with milk", "Dinner for two": no names (nothing capitalized, nobody found), so it's part of the title
+		if (phrase.mode == phraseWith || phrase.mode == phraseFor) && len(ids) == 0 && !resolved && allUnknown(problems) && !capitalized(phrase.tokens) {
+			if len(title) > 0 {
+				title = append(title, phrase.keyword)
+			}
+			title = append(title, phraseWords(phrase.tokens)...)
+			continue
+		}
+
+		field := "splits"
+		if phrase.mode == phrasePayers {
+			field = "payers"
+		}
+		if len(phrase.tokens) == 0 {
+			p.ambiguous(field, phrase.keyword, fmt.Sprintf("No names after %q", phrase.keyword))
+		}
+		for _, problem := range problems {
+			problem.Field = field
+			p.ambiguities = append(p.ambiguities, problem)
+		}
+
+		switch phrase.mode {
+		case phrasePayers:
+			payers = appendUnique(payers, ids...)
+		case phraseWith, phraseFor:
+			listed = true
+			withPayers = withPayers || phrase.mode == phraseWith
+			sharing = appendUnique(sharing, ids...)
+		}
+	}
+
+	p.draft.Title = strings.Join(title, " ")
+	if p.draft.Title == "" {
+		p.ambiguous("title", "", "No title found")
+	}
+
+	if len(payers) == 0 && !hasPhrase(phrases, phrasePayers) {
+		payers = []int{p.me}
+	}
+	if withPayers {
+		sharing = appendUnique(append([]int(nil), payers...), sharing...)
+	}
+	if !listed {
+		for _, m := range p.members {
+			sharing = append(sharing, m.ID)
+		}
+	}
+
+	p.draft.Payers = make([]PayerSplit, len(payers))
+	for i, id := range payers {
+		p.draft.Payers[i] = PayerSplit{UserID: id}
+	}
+	p.draft.Payers = templatePayers(p.draft.Payers, p.draft.Amount)
+
+	p.draft.SplitMode = splitEqual
+	weights := make([]float64, len(sharing))
+	for i := range weights {
+		weights[i] = 1
+	}
+	for i, cents := range distributeCents(toCents(p.draft.Amount), weights) {
+		p.draft.Splits = append(p.draft.Splits, Split{UserID: sharing[i], Amount: fromCents(cents)})
+	}
+}
+
+// resolvePeople resolves a list of names; resolved is false when any of them is unknown
+func (p *quickParser) resolvePeople(tokens []quickToken) ([]int, []ParseAmbiguity, bool) {
+	var ids []int
+	var problems []ParseAmbiguity
+	resolved := true
+
+	var chunk []string
+	resolveChunk := func() {
+		if len(chunk) == 0 {
+			return
+		}
+		found, problem := p.resolveName(chunk)
+		ids = appendUnique(ids, found...)
+		if problem != nil {
+			problems = append(problems, *problem)
+			resolved = resolved && len(problem.Candidates) > 0
+		}
+		chunk = nil
+	}
+	for _, t := range tokens {
+		if t.sep {
+			resolveChunk()
+			continue
+		}
+		chunk = append(chunk, t.raw)
+	}
+	resolveChunk()
+	return ids, problems, resolved
+}
+
+// resolveName finds the member(s) meant by one list entry: a full name, a first name or an email.
+// "Alex Jo" without commas is read as two names when it isn't one member's name.
+func (p *quickParser) resolveName(words []string) ([]int, *ParseAmbiguity) {
+	name := strings.Join(words, " ")
+	switch strings.ToLower(name) {
+	case "me", "i", "myself":
+		return []int{p.me}, nil
+	case "everyone", "everybody", "all", "us", "all of us", "the group":
+		var ids []int
+		for _, m := range p.members {
+			ids = append(ids, m.ID)
+		}
+		return ids, nil
+	}
+
+	matchers := []func(m mentionable) bool{
+		func(m mentionable) bool { return strings.EqualFold(m.Name, name) },
+		func(m mentionable) bool {
+			first, _, _ := strings.Cut(m.Name, " ")
+			return strings.EqualFold(first, name)
+		},
+		func(m mentionable) bool {
+			local, _, _ := strings.Cut(m.Email, "@")
+			return m.Email != "" && (strings.EqualFold(m.Email, name) || strings.EqualFold(local, name))
+		},
+	}
+	for _, matches := range matchers {
+		var candidates []MemberCandidate
+		for _, m := range p.members {
+			if matches(m) {
+				candidates = append(candidates, MemberCandidate{UserID: m.ID, Name: m.Name})
+			}
+		}
+		if len(candidates) == 1 {
+			return []int{candidates[0].UserID}, nil
+		}
+		if len(candidates) > 1 {
+			return nil, &ParseAmbiguity{Text: name, Message: fmt.Sprintf("%q matches more than one member", name), Candidates: candidates}
+		}
+	}
+
+	if len(words) > 1 {
+		var ids []int
+		for _, word := range words {
+			found, problem := p.resolveName([]string{word})
+			if problem != nil {
+				ids = nil
+				break
+			}
+			ids = append(ids, found...)
+		}
+		if ids != nil {
+			return ids, nil
+		}
+	}
+	return nil, &ParseAmbiguity{Text: name, Message: fmt.Sprintf("No member of the group is called %q", name)}
+}
+
+func phraseWords(tokens []quickToken) []string {
+	var words []string
+	for _, t := range tokens {
+		if t.raw != "" {
+			words = append(words, t.raw)
+		}
+	}
+	return words
+}
+
+func hasPhrase(phrases []quickPhrase, mode string) bool {
+	for _, phrase := range phrases {
+		if phrase.mode == mode {
+			return true
+		}
+	}
+	return false
+}
+
+func capitalized(tokens []quickToken) bool {
+	for _, t := range tokens {
+		if r, _ := utf8.DecodeRuneInString(t.raw); unicode.IsUpper(r) {
+			return true
+		}
+	}
+	return false
+}
+
+func allUnknown(problems []ParseAmbiguity) bool {
+	for _, problem := range problems {
+		if len(problem.Candidates) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func appendUnique(ids []int, more ...int) []int {
+	for _, id := range more {
+		seen := false
+		for _, existing := range ids {
+			seen = seen || existing == id
+		}
+		if !seen {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// ParseExpense turns a line of text into a draft expense and lists what it couldn't decide.
+// With "create": true an unambiguous draft is saved like POST /groups/{id}/expenses.
+func ParseExpense(w http.ResponseWriter, r *http.Request) {
+	groupID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Group not found", http.StatusNotFound)
+		return
+	}
+	userID := r.Context().Value(middleware.UserIDKey).(int)
+
+	if !isGroupMember(groupID, userID) {
+		http.Error(w, "Not a member of this group", http.StatusForbidden)
+		return
+	}
+
+	var req QuickEntryRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	if strings.TrimSpace(req.Text) == "" {
+		http.Error(w, "text is required", http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now()
+	if req.Timezone != "" {
+		loc, err := time.LoadLocation(req.Timezone)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("unknown timezone %q", req.Timezone), http.StatusBadRequest)
+			return
+		}
+		now = now.In(loc)
+	}
+
+	// 1. What the names and symbols can refer to
+	baseCurrency, err := groupCurrency(groupID)
+	if err != nil {
+		http.Error(w, "Group not found", http.StatusNotFound)
+		return
+	}
+	members, err := mentionableMembers(db.DB, groupID)
+	if err != nil {
+		fmt.Println("Error loading members:", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	// 2. Parse
+	parser := quickParser{
+		members:      members,
+		me:           userID,
+		today:        time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC),
+		baseCurrency: baseCurrency,
+	}
+	resp := parser.parse(req.Text)
+	resp.Draft.Timezone = req.Timezone
+
+	// 3. Optionally save it
+	if req.Create {
+		if len(resp.Ambiguities) > 0 {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			json.NewEncoder(w).Encode(resp)
+			return
+		}
+		draft := resp.Draft
+		createExpense(w, r, groupID, userID, &draft)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
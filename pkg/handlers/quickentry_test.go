@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestQuickParser(t *testing.T) {
+	team := []mentionable{
+		{ID: 1, Name: "Sam Taylor", Email: "sam@example.com"},
+		{ID: 2, Name: "Alex Kim", Email: "akim@example.com"},
+		{ID: 3, Name: "Jo Park", Email: "jo@example.com"},
+	}
+	twoJos := append(slices.Clone(team), mentionable{ID: 4, Name: "Jo Evans", Email: "evans@example.com"})
+	today := time.Date(2024, 5, 15, 0, 0, 0, 0, time.UTC) // a Wednesday
+
+	tests := []struct {
+		text    string
+		members []mentionable
+		title   string
+		amount  float64
+		curr    string
+		date    string
+		payers  []int
+		sharing []int
+		unsure  []string // fields reported as ambiguous, in order
+	}{
+		// The example from the request: the payer shares with the people after "with"
+		{"Dinner 84.50 paid by Sam split with Alex and Jo", team, "Dinner", 84.50, "EUR", "2024-05-15", []int{1}, []int{1, 2, 3}, nil},
+		{"Taxi €12 yesterday", team, "Taxi", 12, "EUR", "2024-05-14", []int{2}, []int{1, 2, 3}, nil},
+		{"Sam paid 30 for Alex, Jo", team, "", 30, "EUR", "2024-05-15", []int{1}, []int{2, 3}, []string{"title"}},
+		{"Museum 20 GBP last Wednesday for me and Jo", team, "Museum", 20, "GBP", "2024-05-08", []int{2}, []int{2, 3}, nil},
+		{"Coffee with milk 3,50", team, "Coffee with milk", 3.50, "EUR", "2024-05-15", []int{2}, []int{1, 2, 3}, nil},
+		{"BBQ supplies 45 on 2024-05-01", team, "BBQ supplies", 45, "EUR", "2024-05-01", []int{2}, []int{1, 2, 3}, nil},
+		{"Hotel 1.234,50 for everyone", team, "Hotel", 1234.50, "EUR", "2024-05-15", []int{2}, []int{1, 2, 3}, nil},
+
+		// Dates
+		{"Lunch 10 25/4", team, "Lunch", 10, "EUR", "2024-04-25", []int{2}, []int{1, 2, 3}, nil},
+		{"Lunch 10 4/25", team, "Lunch", 10, "EUR", "2024-04-25", []int{2}, []int{1, 2, 3}, nil},
+		{"Lunch 10 20/6", team, "Lunch", 10, "EUR", "2023-06-20", []int{2}, []int{1, 2, 3}, nil},
+		{"Lunch 10 3/4", team, "Lunch", 10, "EUR", "", []int{2}, []int{1, 2, 3}, []string{"date"}},
+		{"Lunch 10 31/2", team, "Lunch", 10, "EUR", "", []int{2}, []int{1, 2, 3}, []string{"date"}},
+		{"Lunch 10 today yesterday", team, "Lunch", 10, "EUR", "", []int{2}, []int{1, 2, 3}, []string{"date"}},
+
+		// Numbers and currencies
+		{"Tickets 1,234", team, "Tickets", 0, "EUR", "2024-05-15", []int{2}, []int{1, 2, 3}, []string{"amount"}},
+		{"Tickets 1.234", team, "Tickets", 0, "EUR", "2024-05-15", []int{2}, []int{1, 2, 3}, []string{"amount"}},
+		{"Tickets 12 and 15", team, "Tickets 12 and 15", 0, "EUR", "2024-05-15", []int{2}, []int{1, 2, 3}, []string{"amount"}},
+		{"Tickets", team, "Tickets", 0, "EUR", "2024-05-15", []int{2}, []int{1, 2, 3}, []string{"amount"}},
+		{"Snacks $5", team, "Snacks", 5, "USD", "2024-05-15", []int{2}, []int{1, 2, 3}, []string{"currency"}},
+		{"Snacks £5 EUR", team, "Snacks", 5, "GBP", "2024-05-15", []int{2}, []int{1, 2, 3}, []string{"currency"}},
+		{"Socks 12pcs", team, "Socks", 12, "PCS", "2024-05-15", []int{2}, []int{1, 2, 3}, []string{"currency"}},
+
+		// Names
+		{"Pizza 18 paid by Jo", twoJos, "Pizza", 18, "EUR", "2024-05-15", nil, []int{1, 2, 3, 4}, []string{"payers"}},
+		{"Pizza 18 paid by Jo Park", twoJos, "Pizza", 18, "EUR", "2024-05-15", []int{3}, []int{1, 2, 3, 4}, nil},
+		{"Pizza 18 paid by jo@example.com for Alex Sam", twoJos, "Pizza", 18, "EUR", "2024-05-15", []int{3}, []int{2, 1}, nil},
+		{"Pizza 18 paid by Chris", team, "Pizza", 18, "EUR", "2024-05-15", nil, []int{1, 2, 3}, []string{"payers"}},
+		{"Pizza 18 for Alex and Chris", team, "Pizza", 18, "EUR", "2024-05-15", []int{2}, []int{2}, []string{"splits"}},
+		{"Pizza 18 paid by", team, "Pizza", 18, "EUR", "2024-05-15", nil, []int{1, 2, 3}, []string{"payers"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			p := &quickParser{members: tt.members, me: 2, today: today, baseCurrency: "EUR"}
+			got := p.parse(tt.text)
+			draft := got.Draft
+
+			if draft.Title != tt.title || draft.Amount != tt.amount || draft.Currency != tt.curr || draft.Date != tt.date {
+				t.Errorf("draft = %q %.2f %s %q, want %q %.2f %s %q",
+					draft.Title, draft.Amount, draft.Currency, draft.Date, tt.title, tt.amount, tt.curr, tt.date)
+			}
+
+			var payers, sharing []int
+			var paid, owed int64
+			for _, payer := range draft.Payers {
+				payers = append(payers, payer.UserID)
+				paid += toCents(payer.PaidAmount)
+			}
+			for _, split := range draft.Splits {
+				sharing = append(sharing, split.UserID)
+				owed += toCents(split.Amount)
+			}
+			if !slices.Equal(payers, tt.payers) || !slices.Equal(sharing, tt.sharing) {
+				t.Errorf("payers %v sharing %v, want %v sharing %v", payers, sharing, tt.payers, tt.sharing)
+			}
+			if want := toCents(draft.Amount); owed != want || (len(payers) > 0 && paid != want) {
+				t.Errorf("paid %d and owed %d cents of %d", paid, owed, want)
+			}
+
+			var fields []string
+			for _, a := range got.Ambiguities {
+				fields = append(fields, a.Field)
+			}
+			if !slices.Equal(fields, tt.unsure) {
+				t.Errorf("ambiguities = %+v, want fields %v", got.Ambiguities, tt.unsure)
+			}
+		})
+	}
+}
+
+func TestQuickParserNameCollisionCandidates(t *testing.T) {
+	p := &quickParser{
+		members: []mentionable{
+			{ID: 1, Name: "Sam Taylor"},
+			{ID: 2, Name: "Sam Rivera"},
+			{ID: 3, Name: "Alex Kim", Email: "sam@example.com"},
+		},
+		me:           3,
+		today:        time.Date(2024, 5, 15, 0, 0, 0, 0, time.UTC),
+		baseCurrency: "EUR",
+	}
+	// A first name shared by two members is reported with both, even though "sam" is also someone's email
+	got := p.parse("Pizza 18 paid by Sam")
+	if len(got.Ambiguities) != 1 {
+		t.Fatalf("ambiguities = %+v", got.Ambiguities)
+	}
+	a := got.Ambiguities[0]
+	want := []MemberCandidate{{UserID: 1, Name: "Sam Taylor"}, {UserID: 2, Name: "Sam Rivera"}}
+	if a.Field != "payers" || a.Text != "Sam" || !slices.Equal(a.Candidates, want) {
+		t.Fatalf("ambiguity = %+v, want Sam's two candidates", a)
+	}
+}
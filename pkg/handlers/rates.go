@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"money-splitter/pkg/db"
+	"money-splitter/pkg/middleware"
+	"money-splitter/pkg/rates"
+)
+
+// RateProvider resolves exchange rates for expenses that don't carry one (set in main)
+var RateProvider rates.ExchangeRateProvider
+
+type ExchangeRateRequest struct {
+	Date  string  `json:"date"`
+	Base  string  `json:"base"`
+	Quote string  `json:"quote"`
+	Rate  float64 `json:"rate"`
+}
+
+// SetExchangeRate stores a manual rate that overrides imported rates for that day.
+// Rates apply to every group, so only the admins listed in RATE_ADMIN_EMAILS may set them.
+func SetExchangeRate(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(middleware.UserIDKey).(int)
+	if !isRateAdmin(userID) {
+		http.Error(w, "Only admins can set exchange rates", http.StatusForbidden)
+		return
+	}
+
+	var req ExchangeRateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	base, okBase := normalizeCurrency(req.Base)
+	quote, okQuote := normalizeCurrency(req.Quote)
+	if !okBase || !okQuote || base == quote {
+		http.Error(w, "Base and quote must be two different currency codes", http.StatusBadRequest)
+		return
+	}
+	if req.Rate <= 0 {
+		http.Error(w, "Rate must be positive", http.StatusBadRequest)
+		return
+	}
+	day, err := time.Parse("2006-01-02", req.Date)
+	if err != nil {
+		http.Error(w, "Date must be YYYY-MM-DD", http.StatusBadRequest)
+		return
+	}
+
+	query := `
+		INSERT INTO exchange_rates (rate_date, base_currency, quote_currency, rate, source)
+		VALUES ($1, $2, $3, $4, 'manual')
+		ON CONFLICT (rate_date, base_currency, quote_currency, source) DO UPDATE SET rate = EXCLUDED.rate`
+	if _, err := db.DB.Exec(query, day.Format("2006-01-02"), base, quote, req.Rate); err != nil {
+		fmt.Println("Error saving rate:", err)
+		http.Error(w, "Failed to save rate", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]any{
+		"message": "Rate saved",
+		"date":    day.Format("2006-01-02"),
+		"base":    base,
+		"quote":   quote,
+		"rate":    req.Rate,
+	})
+}
+
+// GetExchangeRate resolves a rate the same way expenses do: GET /rates?base=EUR&quote=USD&date=2024-05-01
+func GetExchangeRate(w http.ResponseWriter, r *http.Request) {
+	base, okBase := normalizeCurrency(r.URL.Query().Get("base"))
+	quote, okQuote := normalizeCurrency(r.URL.Query().Get("quote"))
+	if !okBase || !okQuote {
+		http.Error(w, "base and quote currency codes are required", http.StatusBadRequest)
+		return
+	}
+
+	day := time.Now()
+	if d := r.URL.Query().Get("date"); d != "" {
+		parsed, err := time.Parse("2006-01-02", d)
+		if err != nil {
+			http.Error(w, "date must be YYYY-MM-DD", http.StatusBadRequest)
+			return
+		}
+		day = parsed
+	}
+
+	rate, err := lookupRate(base, quote, day)
+	if errors.Is(err, rates.ErrRateNotFound) {
+		http.Error(w, "No rate available for that date", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		fmt.Println("Error looking up rate:", err)
+		http.Error(w, "Failed to look up rate", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"date":  day.Format("2006-01-02"),
+		"base":  base,
+		"quote": quote,
+		"rate":  rate,
+	})
+}
+
+// isRateAdmin reports whether the user's email is in the comma-separated RATE_ADMIN_EMAILS
+func isRateAdmin(userID int) bool {
+	var email sql.NullString
+	if err := db.DB.QueryRow(`SELECT email FROM users WHERE id = $1`, userID).Scan(&email); err != nil || !email.Valid {
+		return false
+	}
+	for _, admin := range strings.Split(os.Getenv("RATE_ADMIN_EMAILS"), ",") {
+		if admin = strings.TrimSpace(admin); admin != "" && strings.EqualFold(admin, email.String) {
+			return true
+		}
+	}
+	return false
+}
+
+func lookupRate(base, quote string, on time.Time) (float64, error) {
+	if RateProvider == nil {
+		return 0, rates.ErrRateNotFound
+	}
+	return RateProvider.Rate(base, quote, on)
+}
@@ -0,0 +1,149 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const dateLayout = "2006-01-02"
+
+// maxOccurrenceScan bounds the search for the next occurrence of a schedule
+const maxOccurrenceScan = 100000
+
+// RecurrenceRule is a small subset of iCalendar RRULE:
+// daily/weekly/monthly every Interval periods, weekly on a Weekday, monthly on a MonthDay.
+type RecurrenceRule struct {
+	Frequency string `json:"frequency"`
+	Interval  int    `json:"interval"`
+	MonthDay  int    `json:"month_day,omitempty"`
+	Weekday   *int   `json:"weekday,omitempty"` // 0 = Sunday
+}
+
+var rruleWeekdays = map[string]int{"SU": 0, "MO": 1, "TU": 2, "WE": 3, "TH": 4, "FR": 5, "SA": 6}
+
+// parseRRule understands FREQ, INTERVAL, BYMONTHDAY and BYDAY, e.g. "FREQ=MONTHLY;BYMONTHDAY=1"
+func parseRRule(value string) (RecurrenceRule, error) {
+	var rule RecurrenceRule
+	value = strings.TrimPrefix(strings.TrimSpace(value), "RRULE:")
+
+	for _, part := range strings.Split(value, ";") {
+		if part == "" {
+			continue
+		}
+		key, val, ok := strings.Cut(part, "=")
+		if !ok {
+			return rule, fmt.Errorf("invalid rrule part %q", part)
+		}
+
+		switch strings.ToUpper(key) {
+		case "FREQ":
+			rule.Frequency = strings.ToLower(val)
+		case "INTERVAL":
+			n, err := strconv.Atoi(val)
+			if err != nil {
+				return rule, fmt.Errorf("invalid INTERVAL %q", val)
+			}
+			rule.Interval = n
+		case "BYMONTHDAY":
+			n, err := strconv.Atoi(val)
+			if err != nil {
+				return rule, fmt.Errorf("invalid BYMONTHDAY %q", val)
+			}
+			rule.MonthDay = n
+		case "BYDAY":
+			day, ok := rruleWeekdays[strings.ToUpper(val)]
+			if !ok {
+				return rule, fmt.Errorf("unsupported BYDAY %q", val)
+			}
+			rule.Weekday = &day
+		default:
+			return rule, fmt.Errorf("unsupported rrule part %q", key)
+		}
+	}
+	return rule, nil
+}
+
+// validate normalizes defaults and rejects schedules we can't run
+func (rule *RecurrenceRule) validate() error {
+	if rule.Interval == 0 {
+		rule.Interval = 1
+	}
+	if rule.Interval < 1 {
+		return errors.New("interval must be at least 1")
+	}
+
+	switch rule.Frequency {
+	case "daily":
+		rule.MonthDay, rule.Weekday = 0, nil
+	case "weekly":
+		rule.MonthDay = 0
+		if rule.Weekday != nil && (*rule.Weekday < 0 || *rule.Weekday > 6) {
+			return errors.New("weekday must be between 0 (Sunday) and 6 (Saturday)")
+		}
+	case "monthly":
+		rule.Weekday = nil
+		if rule.MonthDay < 0 || rule.MonthDay > 31 {
+			return errors.New("month_day must be between 1 and 31")
+		}
+	default:
+		return errors.New("frequency must be daily, weekly or monthly")
+	}
+	return nil
+}
+
+// occurrence returns the k-th candidate date of the schedule (k >= 0).
+// Monthly days past the end of a month fall on its last day (31 -> Feb 28/29).
+func (rule RecurrenceRule) occurrence(start time.Time, k int) time.Time {
+	switch rule.Frequency {
+	case "daily":
+		return start.AddDate(0, 0, k*rule.Interval)
+	case "weekly":
+		anchor := start
+		if rule.Weekday != nil {
+			shift := (*rule.Weekday - int(start.Weekday()) + 7) % 7
+			anchor = start.AddDate(0, 0, shift)
+		}
+		return anchor.AddDate(0, 0, 7*k*rule.Interval)
+	default:
+		day := rule.MonthDay
+		if day == 0 {
+			day = start.Day()
+		}
+		firstOfMonth := time.Date(start.Year(), start.Month()+time.Month(k*rule.Interval), 1, 0, 0, 0, 0, time.UTC)
+		lastDay := firstOfMonth.AddDate(0, 1, -1).Day()
+		if day > lastDay {
+			day = lastDay
+		}
+		return firstOfMonth.AddDate(0, 0, day-1)
+	}
+}
+
+// nextOccurrence finds the first occurrence on or after the given day.
+// ok is false when the schedule has ended.
+func (rule RecurrenceRule) nextOccurrence(start time.Time, end *time.Time, onOrAfter time.Time) (time.Time, bool) {
+	for k := 0; k < maxOccurrenceScan; k++ {
+		candidate := rule.occurrence(start, k)
+		if candidate.Before(start) || candidate.Before(onOrAfter) {
+			continue
+		}
+		if end != nil && candidate.After(*end) {
+			return time.Time{}, false
+		}
+		return candidate, true
+	}
+	return time.Time{}, false
+}
+
+// isOccurrence reports whether the schedule lands on the given day
+func (rule RecurrenceRule) isOccurrence(start time.Time, end *time.Time, day time.Time) bool {
+	next, ok := rule.nextOccurrence(start, end, day)
+	return ok && next.Equal(day)
+}
+
+// truncateDay drops the clock so dates compare cleanly
+func truncateDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
@@ -0,0 +1,611 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"money-splitter/pkg/db"
+	"money-splitter/pkg/middleware"
+
+	"github.com/lib/pq"
+)
+
+// RecurringExpenseRequest defines a schedule plus the expense materialized on every occurrence.
+// The schedule is given either as fields or as an RRULE string.
+type RecurringExpenseRequest struct {
+	Expense CreateExpenseRequest `json:"expense"`
+	RRule   string               `json:"rrule,omitempty"`
+	RecurrenceRule
+	StartDate string `json:"start_date"`
+	EndDate   string `json:"end_date,omitempty"`
+}
+
+type RecurringExpenseResponse struct {
+	ID        int                  `json:"id"`
+	GroupID   int                  `json:"group_id"`
+	Expense   CreateExpenseRequest `json:"expense"`
+	Rule      RecurrenceRule       `json:"rule"`
+	StartDate string               `json:"start_date"`
+	EndDate   *string              `json:"end_date"`
+	NextRun   *string              `json:"next_run"`
+	Paused    bool                 `json:"paused"`
+	Failures  int                  `json:"failure_count"` // failed runs in a row
+	LastError *string              `json:"last_error"`
+}
+
+type SkipOccurrenceRequest struct {
+	Date string `json:"date"`
+}
+
+// recurringExpense is a row of recurring_expenses
+type recurringExpense struct {
+	ID        int
+	GroupID   int
+	CreatedBy int
+	Template  CreateExpenseRequest
+	Rule      RecurrenceRule
+	Start     time.Time
+	End       *time.Time
+	NextRun   *time.Time
+	Paused    bool
+	Failures  int
+	LastError *string
+}
+
+const recurringColumns = `id, group_id, created_by, template, frequency, interval_count, month_day, weekday,
+	start_date, end_date, next_run, paused, failure_count, last_error`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanRecurring(row rowScanner) (*recurringExpense, error) {
+	var rec recurringExpense
+	var template []byte
+	var weekday sql.NullInt64
+	var end, next sql.NullTime
+	var lastError sql.NullString
+
+	err := row.Scan(&rec.ID, &rec.GroupID, &rec.CreatedBy, &template, &rec.Rule.Frequency, &rec.Rule.Interval,
+		&rec.Rule.MonthDay, &weekday, &rec.Start, &end, &next, &rec.Paused,
+		&rec.Failures, &lastError)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(template, &rec.Template); err != nil {
+		return nil, fmt.Errorf("decoding template: %w", err)
+	}
+
+	rec.Start = truncateDay(rec.Start)
+	if weekday.Valid {
+		day := int(weekday.Int64)
+		rec.Rule.Weekday = &day
+	}
+	if end.Valid {
+		t := truncateDay(end.Time)
+		rec.End = &t
+	}
+	if next.Valid {
+		t := truncateDay(next.Time)
+		rec.NextRun = &t
+	}
+	if lastError.Valid {
+		rec.LastError = &lastError.String
+	}
+	return &rec, nil
+}
+
+func (rec *recurringExpense) response() RecurringExpenseResponse {
+	resp := RecurringExpenseResponse{
+		ID:        rec.ID,
+		GroupID:   rec.GroupID,
+		Expense:   rec.Template,
+		Rule:      rec.Rule,
+		StartDate: rec.Start.Format(dateLayout),
+		Paused:    rec.Paused,
+		Failures:  rec.Failures,
+		LastError: rec.LastError,
+	}
+	if rec.End != nil {
+		end := rec.End.Format(dateLayout)
+		resp.EndDate = &end
+	}
+	if rec.NextRun != nil {
+		next := rec.NextRun.Format(dateLayout)
+		resp.NextRun = &next
+	}
+	return resp
+}
+
+// parse validates the schedule and the expense template
+func (req *RecurringExpenseRequest) parse(groupID any, defaultStart time.Time) (RecurrenceRule, time.Time, *time.Time, error) {
+	rule := req.RecurrenceRule
+	if req.RRule != "" {
+		parsed, err := parseRRule(req.RRule)
+		if err != nil {
+			return rule, time.Time{}, nil, err
+		}
+		rule = parsed
+	}
+	if err := rule.validate(); err != nil {
+		return rule, time.Time{}, nil, err
+	}
+
+	start := defaultStart
+	if req.StartDate != "" {
+		parsed, err := time.Parse(dateLayout, req.StartDate)
+		if err != nil {
+			return rule, time.Time{}, nil, errors.New("start_date must be YYYY-MM-DD")
+		}
+		start = parsed
+	}
+
+	var end *time.Time
+	if req.EndDate != "" {
+		parsed, err := time.Parse(dateLayout, req.EndDate)
+		if err != nil {
+			return rule, time.Time{}, nil, errors.New("end_date must be YYYY-MM-DD")
+		}
+		if parsed.Before(start) {
+			return rule, time.Time{}, nil, errors.New("end_date is before start_date")
+		}
+		end = &parsed
+	}
+
+	// Validate the template the same way CreateExpense would; the rate is resolved per occurrence
+	if req.Expense.Claiming {
+		return rule, time.Time{}, nil, errors.New("Recurring expenses cannot use claiming")
+	}
+	if req.Expense.Type == typeRefund {
+		return rule, time.Time{}, nil, errors.New("Recurring expenses cannot be refunds")
+	}
+	template := req.Expense
+	if err := prepareExpense(&template); err != nil {
+		return rule, time.Time{}, nil, err
+	}
+	if err := checkGroupParticipants(groupID, &template); err != nil {
+		return rule, time.Time{}, nil, err
+	}
+	// Each occurrence is dated on its own day; only the time and timezone carry over
+	req.Expense.Date = ""
+	if _, err := resolveExpenseDate(&req.Expense, start); err != nil {
+		return rule, time.Time{}, nil, err
+	}
+	req.Expense.Date = ""
+	// Without a category each occurrence is categorized when it is created
+	if strings.TrimSpace(req.Expense.Category) != "" {
+		category, err := resolveCategory(db.DB, groupID, req.Expense.Category)
+		if err != nil {
+			return rule, time.Time{}, nil, err
+		}
+		req.Expense.Category = category
+	}
+	if req.Expense.Currency != "" {
+		currency, ok := normalizeCurrency(req.Expense.Currency)
+		if !ok {
+			return rule, time.Time{}, nil, fmt.Errorf("invalid currency code %q", req.Expense.Currency)
+		}
+		req.Expense.Currency = currency
+	}
+	return rule, start, end, nil
+}
+
+// nullableDate stores a missing next run / end date as NULL
+func nullableDate(t *time.Time) any {
+	if t == nil {
+		return nil
+	}
+	return t.Format(dateLayout)
+}
+
+// --- HANDLERS ---
+
+func CreateRecurringExpense(w http.ResponseWriter, r *http.Request) {
+	groupID := r.PathValue("id")
+	userID := r.Context().Value(middleware.UserIDKey).(int)
+
+	if !isGroupMember(groupID, userID) {
+		http.Error(w, "Not a member of this group", http.StatusForbidden)
+		return
+	}
+
+	var req RecurringExpenseRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	today := truncateDay(time.Now())
+	rule, start, end, err := req.parse(groupID, today)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// A start date in the past is caught up by the scheduler
+	var nextRun *time.Time
+	if next, ok := rule.nextOccurrence(start, end, start); ok {
+		nextRun = &next
+	}
+
+	template, _ := json.Marshal(req.Expense)
+	query := `
+		INSERT INTO recurring_expenses (group_id, created_by, template, frequency, interval_count, month_day, weekday, start_date, end_date, next_run)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		RETURNING ` + recurringColumns
+	rec, err := scanRecurring(db.DB.QueryRow(query, groupID, userID, string(template), rule.Frequency, rule.Interval, rule.MonthDay,
+		rule.Weekday, start.Format(dateLayout), nullableDate(end), nullableDate(nextRun)))
+	if err != nil {
+		fmt.Println("Error creating recurring expense:", err)
+		http.Error(w, "Failed to create recurring expense", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(rec.response())
+}
+
+func GetRecurringExpenses(w http.ResponseWriter, r *http.Request) {
+	groupID := r.PathValue("id")
+	userID := r.Context().Value(middleware.UserIDKey).(int)
+
+	if !isGroupMember(groupID, userID) {
+		http.Error(w, "Not a member of this group", http.StatusForbidden)
+		return
+	}
+
+	rows, err := db.DB.Query(`SELECT `+recurringColumns+` FROM recurring_expenses WHERE group_id = $1 ORDER BY id`, groupID)
+	if err != nil {
+		http.Error(w, "Failed to fetch recurring expenses", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	list := []RecurringExpenseResponse{}
+	for rows.Next() {
+		rec, err := scanRecurring(rows)
+		if err != nil {
+			continue
+		}
+		list = append(list, rec.response())
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// UpdateRecurringExpense replaces the template and schedule for future occurrences.
+// Expenses that were already materialized are left untouched.
+func UpdateRecurringExpense(w http.ResponseWriter, r *http.Request) {
+	rec, ok := loadRecurringForMember(w, r)
+	if !ok {
+		return
+	}
+
+	var req RecurringExpenseRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	rule, start, end, err := req.parse(rec.GroupID, rec.Start)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var nextRun *time.Time
+	if next, ok := rule.nextOccurrence(start, end, truncateDay(time.Now())); ok {
+		nextRun = &next
+	}
+
+	template, _ := json.Marshal(req.Expense)
+	query := `
+		UPDATE recurring_expenses
+		SET template=$1, frequency=$2, interval_count=$3, month_day=$4, weekday=$5, start_date=$6, end_date=$7, next_run=$8,
+		    failure_count=0, last_error=NULL, retry_at=NULL
+		WHERE id=$9
+		RETURNING ` + recurringColumns
+	updated, err := scanRecurring(db.DB.QueryRow(query, string(template), rule.Frequency, rule.Interval, rule.MonthDay, rule.Weekday,
+		start.Format(dateLayout), nullableDate(end), nullableDate(nextRun), rec.ID))
+	if err != nil {
+		fmt.Println("Error updating recurring expense:", err)
+		http.Error(w, "Failed to update recurring expense", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(updated.response())
+}
+
+func PauseRecurringExpense(w http.ResponseWriter, r *http.Request) {
+	setRecurringPaused(w, r, true)
+}
+
+// ResumeRecurringExpense restarts a schedule from today; occurrences missed while paused are not created
+func ResumeRecurringExpense(w http.ResponseWriter, r *http.Request) {
+	setRecurringPaused(w, r, false)
+}
+
+func setRecurringPaused(w http.ResponseWriter, r *http.Request, paused bool) {
+	rec, ok := loadRecurringForMember(w, r)
+	if !ok {
+		return
+	}
+
+	nextRun := rec.NextRun
+	if !paused {
+		nextRun = nil
+		if next, ok := rec.Rule.nextOccurrence(rec.Start, rec.End, truncateDay(time.Now())); ok {
+			nextRun = &next
+		}
+	}
+
+	query := `UPDATE recurring_expenses SET paused=$1, next_run=$2 WHERE id=$3 RETURNING ` + recurringColumns
+	if !paused {
+		// Resuming also gives a schedule that was paused for failing a fresh start
+		query = `UPDATE recurring_expenses SET paused=$1, next_run=$2, failure_count=0, last_error=NULL, retry_at=NULL
+			WHERE id=$3 RETURNING ` + recurringColumns
+	}
+	updated, err := scanRecurring(db.DB.QueryRow(query, paused, nullableDate(nextRun), rec.ID))
+	if err != nil {
+		http.Error(w, "Failed to update recurring expense", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(updated.response())
+}
+
+// SkipRecurringOccurrence marks one upcoming occurrence so the scheduler won't create it
+func SkipRecurringOccurrence(w http.ResponseWriter, r *http.Request) {
+	rec, ok := loadRecurringForMember(w, r)
+	if !ok {
+		return
+	}
+
+	var req SkipOccurrenceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	day, err := time.Parse(dateLayout, req.Date)
+	if err != nil {
+		http.Error(w, "date must be YYYY-MM-DD", http.StatusBadRequest)
+		return
+	}
+	if !rec.Rule.isOccurrence(rec.Start, rec.End, day) {
+		http.Error(w, "The schedule has no occurrence on that date", http.StatusBadRequest)
+		return
+	}
+
+	result, err := db.DB.Exec(`
+		INSERT INTO recurring_occurrences (recurring_id, occurrence_date, skipped)
+		VALUES ($1, $2, TRUE)
+		ON CONFLICT (recurring_id, occurrence_date) DO NOTHING`, rec.ID, day.Format(dateLayout))
+	if err != nil {
+		http.Error(w, "Failed to skip occurrence", http.StatusInternalServerError)
+		return
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		http.Error(w, "That occurrence was already created or skipped", http.StatusConflict)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Occurrence skipped", "date": day.Format(dateLayout)})
+}
+
+func DeleteRecurringExpense(w http.ResponseWriter, r *http.Request) {
+	rec, ok := loadRecurringForMember(w, r)
+	if !ok {
+		return
+	}
+
+	if _, err := db.DB.Exec(`DELETE FROM recurring_expenses WHERE id = $1`, rec.ID); err != nil {
+		http.Error(w, "Failed to delete recurring expense", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Recurring expense deleted"})
+}
+
+// loadRecurringForMember fetches /recurring/{id} and checks the caller belongs to its group
+func loadRecurringForMember(w http.ResponseWriter, r *http.Request) (*recurringExpense, bool) {
+	userID := r.Context().Value(middleware.UserIDKey).(int)
+
+	rec, err := scanRecurring(db.DB.QueryRow(`SELECT `+recurringColumns+` FROM recurring_expenses WHERE id = $1`, r.PathValue("id")))
+	if err != nil {
+		http.Error(w, "Recurring expense not found", http.StatusNotFound)
+		return nil, false
+	}
+	if !isGroupMember(rec.GroupID, userID) {
+		http.Error(w, "Not a member of this group", http.StatusForbidden)
+		return nil, false
+	}
+	return rec, true
+}
+
+// --- SCHEDULER ---
+
+// StartRecurringScheduler materializes due occurrences every tick until ctx is cancelled.
+// Several instances can run it at once: each schedule row is locked while it is processed
+// (SKIP LOCKED) and recurring_occurrences has one row per date, so nothing is created twice.
+// A schedule that fails is retried after a delay that doubles each time, and paused after
+// maxRecurringFailures failures in a row.
+func StartRecurringScheduler(ctx context.Context, every time.Duration) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+
+	for {
+		runDueRecurring(time.Now())
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func runDueRecurring(now time.Time) {
+	failed := []int64{}
+
+	for {
+		id, err := materializeNextDue(now, failed)
+		if err == nil {
+			continue
+		}
+		if id == 0 {
+			// Nothing left to do (or we couldn't even pick a schedule)
+			if !errors.Is(err, sql.ErrNoRows) {
+				fmt.Println("Recurring scheduler error:", err)
+			}
+			return
+		}
+		// Back it off and carry on with the others
+		fmt.Printf("Recurring expense %d failed: %v\n", id, err)
+		failed = append(failed, int64(id))
+		if err := recordRecurringFailure(id, err, now); err != nil {
+			fmt.Println("Error recording recurring failure:", err)
+		}
+	}
+}
+
+const (
+	recurringRetryDelay    = 5 * time.Minute
+	maxRecurringRetryDelay = 24 * time.Hour
+	maxRecurringFailures   = 10
+)
+
+// recurringBackoff is how long to wait after the given number of failures in a row:
+// 5 minutes, then twice as long each time, up to a day
+func recurringBackoff(failures int) time.Duration {
+	delay := recurringRetryDelay
+	for i := 1; i < failures && delay < maxRecurringRetryDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, maxRecurringRetryDelay)
+}
+
+// recordRecurringFailure stores why a schedule failed and when to try it again, pausing it
+// once it has failed too often; resuming or editing it starts over
+func recordRecurringFailure(id int, cause error, now time.Time) error {
+	var failures int
+	err := db.DB.QueryRow(`
+		UPDATE recurring_expenses SET failure_count = failure_count + 1, last_error = $2
+		WHERE id = $1
+		RETURNING failure_count`, id, cause.Error()).Scan(&failures)
+	if err != nil {
+		return err
+	}
+
+	pause := failures >= maxRecurringFailures
+	if pause {
+		fmt.Printf("Recurring expense %d paused after %d failures\n", id, failures)
+	}
+	_, err = db.DB.Exec(`UPDATE recurring_expenses SET retry_at = $2, paused = paused OR $3 WHERE id = $1`,
+		id, now.Add(recurringBackoff(failures)), pause)
+	return err
+}
+
+// materializeNextDue locks one due schedule and creates all of its occurrences up to today
+func materializeNextDue(now time.Time, exclude []int64) (int, error) {
+	today := truncateDay(now)
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	rec, err := scanRecurring(tx.QueryRow(`
+		SELECT `+recurringColumns+`
+		FROM recurring_expenses
+		WHERE paused = FALSE AND next_run IS NOT NULL AND next_run <= $1 AND NOT (id = ANY($2))
+		  AND (retry_at IS NULL OR retry_at <= $3)
+		ORDER BY next_run, id
+		LIMIT 1
+		FOR UPDATE SKIP LOCKED`, today.Format(dateLayout), pq.Array(exclude), now))
+	if err != nil {
+		return 0, err
+	}
+
+	baseCurrency, err := groupCurrency(rec.GroupID)
+	if err != nil {
+		return rec.ID, err
+	}
+	// People can leave the group after the schedule was set up; such a run fails and backs off
+	members, err := groupMemberSet(tx, rec.GroupID)
+	if err != nil {
+		return rec.ID, err
+	}
+
+	next := rec.NextRun
+	for next != nil && !next.After(today) {
+		day := *next
+
+		// The occurrence row is the dedupe key; skipped or already-created dates insert nothing
+		result, err := tx.Exec(`
+			INSERT INTO recurring_occurrences (recurring_id, occurrence_date)
+			VALUES ($1, $2)
+			ON CONFLICT (recurring_id, occurrence_date) DO NOTHING`, rec.ID, day.Format(dateLayout))
+		if err != nil {
+			return rec.ID, err
+		}
+
+		if affected, _ := result.RowsAffected(); affected == 1 {
+			req := rec.Template
+			req.Date = day.Format(dateLayout)
+			if err := prepareExpense(&req); err != nil {
+				return rec.ID, err
+			}
+			if err := checkParticipants(members, &req); err != nil {
+				return rec.ID, err
+			}
+			if err := autoCategory(tx, rec.GroupID, &req); err != nil {
+				return rec.ID, err
+			}
+			// Renames and merges rewrite templates, so an unknown category only means it was removed
+			if req.Category, err = resolveCategory(tx, rec.GroupID, req.Category); errors.Is(err, errUnknownCategory) {
+				req.Category = defaultCategory
+			} else if err != nil {
+				return rec.ID, err
+			}
+			if err := applyCurrency(&req, baseCurrency, day); err != nil {
+				return rec.ID, err
+			}
+
+			expenseID, err := insertExpense(tx, rec.GroupID, &req, time.Now(), rec.CreatedBy)
+			if err != nil {
+				return rec.ID, err
+			}
+			_, err = tx.Exec(`UPDATE recurring_occurrences SET expense_id = $1 WHERE recurring_id = $2 AND occurrence_date = $3`,
+				expenseID, rec.ID, day.Format(dateLayout))
+			if err != nil {
+				return rec.ID, err
+			}
+			fmt.Printf("Recurring expense %d created expense %d for %s\n", rec.ID, expenseID, day.Format(dateLayout))
+		}
+
+		next = nil
+		if following, ok := rec.Rule.nextOccurrence(rec.Start, rec.End, day.AddDate(0, 0, 1)); ok {
+			next = &following
+		}
+	}
+
+	_, err = tx.Exec(`
+		UPDATE recurring_expenses SET next_run = $1, failure_count = 0, last_error = NULL, retry_at = NULL
+		WHERE id = $2`, nullableDate(next), rec.ID)
+	if err != nil {
+		return rec.ID, err
+	}
+	return rec.ID, tx.Commit()
+}
@@ -0,0 +1,25 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRecurringBackoff(t *testing.T) {
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{1, 5 * time.Minute},
+		{2, 10 * time.Minute},
+		{3, 20 * time.Minute},
+		{9, 1280 * time.Minute},
+		{10, 24 * time.Hour},
+		{40, 24 * time.Hour},
+	}
+	for _, tt := range tests {
+		if got := recurringBackoff(tt.failures); got != tt.want {
+			t.Errorf("recurringBackoff(%d) = %v, want %v", tt.failures, got, tt.want)
+		}
+	}
+}
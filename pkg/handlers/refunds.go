@@ -0,0 +1,286 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"money-splitter/pkg/db"
+	"money-splitter/pkg/middleware"
+)
+
+const (
+	refundProportional = "proportional"
+	refundCustom       = "custom"
+)
+
+type RefundRequest struct {
+	Amount      float64 `json:"amount"`
+	Title       string  `json:"title"` // defaults to "Refund: <original title>"
+	Description string  `json:"description"`
+	Date        string  `json:"date,omitempty"`
+	Time        string  `json:"time,omitempty"`
+	Timezone    string  `json:"timezone,omitempty"`
+
+	// proportional (default) gives the money back the way the expense was paid and split;
+	// custom takes Payers (who receive the money) and Splits (whose shares shrink) as given
+	Mode      string       `json:"mode"`
+	Payers    []PayerSplit `json:"payers,omitempty"`
+	Splits    []Split      `json:"splits,omitempty"`
+	SplitMode string       `json:"split_mode,omitempty"`
+}
+
+// Refund problems found while saving, once the original expense is locked (the client's to fix)
+var (
+	errRefundExceeded     = errors.New("Refunds would exceed the original expense")
+	errRefundOriginalGone = errors.New("The refunded expense was deleted")
+)
+
+func isRefundRejected(err error) bool {
+	return errors.Is(err, errRefundExceeded) || errors.Is(err, errRefundOriginalGone)
+}
+
+// checkRefund validates a refund against the expense it refunds: same group and a final expense.
+// Without a currency the refund uses the original's, at the original rate. That all refunds together
+// don't exceed the original is checked when saving, by lockRefundLimit.
+func checkRefund(groupID any, req *CreateExpenseRequest) error {
+	if req.Type != typeRefund {
+		return nil
+	}
+	if req.Claiming {
+		return errors.New("Refunds cannot use claiming")
+	}
+
+	var origType, status, currency string
+	var rate float64
+	err := db.DB.QueryRow(`
+		SELECT type, status, currency, exchange_rate
+		FROM expenses
+		WHERE id = $1 AND group_id = $2 AND deleted_at IS NULL`, req.RefundOf, groupID).Scan(&origType, &status, &currency, &rate)
+	if err != nil {
+		return errors.New("The refunded expense does not exist in this group")
+	}
+	if origType != typeExpense {
+		return errors.New("Only expenses can be refunded")
+	}
+	if status != statusFinal {
+		return errors.New("The expense must be final (claimed and confirmed) before it can be refunded")
+	}
+
+	if req.Currency == "" {
+		req.Currency = currency
+	}
+	if normalized, ok := normalizeCurrency(req.Currency); ok && normalized == currency && req.ExchangeRate == 0 {
+		req.ExchangeRate = rate
+	}
+	return nil
+}
+
+// lockRefundLimit locks the refunded expense and checks all its refunds together stay within it.
+// Runs in the saving transaction, so two refunds created at once can't both take the last of it.
+// selfID is the refund being updated (0 when creating).
+func lockRefundLimit(tx *sql.Tx, req *CreateExpenseRequest, selfID any) error {
+	if req.Type != typeRefund {
+		return nil
+	}
+
+	var amount, rate float64
+	err := tx.QueryRow(`SELECT amount, exchange_rate FROM expenses WHERE id = $1 AND deleted_at IS NULL FOR UPDATE`, req.RefundOf).
+		Scan(&amount, &rate)
+	if errors.Is(err, sql.ErrNoRows) {
+		return errRefundOriginalGone
+	}
+	if err != nil {
+		return err
+	}
+
+	// Compare in the group currency so refunds in another currency count too
+	var refunded float64
+	err = tx.QueryRow(`
+		SELECT COALESCE(SUM(amount * exchange_rate), 0)
+		FROM expenses
+		WHERE refund_of = $1 AND type = 'refund' AND deleted_at IS NULL AND id <> $2`, req.RefundOf, selfID).Scan(&refunded)
+	if err != nil {
+		return err
+	}
+	if left := amount*rate - refunded; req.Amount*req.ExchangeRate > left+0.005 {
+		return fmt.Errorf("%w (%.2f left to refund)", errRefundExceeded, max(left, 0))
+	}
+	return nil
+}
+
+// checkRefundedTotal keeps an edited expense at least as large as what its refunds already return,
+// in the group currency. The caller holds the row lock, which refunds take too before saving.
+func checkRefundedTotal(tx *sql.Tx, req *CreateExpenseRequest, expenseID any) error {
+	if req.Type == typeRefund {
+		return nil
+	}
+	var refunded float64
+	err := tx.QueryRow(`
+		SELECT COALESCE(SUM(amount * exchange_rate), 0)
+		FROM expenses
+		WHERE refund_of = $1 AND type = 'refund' AND deleted_at IS NULL`, expenseID).Scan(&refunded)
+	if err != nil {
+		return err
+	}
+	if refunded > 0 && req.Amount*req.ExchangeRate < refunded-0.005 {
+		return fmt.Errorf("%w (%.2f is already refunded)", errRefundExceeded, refunded)
+	}
+	return nil
+}
+
+// refundFromOriginal builds the refund expense for POST /expenses/{id}/refunds
+func refundFromOriginal(original *CreateExpenseRequest, originalID int, req RefundRequest) (*CreateExpenseRequest, error) {
+	if req.Amount <= 0 {
+		return nil, errors.New("Refund amount must be positive")
+	}
+	refund := &CreateExpenseRequest{
+		Title:       strings.TrimSpace(req.Title),
+		Description: req.Description,
+		Amount:      req.Amount,
+		Category:    original.Category,
+		Currency:    original.Currency,
+		Date:        req.Date,
+		Time:        req.Time,
+		Timezone:    req.Timezone,
+		Type:        typeRefund,
+		RefundOf:    originalID,
+	}
+	if refund.Title == "" {
+		refund.Title = "Refund: " + original.Title
+	}
+
+	switch req.Mode {
+	case "", refundProportional:
+		if len(req.Payers) > 0 || len(req.Splits) > 0 {
+			return nil, errors.New("Payers and splits are only used with mode custom")
+		}
+		if len(original.Splits) == 0 {
+			return nil, errors.New("The expense has no splits to refund")
+		}
+		refund.Payers = scalePayers(original.Payers, req.Amount)
+		weights := make([]float64, len(original.Splits))
+		for i, s := range original.Splits {
+			weights[i] = s.Amount
+		}
+		for i, cents := range distributeCents(toCents(req.Amount), weights) {
+			refund.Splits = append(refund.Splits, Split{UserID: original.Splits[i].UserID, Amount: fromCents(cents)})
+		}
+		refund.SplitMode = splitExact
+	case refundCustom:
+		if len(req.Payers) == 0 || len(req.Splits) == 0 {
+			return nil, errors.New("A custom refund needs payers and splits")
+		}
+		refund.Payers = req.Payers
+		refund.Splits = req.Splits
+		refund.SplitMode = req.SplitMode
+	default:
+		return nil, errors.New("mode must be proportional or custom")
+	}
+	return refund, nil
+}
+
+// CreateRefund records money coming back for an expense. The refund reverses the payers and
+// splits of the original, proportionally by default or by custom amounts.
+func CreateRefund(w http.ResponseWriter, r *http.Request) {
+	expenseID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Expense not found", http.StatusNotFound)
+		return
+	}
+	userID := r.Context().Value(middleware.UserIDKey).(int)
+
+	groupID, err := expenseGroupID(expenseID)
+	if err != nil {
+		http.Error(w, "Expense not found", http.StatusNotFound)
+		return
+	}
+	if !isGroupMember(groupID, userID) {
+		http.Error(w, "Not a member of this group", http.StatusForbidden)
+		return
+	}
+
+	var req RefundRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	// 1. Build the refund from the original expense
+	original, err := loadExpenseSnapshot(db.DB, expenseID, false)
+	if err != nil {
+		http.Error(w, "Expense not found", http.StatusNotFound)
+		return
+	}
+	refund, err := refundFromOriginal(original, expenseID, req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// 2. Validate like any other expense
+	if err := prepareExpense(refund); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	day, err := resolveExpenseDate(refund, time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := checkRefund(groupID, refund); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := checkGroupParticipants(groupID, refund); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !applyCategory(w, groupID, refund) {
+		return
+	}
+	baseCurrency, err := groupCurrency(groupID)
+	if err != nil {
+		http.Error(w, "Group not found", http.StatusNotFound)
+		return
+	}
+	if err := applyCurrency(refund, baseCurrency, day); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// 3. Save
+	tx, err := db.DB.Begin()
+	if err != nil {
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	refundID, err := insertExpense(tx, groupID, refund, time.Now(), userID)
+	if isRefundRejected(err) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		fmt.Println("Error inserting refund:", err)
+		http.Error(w, "Failed to save refund", http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("ETag", expenseETag(1))
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]any{
+		"message":    "Refund added successfully",
+		"expense_id": refundID,
+	})
+}
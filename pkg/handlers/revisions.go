@@ -0,0 +1,340 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"time"
+
+	"money-splitter/pkg/db"
+	"money-splitter/pkg/middleware"
+)
+
+// Revision actions recorded in expense_revisions
+const (
+	revisionCreate       = "create"
+	revisionUpdate       = "update"
+	revisionDelete       = "delete"
+	revisionRevert       = "revert"
+	revisionFinalize     = "finalize"
+	revisionRestore      = "restore"
+	revisionRecategorize = "recategorize" // the category was renamed or merged into another
+)
+
+// FieldChange is one entry of a field-level diff; From is null on create, To is null on delete
+type FieldChange struct {
+	From any `json:"from"`
+	To   any `json:"to"`
+}
+
+type RevisionResponse struct {
+	Revision      int                    `json:"revision"`
+	Action        string                 `json:"action"`
+	ChangedBy     int                    `json:"changed_by"`
+	ChangedByName string                 `json:"changed_by_name"`
+	ChangedAt     string                 `json:"changed_at"`
+	Diff          map[string]FieldChange `json:"diff"`
+	Snapshot      CreateExpenseRequest   `json:"snapshot"`
+}
+
+type RevertRequest struct {
+	Revision int `json:"revision"`
+}
+
+// loadExpenseSnapshot reads the full state of an expense in the same shape clients send it,
+// so a snapshot can be fed straight back through the update path.
+func loadExpenseSnapshot(q queryer, expenseID any, lock bool) (*CreateExpenseRequest, error) {
+	query := `
+		SELECT title, COALESCE(description, ''), amount, COALESCE(category, ''), currency, exchange_rate,
+		       tax, tip, service_charge, status,
+		       TO_CHAR(expense_date, 'YYYY-MM-DD'), COALESCE(TO_CHAR(expense_time, 'HH24:MI'), ''), expense_timezone, split_mode,
+		       type, COALESCE(refund_of, 0)
+		FROM expenses
+		WHERE id = $1 AND deleted_at IS NULL`
+	if lock {
+		query += ` FOR UPDATE`
+	}
+
+	var snap CreateExpenseRequest
+	var status string
+	err := q.QueryRow(query, expenseID).Scan(&snap.Title, &snap.Description, &snap.Amount, &snap.Category,
+		&snap.Currency, &snap.ExchangeRate, &snap.Tax, &snap.Tip, &snap.ServiceCharge, &status,
+		&snap.Date, &snap.Time, &snap.Timezone, &snap.SplitMode, &snap.Type, &snap.RefundOf)
+	if err != nil {
+		return nil, err
+	}
+	snap.Claiming = status == statusClaiming
+
+	rows, err := q.Query(`SELECT user_id, paid_amount FROM expense_payers WHERE expense_id = $1 ORDER BY id`, expenseID)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var p PayerSplit
+		if err := rows.Scan(&p.UserID, &p.PaidAmount); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		snap.Payers = append(snap.Payers, p)
+	}
+	rows.Close()
+
+	rows, err = q.Query(`SELECT user_id, amount_owed, COALESCE(weight, 0) FROM expense_splits WHERE expense_id = $1 ORDER BY id`, expenseID)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var s Split
+		var weight float64
+		if err := rows.Scan(&s.UserID, &s.Amount, &weight); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		switch snap.SplitMode {
+		case splitPercent:
+			s.Percent = weight
+		case splitShares:
+			s.Shares = weight
+		}
+		snap.Splits = append(snap.Splits, s)
+	}
+	rows.Close()
+
+	snap.Items, err = loadItems(q, expenseID)
+	if err != nil {
+		return nil, err
+	}
+	// Item ids change every time items are rewritten; they'd only add noise to diffs
+	for i := range snap.Items {
+		snap.Items[i].ID = 0
+	}
+	return &snap, nil
+}
+
+// diffSnapshots compares two snapshots field by field (by their JSON names)
+func diffSnapshots(before, after *CreateExpenseRequest) map[string]FieldChange {
+	oldFields := snapshotFields(before)
+	newFields := snapshotFields(after)
+
+	diff := make(map[string]FieldChange)
+	for key, oldValue := range oldFields {
+		if newValue := newFields[key]; !reflect.DeepEqual(oldValue, newValue) {
+			diff[key] = FieldChange{From: oldValue, To: newValue}
+		}
+	}
+	for key, newValue := range newFields {
+		if _, seen := oldFields[key]; !seen && newValue != nil {
+			diff[key] = FieldChange{From: nil, To: newValue}
+		}
+	}
+	return diff
+}
+
+func snapshotFields(snap *CreateExpenseRequest) map[string]any {
+	fields := make(map[string]any)
+	if snap == nil {
+		return fields
+	}
+	raw, _ := json.Marshal(snap)
+	json.Unmarshal(raw, &fields)
+	return fields
+}
+
+// recordRevision appends a snapshot to the expense history. Must run while the expense row still exists.
+func recordRevision(tx *sql.Tx, expenseID any, action string, userID int, before, after *CreateExpenseRequest) error {
+	snapshot := after
+	if snapshot == nil {
+		snapshot = before
+	}
+	snapshotJSON, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+	diffJSON, err := json.Marshal(diffSnapshots(before, after))
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO expense_revisions (expense_id, group_id, revision, action, changed_by, snapshot, diff)
+		SELECT e.id, e.group_id,
+		       COALESCE((SELECT MAX(r.revision) FROM expense_revisions r WHERE r.expense_id = e.id), 0) + 1,
+		       $2, $3, $4, $5
+		FROM expenses e
+		WHERE e.id = $1`, expenseID, action, userID, string(snapshotJSON), string(diffJSON))
+	return err
+}
+
+// --- HANDLERS ---
+
+// GetExpenseHistory lists every revision of an expense, newest first
+func GetExpenseHistory(w http.ResponseWriter, r *http.Request) {
+	expenseID := r.PathValue("id")
+	userID := r.Context().Value(middleware.UserIDKey).(int)
+
+	// The history outlives the expense, so membership is checked against the recorded group
+	var groupID int
+	err := db.DB.QueryRow(`SELECT group_id FROM expense_revisions WHERE expense_id = $1 LIMIT 1`, expenseID).Scan(&groupID)
+	if err != nil {
+		http.Error(w, "No history for this expense", http.StatusNotFound)
+		return
+	}
+	if !isGroupMember(groupID, userID) {
+		http.Error(w, "Not a member of this group", http.StatusForbidden)
+		return
+	}
+
+	rows, err := db.DB.Query(`
+		SELECT r.revision, r.action, COALESCE(r.changed_by, 0), COALESCE(u.name, ''), r.changed_at, r.snapshot, r.diff
+		FROM expense_revisions r
+		LEFT JOIN users u ON r.changed_by = u.id
+		WHERE r.expense_id = $1
+		ORDER BY r.revision DESC`, expenseID)
+	if err != nil {
+		fmt.Println("Error fetching history:", err)
+		http.Error(w, "Failed to fetch history", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	history := []RevisionResponse{}
+	for rows.Next() {
+		var rev RevisionResponse
+		var changedAt time.Time
+		var snapshot, diff []byte
+		if err := rows.Scan(&rev.Revision, &rev.Action, &rev.ChangedBy, &rev.ChangedByName, &changedAt, &snapshot, &diff); err != nil {
+			continue
+		}
+		rev.ChangedAt = changedAt.Format(time.RFC3339)
+		json.Unmarshal(snapshot, &rev.Snapshot)
+		json.Unmarshal(diff, &rev.Diff)
+		history = append(history, rev)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(history)
+}
+
+// RevertExpense restores an expense to an earlier revision through the normal update path
+func RevertExpense(w http.ResponseWriter, r *http.Request) {
+	expenseID := r.PathValue("id")
+	userID := r.Context().Value(middleware.UserIDKey).(int)
+
+	if _, ok := requireExpenseMember(w, expenseID, userID); !ok {
+		return
+	}
+	expectedVersion, err := ifMatchVersion(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var req RevertRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Revision <= 0 {
+		http.Error(w, "A revision number is required", http.StatusBadRequest)
+		return
+	}
+
+	// 1. Load the snapshot to restore
+	var raw []byte
+	var savedAt time.Time
+	err = db.DB.QueryRow(`SELECT snapshot, changed_at FROM expense_revisions WHERE expense_id = $1 AND revision = $2`,
+		expenseID, req.Revision).Scan(&raw, &savedAt)
+	if err != nil {
+		http.Error(w, "Revision not found", http.StatusNotFound)
+		return
+	}
+	var target CreateExpenseRequest
+	if err := json.Unmarshal(raw, &target); err != nil {
+		http.Error(w, "Revision snapshot is unreadable", http.StatusInternalServerError)
+		return
+	}
+
+	// 2. Validate it like any other update (the stored exchange rate is kept)
+	groupID, baseCurrency, err := storedExpenseDefaults(expenseID, &target)
+	if err != nil {
+		http.Error(w, "Expense not found", http.StatusNotFound)
+		return
+	}
+	if err := prepareExpense(&target); err != nil {
+		http.Error(w, "Revision can no longer be applied: "+err.Error(), http.StatusConflict)
+		return
+	}
+	day, err := resolveExpenseDate(&target, time.Now())
+	if err != nil {
+		http.Error(w, "Revision can no longer be applied: "+err.Error(), http.StatusConflict)
+		return
+	}
+	if err := checkRefund(groupID, &target); err != nil {
+		http.Error(w, "Revision can no longer be applied: "+err.Error(), http.StatusConflict)
+		return
+	}
+	if err := checkGroupParticipants(groupID, &target); err != nil {
+		http.Error(w, "Revision can no longer be applied: "+err.Error(), http.StatusConflict)
+		return
+	}
+	// A category renamed or merged since then is restored under the name it has now
+	renamed, err := renamedCategory(db.DB, groupID, target.Category, savedAt)
+	if err != nil {
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+	if renamed != "" {
+		target.Category = renamed
+	}
+	if target.Category, err = resolveCategory(db.DB, groupID, target.Category); err != nil {
+		if errors.Is(err, errUnknownCategory) {
+			http.Error(w, "Revision can no longer be applied: "+err.Error(), http.StatusConflict)
+			return
+		}
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+	if err := applyCurrency(&target, baseCurrency, day); err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+
+	// 3. Apply
+	tx, err := db.DB.Begin()
+	if err != nil {
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	version, err := updateExpense(tx, expenseID, &target, userID, revisionRevert, expectedVersion)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "Expense not found", http.StatusNotFound)
+			return
+		}
+		if errors.Is(err, errVersionMismatch) {
+			tx.Rollback()
+			writePreconditionFailed(w, expenseID)
+			return
+		}
+		if isRefundRejected(err) {
+			http.Error(w, "Revision can no longer be applied: "+err.Error(), http.StatusConflict)
+			return
+		}
+		fmt.Println("Error reverting expense:", err)
+		http.Error(w, "Failed to revert expense", http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("ETag", expenseETag(version))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"message":       "Expense reverted",
+		"restored_from": req.Revision,
+	})
+}
@@ -0,0 +1,196 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/http"
+	"strings"
+	"time"
+
+	"money-splitter/pkg/db"
+	"money-splitter/pkg/middleware"
+)
+
+// Full-text documents; these expressions must match the GIN indexes in the migrations
+const (
+	expenseDocument = `(setweight(to_tsvector('english', e.title), 'A') ||
+		setweight(to_tsvector('english', COALESCE(e.description, '')), 'B') ||
+		setweight(to_tsvector('english', COALESCE(e.category, '')), 'C'))`
+	commentDocument = `to_tsvector('english', c.body)`
+	nameDocument    = `to_tsvector('simple', u.name)`
+
+	// ts_headline marks matches with control characters that are stripped from the text first;
+	// highlightSnippet escapes the rest and only then turns the markers into <mark> tags
+	headlineMarkers = `'StartSel=' || chr(2) || ', StopSel=' || chr(3)`
+	headlineOptions = headlineMarkers + ` || ', MaxWords=25, MinWords=8, MaxFragments=2'`
+)
+
+// headlineText keeps the markers out of the user's own text
+func headlineText(expr string) string {
+	return `translate(` + expr + `, chr(2) || chr(3), '')`
+}
+
+// highlightSnippet HTML-escapes a ts_headline result, so stored text can't inject markup, and marks the matches
+func highlightSnippet(headline string) string {
+	return strings.NewReplacer("\x02", "<mark>", "\x03", "</mark>").Replace(html.EscapeString(headline))
+}
+
+type SearchGroupRef struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+type ExpenseSearchResult struct {
+	ID       int            `json:"id"`
+	Group    SearchGroupRef `json:"group"`
+	Title    string         `json:"title"`
+	Category string         `json:"category"`
+	Amount   float64        `json:"amount"`
+	Currency string         `json:"currency"`
+	Date     string         `json:"date"`
+	Snippet  string         `json:"snippet"` // escaped HTML, matches wrapped in <mark>
+	Rank     float64        `json:"rank"`
+}
+
+type CommentSearchResult struct {
+	ID           int            `json:"id"`
+	ExpenseID    int            `json:"expense_id"`
+	ExpenseTitle string         `json:"expense_title"`
+	Group        SearchGroupRef `json:"group"`
+	AuthorName   string         `json:"author_name"`
+	CreatedAt    string         `json:"created_at"`
+	Snippet      string         `json:"snippet"`
+	Rank         float64        `json:"rank"`
+}
+
+type MemberSearchResult struct {
+	ID      int              `json:"id"`
+	Name    string           `json:"name"`
+	Snippet string           `json:"snippet"`
+	Groups  []SearchGroupRef `json:"groups"`
+	Rank    float64          `json:"rank"`
+}
+
+type SearchResponse struct {
+	Query    string                `json:"query"`
+	Expenses []ExpenseSearchResult `json:"expenses"`
+	Comments []CommentSearchResult `json:"comments"`
+	Members  []MemberSearchResult  `json:"members"`
+}
+
+// Search runs a full-text query over everything in the caller's groups:
+// expenses (title, description, category), comments and member names.
+// ?q= uses web search syntax ("quoted phrases", -exclusions, or); limit/offset apply per type.
+func Search(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(middleware.UserIDKey).(int)
+
+	text := strings.TrimSpace(r.URL.Query().Get("q"))
+	if text == "" {
+		http.Error(w, "q is required", http.StatusBadRequest)
+		return
+	}
+	limit, offset := pageParams(r, 10, 50)
+
+	resp := SearchResponse{
+		Query:    text,
+		Expenses: []ExpenseSearchResult{},
+		Comments: []CommentSearchResult{},
+		Members:  []MemberSearchResult{},
+	}
+
+	// 1. Expenses
+	rows, err := db.DB.Query(`
+		SELECT e.id, g.id, g.name, e.title, e.category, e.amount, e.currency, TO_CHAR(e.expense_date, 'YYYY-MM-DD'),
+		       ts_headline('english', `+headlineText(`e.title || ' - ' || COALESCE(e.description, '')`)+`, query, `+headlineOptions+`),
+		       ts_rank(`+expenseDocument+`, query) AS rank
+		FROM expenses e
+		JOIN groups g ON e.group_id = g.id
+		JOIN group_members gm ON gm.group_id = e.group_id AND gm.user_id = $2,
+		     websearch_to_tsquery('english', $1) query
+		WHERE e.deleted_at IS NULL AND `+expenseDocument+` @@ query
+		ORDER BY rank DESC, e.expense_date DESC
+		LIMIT $3 OFFSET $4`, text, userID, limit, offset)
+	if err != nil {
+		fmt.Println("Error searching expenses:", err)
+		http.Error(w, "Search failed", http.StatusInternalServerError)
+		return
+	}
+	for rows.Next() {
+		var res ExpenseSearchResult
+		if err := rows.Scan(&res.ID, &res.Group.ID, &res.Group.Name, &res.Title, &res.Category, &res.Amount, &res.Currency,
+			&res.Date, &res.Snippet, &res.Rank); err != nil {
+			continue
+		}
+		res.Snippet = highlightSnippet(res.Snippet)
+		resp.Expenses = append(resp.Expenses, res)
+	}
+	rows.Close()
+
+	// 2. Comments (on expenses that are still around)
+	rows, err = db.DB.Query(`
+		SELECT c.id, e.id, e.title, g.id, g.name, u.name, c.created_at,
+		       ts_headline('english', `+headlineText(`c.body`)+`, query, `+headlineOptions+`),
+		       ts_rank(`+commentDocument+`, query) AS rank
+		FROM expense_comments c
+		JOIN expenses e ON c.expense_id = e.id
+		JOIN groups g ON e.group_id = g.id
+		JOIN users u ON c.author_id = u.id
+		JOIN group_members gm ON gm.group_id = e.group_id AND gm.user_id = $2,
+		     websearch_to_tsquery('english', $1) query
+		WHERE e.deleted_at IS NULL AND `+commentDocument+` @@ query
+		ORDER BY rank DESC, c.created_at DESC
+		LIMIT $3 OFFSET $4`, text, userID, limit, offset)
+	if err != nil {
+		fmt.Println("Error searching comments:", err)
+		http.Error(w, "Search failed", http.StatusInternalServerError)
+		return
+	}
+	for rows.Next() {
+		var res CommentSearchResult
+		var createdAt time.Time
+		if err := rows.Scan(&res.ID, &res.ExpenseID, &res.ExpenseTitle, &res.Group.ID, &res.Group.Name, &res.AuthorName,
+			&createdAt, &res.Snippet, &res.Rank); err != nil {
+			continue
+		}
+		res.CreatedAt = createdAt.Format(time.RFC3339)
+		res.Snippet = highlightSnippet(res.Snippet)
+		resp.Comments = append(resp.Comments, res)
+	}
+	rows.Close()
+
+	// 3. Members of any of my groups (names aren't stemmed)
+	rows, err = db.DB.Query(`
+		SELECT u.id, u.name,
+		       ts_headline('simple', `+headlineText(`u.name`)+`, query, `+headlineMarkers+`),
+		       ts_rank(`+nameDocument+`, query) AS rank,
+		       COALESCE(JSON_AGG(JSON_BUILD_OBJECT('id', g.id, 'name', g.name) ORDER BY g.name), '[]')
+		FROM users u
+		JOIN group_members gm ON gm.user_id = u.id
+		JOIN groups g ON gm.group_id = g.id
+		JOIN group_members mine ON mine.group_id = gm.group_id AND mine.user_id = $2,
+		     websearch_to_tsquery('simple', $1) query
+		WHERE `+nameDocument+` @@ query
+		GROUP BY u.id, u.name, query
+		ORDER BY rank DESC, u.name
+		LIMIT $3 OFFSET $4`, text, userID, limit, offset)
+	if err != nil {
+		fmt.Println("Error searching members:", err)
+		http.Error(w, "Search failed", http.StatusInternalServerError)
+		return
+	}
+	for rows.Next() {
+		var res MemberSearchResult
+		var groups []byte
+		if err := rows.Scan(&res.ID, &res.Name, &res.Snippet, &res.Rank, &groups); err != nil {
+			continue
+		}
+		json.Unmarshal(groups, &res.Groups)
+		res.Snippet = highlightSnippet(res.Snippet)
+		resp.Members = append(resp.Members, res)
+	}
+	rows.Close()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
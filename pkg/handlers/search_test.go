@@ -0,0 +1,20 @@
+package handlers
+
+import "testing"
+
+func TestHighlightSnippet(t *testing.T) {
+	tests := []struct {
+		headline string
+		want     string
+	}{
+		{"Dinner at \x02Luigi\x03's", "Dinner at <mark>Luigi</mark>&#39;s"},
+		{"<img src=x onerror=alert(1)> \x02pizza\x03", "&lt;img src=x onerror=alert(1)&gt; <mark>pizza</mark>"},
+		{"\x02Tom\x03 & <b>Jerry</b>", "<mark>Tom</mark> &amp; &lt;b&gt;Jerry&lt;/b&gt;"},
+		{"no match", "no match"},
+	}
+	for _, tt := range tests {
+		if got := highlightSnippet(tt.headline); got != tt.want {
+			t.Errorf("highlightSnippet(%q) = %q, want %q", tt.headline, got, tt.want)
+		}
+	}
+}
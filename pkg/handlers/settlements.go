@@ -0,0 +1,315 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"money-splitter/pkg/db"
+	"money-splitter/pkg/middleware"
+)
+
+// SettlementRequest records a payment between two members, e.g. one of the suggested
+// transactions from GET /groups/{id}/balance. from_user_id defaults to the caller.
+type SettlementRequest struct {
+	FromUserID   int     `json:"from_user_id"`
+	ToUserID     int     `json:"to_user_id"`
+	Amount       float64 `json:"amount"`
+	Currency     string  `json:"currency"` // defaults to the group currency
+	ExchangeRate float64 `json:"exchange_rate"`
+	Date         string  `json:"date,omitempty"` // YYYY-MM-DD, defaults to today
+	Note         string  `json:"note"`
+}
+
+type SettlementResponse struct {
+	ID              int     `json:"id"`
+	GroupID         int     `json:"group_id"`
+	FromUserID      int     `json:"from_user_id"`
+	FromName        string  `json:"from_name"`
+	ToUserID        int     `json:"to_user_id"`
+	ToName          string  `json:"to_name"`
+	Amount          float64 `json:"amount"`
+	Currency        string  `json:"currency"`
+	ExchangeRate    float64 `json:"exchange_rate"`
+	ConvertedAmount float64 `json:"converted_amount"` // in the group currency
+	Date            string  `json:"date"`
+	Note            string  `json:"note"`
+	CreatedBy       int     `json:"created_by,omitempty"`
+	CreatedAt       string  `json:"created_at"`
+}
+
+const settlementColumns = `s.id, s.group_id, s.from_user, fu.name, s.to_user, tu.name, s.amount, s.currency, s.exchange_rate,
+	TO_CHAR(s.settled_on, 'YYYY-MM-DD'), s.note, COALESCE(s.created_by, 0), s.created_at`
+
+const settlementJoins = `
+	FROM settlements s
+	JOIN users fu ON fu.id = s.from_user
+	JOIN users tu ON tu.id = s.to_user`
+
+func scanSettlement(row rowScanner) (*SettlementResponse, error) {
+	var s SettlementResponse
+	var createdAt time.Time
+	err := row.Scan(&s.ID, &s.GroupID, &s.FromUserID, &s.FromName, &s.ToUserID, &s.ToName, &s.Amount, &s.Currency,
+		&s.ExchangeRate, &s.Date, &s.Note, &s.CreatedBy, &createdAt)
+	if err != nil {
+		return nil, err
+	}
+	s.ConvertedAmount = math.Round(s.Amount*s.ExchangeRate*100) / 100
+	s.CreatedAt = createdAt.Format(time.RFC3339)
+	return &s, nil
+}
+
+// validate checks both people belong to the group and resolves the date and currency
+func (req *SettlementRequest) validate(groupID any, baseCurrency string, now time.Time) error {
+	req.Note = strings.TrimSpace(req.Note)
+	if req.ToUserID == 0 {
+		return errors.New("to_user_id is required")
+	}
+	if req.FromUserID == req.ToUserID {
+		return errors.New("A member can't pay themselves")
+	}
+	if !isGroupMember(groupID, req.FromUserID) || !isGroupMember(groupID, req.ToUserID) {
+		return errors.New("Both people must be members of the group")
+	}
+	if req.Amount <= 0 {
+		return errors.New("Amount must be positive")
+	}
+	req.Amount = fromCents(toCents(req.Amount))
+
+	if req.Date == "" {
+		req.Date = now.Format(dateLayout)
+	}
+	day, err := time.Parse(dateLayout, req.Date)
+	if err != nil {
+		return errors.New("date must be YYYY-MM-DD")
+	}
+	req.Currency, req.ExchangeRate, err = resolveCurrency(req.Currency, req.ExchangeRate, baseCurrency, day)
+	return err
+}
+
+// --- HANDLERS ---
+
+// CreateSettlement records that one member paid another back
+func CreateSettlement(w http.ResponseWriter, r *http.Request) {
+	groupID := r.PathValue("id")
+	userID := r.Context().Value(middleware.UserIDKey).(int)
+
+	if !isGroupMember(groupID, userID) {
+		http.Error(w, "Not a member of this group", http.StatusForbidden)
+		return
+	}
+
+	var req SettlementRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	if req.FromUserID == 0 {
+		req.FromUserID = userID
+	}
+
+	baseCurrency, err := groupCurrency(groupID)
+	if err != nil {
+		http.Error(w, "Group not found", http.StatusNotFound)
+		return
+	}
+	if err := req.validate(groupID, baseCurrency, time.Now()); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	var settlementID int
+	err = tx.QueryRow(`
+		INSERT INTO settlements (group_id, from_user, to_user, amount, currency, exchange_rate, settled_on, note, created_by)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		RETURNING id`, groupID, req.FromUserID, req.ToUserID, req.Amount, req.Currency, req.ExchangeRate, req.Date, req.Note, userID).
+		Scan(&settlementID)
+	if err != nil {
+		fmt.Println("Error creating settlement:", err)
+		http.Error(w, "Failed to record settlement", http.StatusInternalServerError)
+		return
+	}
+	s, err := scanSettlement(tx.QueryRow(`SELECT `+settlementColumns+settlementJoins+` WHERE s.id = $1`, settlementID))
+	if err != nil {
+		fmt.Println("Error loading settlement:", err)
+		http.Error(w, "Failed to record settlement", http.StatusInternalServerError)
+		return
+	}
+
+	// Whoever didn't record it themselves hears about it
+	for _, uid := range []int{s.FromUserID, s.ToUserID} {
+		if uid == userID {
+			continue
+		}
+		message := fmt.Sprintf("%s paid %s %.2f %s", s.FromName, s.ToName, s.Amount, s.Currency)
+		if err := notify(tx, uid, "settlement_recorded", groupID, nil, userID, message); err != nil {
+			fmt.Println("Error creating notification:", err)
+			http.Error(w, "Failed to record settlement", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(s)
+}
+
+// GetSettlements lists the group's settlements, newest first
+func GetSettlements(w http.ResponseWriter, r *http.Request) {
+	groupID := r.PathValue("id")
+	userID := r.Context().Value(middleware.UserIDKey).(int)
+
+	if !isGroupMember(groupID, userID) {
+		http.Error(w, "Not a member of this group", http.StatusForbidden)
+		return
+	}
+	limit, offset := pageParams(r, 50, 200)
+
+	rows, err := db.DB.Query(`SELECT `+settlementColumns+settlementJoins+`
+		WHERE s.group_id = $1
+		ORDER BY s.settled_on DESC, s.id DESC
+		LIMIT $2 OFFSET $3`, groupID, limit, offset)
+	if err != nil {
+		fmt.Println("Error fetching settlements:", err)
+		http.Error(w, "Failed to fetch settlements", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	list := []SettlementResponse{}
+	for rows.Next() {
+		s, err := scanSettlement(rows)
+		if err != nil {
+			continue
+		}
+		list = append(list, *s)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// DeleteSettlement removes a payment recorded by mistake; the balances go back to before it
+func DeleteSettlement(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(middleware.UserIDKey).(int)
+
+	var groupID int
+	err := db.DB.QueryRow(`SELECT group_id FROM settlements WHERE id = $1`, r.PathValue("id")).Scan(&groupID)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "Settlement not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+	if !isGroupMember(groupID, userID) {
+		http.Error(w, "Not a member of this group", http.StatusForbidden)
+		return
+	}
+
+	if _, err := db.DB.Exec(`DELETE FROM settlements WHERE id = $1`, r.PathValue("id")); err != nil {
+		http.Error(w, "Failed to delete settlement", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Settlement deleted"})
+}
+
+// TimelineEntry is an expense or a settlement in the group's history; Kind tells them apart
+type TimelineEntry struct {
+	Kind            string  `json:"kind"` // expense or settlement
+	ID              int     `json:"id"`
+	Date            string  `json:"date"`
+	CreatedAt       string  `json:"created_at"`
+	Title           string  `json:"title"`
+	Amount          float64 `json:"amount"`
+	Currency        string  `json:"currency"`
+	ConvertedAmount float64 `json:"converted_amount"`
+
+	// Expenses
+	Type      string `json:"type,omitempty"`
+	Status    string `json:"status,omitempty"`
+	Category  string `json:"category,omitempty"`
+	PayerName string `json:"payer_name,omitempty"`
+
+	// Settlements
+	FromUserID int    `json:"from_user_id,omitempty"`
+	FromName   string `json:"from_name,omitempty"`
+	ToUserID   int    `json:"to_user_id,omitempty"`
+	ToName     string `json:"to_name,omitempty"`
+	Note       string `json:"note,omitempty"`
+}
+
+// GetGroupTimeline lists expenses and settlements together, newest first
+func GetGroupTimeline(w http.ResponseWriter, r *http.Request) {
+	groupID := r.PathValue("id")
+	userID := r.Context().Value(middleware.UserIDKey).(int)
+
+	if !isGroupMember(groupID, userID) {
+		http.Error(w, "Not a member of this group", http.StatusForbidden)
+		return
+	}
+	limit, offset := pageParams(r, 50, 200)
+
+	rows, err := db.DB.Query(`
+		SELECT 'expense', e.id, e.expense_date, e.created_at, e.title, e.amount, e.currency, e.exchange_rate,
+		       e.type, e.status, e.category,
+		       COALESCE((SELECT u.name FROM expense_payers ep JOIN users u ON ep.user_id = u.id WHERE ep.expense_id = e.id LIMIT 1), ''),
+		       0, '', 0, '', ''
+		FROM expenses e
+		WHERE e.group_id = $1 AND e.deleted_at IS NULL
+		UNION ALL
+		SELECT 'settlement', s.id, s.settled_on, s.created_at, '', s.amount, s.currency, s.exchange_rate,
+		       '', '', '', '',
+		       s.from_user, fu.name, s.to_user, tu.name, s.note`+settlementJoins+`
+		WHERE s.group_id = $1
+		ORDER BY 3 DESC, 4 DESC, 2 DESC
+		LIMIT $2 OFFSET $3`, groupID, limit, offset)
+	if err != nil {
+		fmt.Println("Error fetching timeline:", err)
+		http.Error(w, "Failed to fetch timeline", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	timeline := []TimelineEntry{}
+	for rows.Next() {
+		var t TimelineEntry
+		var date, createdAt time.Time
+		var rate float64
+		err := rows.Scan(&t.Kind, &t.ID, &date, &createdAt, &t.Title, &t.Amount, &t.Currency, &rate,
+			&t.Type, &t.Status, &t.Category, &t.PayerName, &t.FromUserID, &t.FromName, &t.ToUserID, &t.ToName, &t.Note)
+		if err != nil {
+			continue
+		}
+		t.Date = date.Format(dateLayout)
+		t.CreatedAt = createdAt.Format(time.RFC3339)
+		t.ConvertedAmount = convertedAmount(t.Type, t.Amount, rate)
+		if t.Kind == "settlement" {
+			t.Title = fmt.Sprintf("%s paid %s", t.FromName, t.ToName)
+		}
+		timeline = append(timeline, t)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(timeline)
+}
@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// How an expense's splits were derived. Everything except exact is recomputed when the amount changes.
+const (
+	splitExact    = "exact"
+	splitEqual    = "equal"
+	splitPercent  = "percent"
+	splitShares   = "shares"
+	splitItemized = "itemized"
+)
+
+// applySplitMode fills in split amounts from the request's split mode.
+// equal splits the amount between the listed users, percent and shares use each split's weight.
+func applySplitMode(req *CreateExpenseRequest) error {
+	if req.SplitMode == "" {
+		req.SplitMode = splitExact
+	}
+	if req.SplitMode == splitExact {
+		for i := range req.Splits {
+			req.Splits[i].Percent, req.Splits[i].Shares = 0, 0
+		}
+		return nil
+	}
+	if req.SplitMode == splitItemized {
+		return errors.New("split_mode itemized requires receipt items")
+	}
+	if req.SplitMode != splitEqual && req.SplitMode != splitPercent && req.SplitMode != splitShares {
+		return fmt.Errorf("unknown split_mode %q", req.SplitMode)
+	}
+	if len(req.Splits) == 0 {
+		return fmt.Errorf("split_mode %s needs at least one split", req.SplitMode)
+	}
+
+	seen := make(map[int]bool)
+	weights := make([]float64, len(req.Splits))
+	var totalWeight float64
+	for i, s := range req.Splits {
+		if seen[s.UserID] {
+			return fmt.Errorf("user %d appears in the splits more than once", s.UserID)
+		}
+		seen[s.UserID] = true
+
+		switch req.SplitMode {
+		case splitEqual:
+			weights[i] = 1
+			req.Splits[i].Percent, req.Splits[i].Shares = 0, 0
+		case splitPercent:
+			if s.Percent <= 0 {
+				return errors.New("Every split needs a positive percent")
+			}
+			weights[i] = s.Percent
+			req.Splits[i].Shares = 0
+		case splitShares:
+			if s.Shares <= 0 {
+				return errors.New("Every split needs a positive number of shares")
+			}
+			weights[i] = s.Shares
+			req.Splits[i].Percent = 0
+		}
+		totalWeight += weights[i]
+	}
+	if req.SplitMode == splitPercent && math.Abs(totalWeight-100) > 0.01 {
+		return errors.New("Split percentages must add up to 100")
+	}
+
+	for i, cents := range distributeCents(toCents(req.Amount), weights) {
+		req.Splits[i].Amount = fromCents(cents)
+	}
+	return nil
+}
+
+// distributeCents splits total proportionally to weights. Leftover cents go to the largest
+// fractional remainders (earlier entries win ties) so the parts always add up to the total.
+func distributeCents(total int64, weights []float64) []int64 {
+	parts := make([]int64, len(weights))
+	var sum float64
+	for _, w := range weights {
+		sum += w
+	}
+	if sum <= 0 {
+		return parts
+	}
+
+	fractions := make([]float64, len(weights))
+	var allocated int64
+	for i, w := range weights {
+		exact := float64(total) * w / sum
+		parts[i] = int64(math.Floor(exact))
+		fractions[i] = exact - math.Floor(exact)
+		allocated += parts[i]
+	}
+
+	order := make([]int, len(weights))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool { return fractions[order[a]] > fractions[order[b]] })
+	for i := 0; allocated < total; i++ {
+		parts[order[i%len(order)]]++
+		allocated++
+	}
+	return parts
+}
+
+// splitWeight is the value stored in expense_splits.weight for a split
+func splitWeight(mode string, s Split) any {
+	switch mode {
+	case splitEqual:
+		return 1
+	case splitPercent:
+		return s.Percent
+	case splitShares:
+		return s.Shares
+	}
+	return nil
+}
@@ -0,0 +1,316 @@
+package handlers
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"money-splitter/pkg/db"
+	"money-splitter/pkg/middleware"
+)
+
+// ExpenseTemplateRequest creates or replaces a template. Expense is stored without an amount:
+// payers' paid_amount are weights (all zero means they pay equally) and the splits must use
+// split_mode equal, percent or shares so they work for any amount.
+type ExpenseTemplateRequest struct {
+	Name    string               `json:"name"`
+	Expense CreateExpenseRequest `json:"expense"`
+}
+
+type ExpenseTemplateResponse struct {
+	ID        int                  `json:"id"`
+	GroupID   int                  `json:"group_id"`
+	Name      string               `json:"name"`
+	Expense   CreateExpenseRequest `json:"expense"`
+	CreatedBy int                  `json:"created_by,omitempty"`
+	UpdatedAt string               `json:"updated_at"`
+}
+
+const templateColumns = `id, group_id, name, template, COALESCE(created_by, 0), updated_at`
+
+func scanTemplate(row rowScanner) (*ExpenseTemplateResponse, error) {
+	var t ExpenseTemplateResponse
+	var template []byte
+	var updatedAt time.Time
+	if err := row.Scan(&t.ID, &t.GroupID, &t.Name, &template, &t.CreatedBy, &updatedAt); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(template, &t.Expense); err != nil {
+		return nil, fmt.Errorf("decoding template: %w", err)
+	}
+	t.UpdatedAt = updatedAt.Format(time.RFC3339)
+	return &t, nil
+}
+
+// validate checks the template would make a valid expense and normalizes what is stored.
+// selfID is the template being replaced (0 when creating).
+func (req *ExpenseTemplateRequest) validate(groupID any, selfID int) error {
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len(req.Name) > 100 {
+		return errors.New("Template name must be 1-100 characters")
+	}
+	var taken bool
+	err := db.DB.QueryRow(`SELECT EXISTS (SELECT 1 FROM expense_templates WHERE group_id = $1 AND LOWER(name) = LOWER($2) AND id <> $3)`,
+		groupID, req.Name, selfID).Scan(&taken)
+	if err != nil {
+		return err
+	}
+	if taken {
+		return fmt.Errorf("Template %q already exists", req.Name)
+	}
+
+	exp := &req.Expense
+	switch {
+	case exp.Type == typeRefund:
+		return errors.New("Refunds can't be templates, create them from the expense")
+	case exp.Claiming || len(exp.Items) > 0:
+		return errors.New("Templates can't use items or claiming")
+	case len(exp.Payers) == 0:
+		return errors.New("A template needs at least one payer")
+	case len(exp.Splits) == 0:
+		return errors.New("A template needs splits")
+	}
+	if exp.SplitMode != splitEqual && exp.SplitMode != splitPercent && exp.SplitMode != splitShares {
+		return errors.New("Template splits must use split_mode equal, percent or shares")
+	}
+	for _, p := range exp.Payers {
+		if p.PaidAmount < 0 {
+			return errors.New("Payer weights can't be negative")
+		}
+	}
+	exp.Amount = 0
+	exp.ExchangeRate = 0
+	exp.Date, exp.Time = "", ""
+
+	// Dry run with a sample amount catches bad weights and unknown types up front
+	sample := *exp
+	sample.Amount = 100
+	sample.Payers = templatePayers(exp.Payers, sample.Amount)
+	sample.Splits = append([]Split(nil), exp.Splits...)
+	if err := prepareExpense(&sample); err != nil {
+		return err
+	}
+	if sample.Timezone != "" {
+		if _, err := time.LoadLocation(sample.Timezone); err != nil {
+			return errors.New("Unknown timezone")
+		}
+	}
+
+	if exp.Category != "" {
+		category, err := resolveCategory(db.DB, groupID, exp.Category)
+		if err != nil {
+			return err
+		}
+		exp.Category = category
+	}
+	if exp.Currency != "" {
+		currency, ok := normalizeCurrency(exp.Currency)
+		if !ok {
+			return errors.New("Unknown currency")
+		}
+		exp.Currency = currency
+	}
+	for i := range exp.Splits {
+		exp.Splits[i].Amount = 0
+	}
+	return nil
+}
+
+// templatePayers spreads the amount over the template's payers by their weights,
+// or equally when none are given
+func templatePayers(payers []PayerSplit, amount float64) []PayerSplit {
+	weighted := make([]PayerSplit, len(payers))
+	total := 0.0
+	for i, p := range payers {
+		weighted[i] = p
+		total += p.PaidAmount
+	}
+	if total == 0 {
+		for i := range weighted {
+			weighted[i].PaidAmount = 1
+		}
+	}
+	return scalePayers(weighted, amount)
+}
+
+// --- HANDLERS ---
+
+func CreateExpenseTemplate(w http.ResponseWriter, r *http.Request) {
+	groupID := r.PathValue("id")
+	userID := r.Context().Value(middleware.UserIDKey).(int)
+
+	if !isGroupMember(groupID, userID) {
+		http.Error(w, "Not a member of this group", http.StatusForbidden)
+		return
+	}
+
+	var req ExpenseTemplateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	if err := req.validate(groupID, 0); err != nil {
+		if errors.Is(err, errUnknownCategory) {
+			http.Error(w, err.Error()+", create it first", http.StatusBadRequest)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	template, _ := json.Marshal(req.Expense)
+	t, err := scanTemplate(db.DB.QueryRow(`
+		INSERT INTO expense_templates (group_id, name, template, created_by)
+		VALUES ($1, $2, $3, $4)
+		RETURNING `+templateColumns, groupID, req.Name, string(template), userID))
+	if err != nil {
+		fmt.Println("Error creating template:", err)
+		http.Error(w, "Failed to create template", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(t)
+}
+
+func GetExpenseTemplates(w http.ResponseWriter, r *http.Request) {
+	groupID := r.PathValue("id")
+	userID := r.Context().Value(middleware.UserIDKey).(int)
+
+	if !isGroupMember(groupID, userID) {
+		http.Error(w, "Not a member of this group", http.StatusForbidden)
+		return
+	}
+
+	rows, err := db.DB.Query(`SELECT `+templateColumns+` FROM expense_templates WHERE group_id = $1 ORDER BY LOWER(name)`, groupID)
+	if err != nil {
+		http.Error(w, "Failed to fetch templates", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	list := []ExpenseTemplateResponse{}
+	for rows.Next() {
+		t, err := scanTemplate(rows)
+		if err != nil {
+			continue
+		}
+		list = append(list, *t)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// UpdateExpenseTemplate replaces a template; expenses created from it are not touched
+func UpdateExpenseTemplate(w http.ResponseWriter, r *http.Request) {
+	current, ok := loadTemplateForMember(w, r)
+	if !ok {
+		return
+	}
+
+	var req ExpenseTemplateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	if err := req.validate(current.GroupID, current.ID); err != nil {
+		if errors.Is(err, errUnknownCategory) {
+			http.Error(w, err.Error()+", create it first", http.StatusBadRequest)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	template, _ := json.Marshal(req.Expense)
+	t, err := scanTemplate(db.DB.QueryRow(`
+		UPDATE expense_templates SET name = $1, template = $2, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $3
+		RETURNING `+templateColumns, req.Name, string(template), current.ID))
+	if err != nil {
+		fmt.Println("Error updating template:", err)
+		http.Error(w, "Failed to update template", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(t)
+}
+
+func DeleteExpenseTemplate(w http.ResponseWriter, r *http.Request) {
+	t, ok := loadTemplateForMember(w, r)
+	if !ok {
+		return
+	}
+
+	if _, err := db.DB.Exec(`DELETE FROM expense_templates WHERE id = $1`, t.ID); err != nil {
+		http.Error(w, "Failed to delete template", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Template deleted"})
+}
+
+// CreateExpenseFromTemplate adds an expense from a template. The body needs only an amount;
+// any other CreateExpenseRequest field overrides the template (merge patch semantics).
+// Payers are scaled to the amount unless the body brings its own.
+func CreateExpenseFromTemplate(w http.ResponseWriter, r *http.Request) {
+	t, ok := loadTemplateForMember(w, r)
+	if !ok {
+		return
+	}
+	userID := r.Context().Value(middleware.UserIDKey).(int)
+
+	var overrides map[string]any
+	if err := json.NewDecoder(r.Body).Decode(&overrides); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	if _, ok := overrides["amount"]; !ok {
+		http.Error(w, "amount is required", http.StatusBadRequest)
+		return
+	}
+
+	merged, _ := json.Marshal(mergePatch(snapshotFields(&t.Expense), overrides))
+	dec := json.NewDecoder(bytes.NewReader(merged))
+	dec.DisallowUnknownFields()
+	var req CreateExpenseRequest
+	if err := dec.Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid request: %v", err), http.StatusBadRequest)
+		return
+	}
+	if _, ok := overrides["payers"]; !ok {
+		req.Payers = templatePayers(req.Payers, req.Amount)
+	}
+
+	createExpense(w, r, t.GroupID, userID, &req)
+}
+
+// loadTemplateForMember fetches /templates/{id} and checks the caller belongs to its group
+func loadTemplateForMember(w http.ResponseWriter, r *http.Request) (*ExpenseTemplateResponse, bool) {
+	userID := r.Context().Value(middleware.UserIDKey).(int)
+
+	t, err := scanTemplate(db.DB.QueryRow(`SELECT `+templateColumns+` FROM expense_templates WHERE id = $1`, r.PathValue("id")))
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "Template not found", http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		fmt.Println("Error loading template:", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return nil, false
+	}
+	if !isGroupMember(t.GroupID, userID) {
+		http.Error(w, "Not a member of this group", http.StatusForbidden)
+		return nil, false
+	}
+	return t, true
+}
@@ -0,0 +1,231 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"money-splitter/pkg/db"
+	"money-splitter/pkg/middleware"
+
+	"github.com/lib/pq"
+)
+
+// Trashed expenses are kept this long before being purged for good
+const defaultTrashRetentionDays = 30
+
+type TrashedExpenseResponse struct {
+	ID            int     `json:"id"`
+	Title         string  `json:"title"`
+	Amount        float64 `json:"amount"`
+	Currency      string  `json:"currency"`
+	Category      string  `json:"category"`
+	DeletedAt     string  `json:"deleted_at"`
+	DeletedBy     int     `json:"deleted_by"`
+	DeletedByName string  `json:"deleted_by_name"`
+	PurgeAt       string  `json:"purge_at"`
+}
+
+// trashRetention reads TRASH_RETENTION_DAYS, falling back to the default
+func trashRetention() time.Duration {
+	days, err := strconv.Atoi(os.Getenv("TRASH_RETENTION_DAYS"))
+	if err != nil || days <= 0 {
+		days = defaultTrashRetentionDays
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// GetGroupTrash lists the group's deleted expenses that can still be restored
+func GetGroupTrash(w http.ResponseWriter, r *http.Request) {
+	groupID := r.PathValue("id")
+	userID := r.Context().Value(middleware.UserIDKey).(int)
+
+	if !isGroupMember(groupID, userID) {
+		http.Error(w, "Not a member of this group", http.StatusForbidden)
+		return
+	}
+
+	rows, err := db.DB.Query(`
+		SELECT e.id, e.title, e.amount, e.currency, COALESCE(e.category, ''), e.deleted_at,
+		       COALESCE(e.deleted_by, 0), COALESCE(u.name, '')
+		FROM expenses e
+		LEFT JOIN users u ON e.deleted_by = u.id
+		WHERE e.group_id = $1 AND e.deleted_at IS NOT NULL
+		ORDER BY e.deleted_at DESC`, groupID)
+	if err != nil {
+		fmt.Println("Error fetching trash:", err)
+		http.Error(w, "Failed to fetch trash", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	retention := trashRetention()
+	trash := []TrashedExpenseResponse{}
+	for rows.Next() {
+		var e TrashedExpenseResponse
+		var deletedAt time.Time
+		if err := rows.Scan(&e.ID, &e.Title, &e.Amount, &e.Currency, &e.Category, &deletedAt, &e.DeletedBy, &e.DeletedByName); err != nil {
+			continue
+		}
+		e.Amount = math.Round(e.Amount*100) / 100
+		e.DeletedAt = deletedAt.Format(time.RFC3339)
+		e.PurgeAt = deletedAt.Add(retention).Format(time.RFC3339)
+		trash = append(trash, e)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(trash)
+}
+
+// RestoreExpense takes an expense back out of the trash
+func RestoreExpense(w http.ResponseWriter, r *http.Request) {
+	expenseID := r.PathValue("id")
+	userID := r.Context().Value(middleware.UserIDKey).(int)
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	var groupID int
+	var refund CreateExpenseRequest
+	var refundOf sql.NullInt64
+	err = tx.QueryRow(`SELECT group_id, type, refund_of, amount, exchange_rate FROM expenses WHERE id = $1 AND deleted_at IS NOT NULL`, expenseID).
+		Scan(&groupID, &refund.Type, &refundOf, &refund.Amount, &refund.ExchangeRate)
+	if err != nil {
+		http.Error(w, "Expense not found in trash", http.StatusNotFound)
+		return
+	}
+	if !isGroupMember(groupID, userID) {
+		http.Error(w, "Not a member of this group", http.StatusForbidden)
+		return
+	}
+	// Other refunds may have taken what this one returned while it was in the trash. The original
+	// is locked first, the same order as when saving a refund.
+	refund.RefundOf = int(refundOf.Int64)
+	switch err := lockRefundLimit(tx, &refund, expenseID); {
+	case errors.Is(err, errRefundOriginalGone):
+		http.Error(w, "The refunded expense is gone; restore it first", http.StatusConflict)
+		return
+	case isRefundRejected(err):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		fmt.Println("Error checking refund limit:", err)
+		http.Error(w, "Failed to restore expense", http.StatusInternalServerError)
+		return
+	}
+
+	// Lock the row so a concurrent purge can't remove it halfway through
+	err = tx.QueryRow(`SELECT group_id FROM expenses WHERE id = $1 AND deleted_at IS NOT NULL FOR UPDATE`, expenseID).Scan(&groupID)
+	if err != nil {
+		http.Error(w, "Expense not found in trash", http.StatusNotFound)
+		return
+	}
+
+	if _, err := tx.Exec(`UPDATE expenses SET deleted_at = NULL, deleted_by = NULL, version = version + 1 WHERE id = $1`, expenseID); err != nil {
+		http.Error(w, "Failed to restore expense", http.StatusInternalServerError)
+		return
+	}
+
+	after, err := loadExpenseSnapshot(tx, expenseID, false)
+	if err == nil {
+		err = recordRevision(tx, expenseID, revisionRestore, userID, nil, after)
+	}
+	if err != nil {
+		fmt.Println("Error recording revision:", err)
+		http.Error(w, "Failed to restore expense", http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Expense restored"})
+}
+
+// StartTrashPurger permanently removes expenses that have been in the trash longer than the retention period
+func StartTrashPurger(ctx context.Context, every time.Duration) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+
+	for {
+		if purged, err := purgeTrash(time.Now().Add(-trashRetention())); err != nil {
+			fmt.Println("Trash purge error:", err)
+		} else if purged > 0 {
+			fmt.Printf("Purged %d expenses from the trash\n", purged)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// purgeTrash hard-deletes expenses trashed before the cutoff, along with their stored receipt files.
+// Rows locked by a concurrent restore (or another instance) are skipped until the next run.
+func purgeTrash(cutoff time.Time) (int, error) {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var ids pq.Int64Array
+	err = tx.QueryRow(`
+		SELECT COALESCE(ARRAY_AGG(id), '{}') FROM (
+			SELECT id FROM expenses
+			WHERE deleted_at IS NOT NULL AND deleted_at < $1
+			FOR UPDATE SKIP LOCKED
+		) expired`, cutoff).Scan(&ids)
+	if err != nil || len(ids) == 0 {
+		return 0, err
+	}
+
+	// Collect blob keys before the cascade drops the attachment rows
+	type blob struct {
+		key          string
+		thumbnailKey *string
+	}
+	var blobs []blob
+	rows, err := tx.Query(`SELECT storage_key, thumbnail_key FROM expense_attachments WHERE expense_id = ANY($1)`, ids)
+	if err != nil {
+		return 0, err
+	}
+	for rows.Next() {
+		var b blob
+		if err := rows.Scan(&b.key, &b.thumbnailKey); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		blobs = append(blobs, b)
+	}
+	rows.Close()
+
+	if _, err := tx.Exec(`DELETE FROM expenses WHERE id = ANY($1)`, ids); err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	// Files go only once the rows are gone for good; a failure here just leaves an orphan blob
+	for _, b := range blobs {
+		deleteAttachmentBlobs(b.key, b.thumbnailKey)
+	}
+	return len(ids), nil
+}
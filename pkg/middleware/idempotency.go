@@ -0,0 +1,190 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"money-splitter/pkg/db"
+)
+
+const (
+	IdempotencyHeader = "Idempotency-Key"
+
+	defaultIdempotencyTTL = 24 * time.Hour
+	maxIdempotentBody     = 1 << 20 // 1 MB
+	maxIdempotencyKeyLen  = 255
+)
+
+// idempotencyTTL reads IDEMPOTENCY_TTL_HOURS, falling back to a day
+func idempotencyTTL() time.Duration {
+	hours, err := strconv.Atoi(os.Getenv("IDEMPOTENCY_TTL_HOURS"))
+	if err != nil || hours <= 0 {
+		return defaultIdempotencyTTL
+	}
+	return time.Duration(hours) * time.Hour
+}
+
+// Idempotency makes a write endpoint safe to retry. The first response for a user's
+// Idempotency-Key is stored, with its Content-Type, ETag and Location, and replayed for retries
+// with the same method, URL (query included) and body; reusing the key for a different request
+// is rejected. Must run inside AuthMiddleware.
+func Idempotency(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(IdempotencyHeader)
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLen {
+			http.Error(w, "Idempotency-Key is too long", http.StatusBadRequest)
+			return
+		}
+		userID := r.Context().Value(UserIDKey).(int)
+
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxIdempotentBody+1))
+		if err != nil || len(body) > maxIdempotentBody {
+			http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		hash := sha256.New()
+		fmt.Fprintf(hash, "%s %s\n", r.Method, r.URL.RequestURI())
+		hash.Write(body)
+		requestHash := hex.EncodeToString(hash.Sum(nil))
+
+		// Claim the key; an expired entry is taken over as if it never existed
+		var claimed bool
+		err = db.DB.QueryRow(`
+			INSERT INTO idempotency_keys (user_id, key, request_hash, expires_at)
+			VALUES ($1, $2, $3, $4)
+			ON CONFLICT (user_id, key) DO UPDATE
+			SET request_hash = EXCLUDED.request_hash, status_code = NULL, content_type = NULL, response_body = NULL, etag = NULL, location = NULL,
+			    created_at = CURRENT_TIMESTAMP, expires_at = EXCLUDED.expires_at
+			WHERE idempotency_keys.expires_at < CURRENT_TIMESTAMP
+			RETURNING TRUE`, userID, key, requestHash, time.Now().Add(idempotencyTTL())).Scan(&claimed)
+		if errors.Is(err, sql.ErrNoRows) {
+			replayIdempotent(w, userID, key, requestHash)
+			return
+		}
+		if err != nil {
+			fmt.Println("Error claiming idempotency key:", err)
+			http.Error(w, "Server error", http.StatusInternalServerError)
+			return
+		}
+
+		rec := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+		completed := false
+		defer func() {
+			// Server errors, conflicts and panics release the key so the client can simply retry
+			if !completed || !storeIdempotent(rec.status) {
+				db.DB.Exec(`DELETE FROM idempotency_keys WHERE user_id = $1 AND key = $2`, userID, key)
+			}
+		}()
+
+		next.ServeHTTP(rec, r)
+		completed = true
+
+		if storeIdempotent(rec.status) {
+			_, err := db.DB.Exec(`
+				UPDATE idempotency_keys SET status_code = $3, content_type = $4, response_body = $5, etag = $6, location = $7
+				WHERE user_id = $1 AND key = $2`, userID, key, rec.status, rec.Header().Get("Content-Type"), rec.body.Bytes(),
+				rec.Header().Get("ETag"), rec.Header().Get("Location"))
+			if err != nil {
+				fmt.Println("Error storing idempotent response:", err)
+			}
+		}
+	}
+}
+
+// storeIdempotent decides which responses are replayed. Conflicts are not: a 409 (such as a
+// likely-duplicate warning) asks the client to change something and send the request again.
+func storeIdempotent(status int) bool {
+	return status < 500 && status != http.StatusConflict
+}
+
+// replayIdempotent answers a retry from the stored response
+func replayIdempotent(w http.ResponseWriter, userID int, key, requestHash string) {
+	var storedHash string
+	var status sql.NullInt64
+	var contentType, etag, location sql.NullString
+	var body []byte
+	err := db.DB.QueryRow(`
+		SELECT request_hash, status_code, content_type, response_body, etag, location
+		FROM idempotency_keys
+		WHERE user_id = $1 AND key = $2`, userID, key).Scan(&storedHash, &status, &contentType, &body, &etag, &location)
+	if err != nil {
+		// Released by a failed first attempt in the meantime
+		http.Error(w, "Idempotency-Key was released, retry the request", http.StatusConflict)
+		return
+	}
+
+	if storedHash != requestHash {
+		http.Error(w, "Idempotency-Key was already used for a different request", http.StatusUnprocessableEntity)
+		return
+	}
+	if !status.Valid {
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, "A request with this Idempotency-Key is still in progress", http.StatusConflict)
+		return
+	}
+
+	for name, value := range map[string]sql.NullString{"Content-Type": contentType, "ETag": etag, "Location": location} {
+		if value.Valid && value.String != "" {
+			w.Header().Set(name, value.String)
+		}
+	}
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.WriteHeader(int(status.Int64))
+	w.Write(body)
+}
+
+// responseRecorder passes the response through while keeping a copy for replays
+type responseRecorder struct {
+	http.ResponseWriter
+	status      int
+	body        bytes.Buffer
+	wroteHeader bool
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.status = status
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(p []byte) (int, error) {
+	r.wroteHeader = true
+	r.body.Write(p)
+	return r.ResponseWriter.Write(p)
+}
+
+// StartIdempotencyPurger deletes expired keys so the table doesn't grow forever
+func StartIdempotencyPurger(ctx context.Context, every time.Duration) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+
+	for {
+		if _, err := db.DB.Exec(`DELETE FROM idempotency_keys WHERE expires_at < CURRENT_TIMESTAMP`); err != nil {
+			fmt.Println("Idempotency purge error:", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package rates
+
+import (
+	"archive/zip"
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const sourceECB = "ecb"
+
+// ECB reference files quote every currency against EUR.
+// The historical CSV uses ISO dates, the daily CSV spells the month out.
+var ecbDateLayouts = []string{"2006-01-02", "02 January 2006", "2 January 2006"}
+
+// ParseECBCSV reads eurofxref.csv / eurofxref-hist.csv style files:
+// a "Date,USD,JPY,..." header followed by one row per day, with N/A for missing quotes.
+func ParseECBCSV(r io.Reader) ([]Rate, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("reading header: %w", err)
+	}
+	if len(header) == 0 || !strings.EqualFold(strings.TrimSpace(header[0]), "Date") {
+		return nil, fmt.Errorf("not an ECB rates file: first column must be Date")
+	}
+
+	var rates []Rate
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(record) == 0 || strings.TrimSpace(record[0]) == "" {
+			continue
+		}
+
+		day, err := parseECBDate(record[0])
+		if err != nil {
+			return nil, err
+		}
+
+		for i := 1; i < len(record) && i < len(header); i++ {
+			currency := strings.TrimSpace(header[i])
+			value := strings.TrimSpace(record[i])
+			if currency == "" || value == "" || value == "N/A" {
+				continue
+			}
+			rate, err := strconv.ParseFloat(value, 64)
+			if err != nil || rate <= 0 {
+				return nil, fmt.Errorf("bad rate %q for %s on %s", value, currency, record[0])
+			}
+			rates = append(rates, Rate{Date: day, Base: pivotCurrency, Quote: currency, Value: rate, Source: sourceECB})
+		}
+	}
+	return rates, nil
+}
+
+// ParseECBXML reads eurofxref-daily.xml / eurofxref-hist.xml style files
+func ParseECBXML(r io.Reader) ([]Rate, error) {
+	var envelope struct {
+		Days []struct {
+			Time  string `xml:"time,attr"`
+			Rates []struct {
+				Currency string  `xml:"currency,attr"`
+				Rate     float64 `xml:"rate,attr"`
+			} `xml:"Cube"`
+		} `xml:"Cube>Cube"`
+	}
+	if err := xml.NewDecoder(r).Decode(&envelope); err != nil {
+		return nil, err
+	}
+
+	var rates []Rate
+	for _, d := range envelope.Days {
+		day, err := parseECBDate(d.Time)
+		if err != nil {
+			return nil, err
+		}
+		for _, q := range d.Rates {
+			if q.Rate <= 0 {
+				return nil, fmt.Errorf("bad rate %v for %s on %s", q.Rate, q.Currency, d.Time)
+			}
+			rates = append(rates, Rate{Date: day, Base: pivotCurrency, Quote: q.Currency, Value: q.Rate, Source: sourceECB})
+		}
+	}
+	return rates, nil
+}
+
+// ImportFile parses a rate file from disk (.csv, .xml, or a .zip holding either) and stores it
+func ImportFile(p *DBProvider, path string) (int, error) {
+	var rates []Rate
+	var err error
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".zip":
+		rates, err = parseZip(path)
+	default:
+		var f *os.File
+		f, err = os.Open(path)
+		if err != nil {
+			return 0, err
+		}
+		defer f.Close()
+		rates, err = parseByName(path, f)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", path, err)
+	}
+
+	if err := p.Save(rates); err != nil {
+		return 0, err
+	}
+	return len(rates), nil
+}
+
+func parseZip(path string) ([]Rate, error) {
+	archive, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, err
+	}
+	defer archive.Close()
+
+	var rates []Rate
+	for _, file := range archive.File {
+		rc, err := file.Open()
+		if err != nil {
+			return nil, err
+		}
+		parsed, err := parseByName(file.Name, rc)
+		rc.Close()
+		if err != nil {
+			return nil, err
+		}
+		rates = append(rates, parsed...)
+	}
+	return rates, nil
+}
+
+func parseByName(name string, r io.Reader) ([]Rate, error) {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".csv":
+		return ParseECBCSV(r)
+	case ".xml":
+		return ParseECBXML(r)
+	default:
+		return nil, fmt.Errorf("unsupported rate file type %q", filepath.Ext(name))
+	}
+}
+
+func parseECBDate(value string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	for _, layout := range ecbDateLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unrecognised date %q", value)
+}
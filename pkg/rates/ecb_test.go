@@ -0,0 +1,118 @@
+package rates
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseECBCSV(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  []string // "date quote rate"
+		err   string
+	}{
+		{"daily file", "Date, USD, JPY, \n17 October 2024, 1.0866, 162.51, \n",
+			[]string{"2024-10-17 USD 1.0866", "2024-10-17 JPY 162.51"}, ""},
+		{"history with N/A and blank lines", "Date,USD,CYP\n2024-10-17,1.0866,N/A\n\n2007-12-31,1.4721,0.585274\n",
+			[]string{"2024-10-17 USD 1.0866", "2007-12-31 USD 1.4721", "2007-12-31 CYP 0.585274"}, ""},
+		{"empty cell", "Date,USD,GBP\n2024-10-17,,0.8345\n", []string{"2024-10-17 GBP 0.8345"}, ""},
+		{"short row", "Date,USD,GBP,JPY\n2024-10-17,1.0866\n", []string{"2024-10-17 USD 1.0866"}, ""},
+		{"row longer than the header", "Date,USD\n2024-10-17,1.0866,9.99\n", []string{"2024-10-17 USD 1.0866"}, ""},
+		{"bad number", "Date,USD\n2024-10-17,1.08.66\n", nil, `bad rate "1.08.66" for USD`},
+		{"not a number", "Date,USD\n2024-10-17,abc\n", nil, `bad rate "abc"`},
+		{"zero rate", "Date,USD\n2024-10-17,0\n", nil, "bad rate"},
+		{"negative rate", "Date,USD\n2024-10-17,-1.08\n", nil, "bad rate"},
+		{"bad date", "Date,USD\n17/10/2024,1.08\n", nil, "unrecognised date"},
+		{"wrong header", "Day,USD\n2024-10-17,1.08\n", nil, "first column must be Date"},
+		{"empty file", "", nil, "reading header"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rates, err := ParseECBCSV(strings.NewReader(tt.input))
+			checkParsed(t, rates, err, tt.want, tt.err)
+		})
+	}
+}
+
+func TestParseECBXML(t *testing.T) {
+	const header = `<?xml version="1.0" encoding="UTF-8"?>
+<gesmes:Envelope xmlns:gesmes="http://www.gesmes.org/xml/2002-08-01" xmlns="http://www.ecb.int/vocabulary/2002-08-01/eurofxref">
+	<gesmes:subject>Reference rates</gesmes:subject>
+	<Cube>`
+	const footer = `</Cube></gesmes:Envelope>`
+
+	tests := []struct {
+		name  string
+		input string
+		want  []string
+		err   string
+	}{
+		{"daily", header + `<Cube time="2024-10-17"><Cube currency="USD" rate="1.0866"/><Cube currency="JPY" rate="162.51"/></Cube>` + footer,
+			[]string{"2024-10-17 USD 1.0866", "2024-10-17 JPY 162.51"}, ""},
+		{"history", header + `<Cube time="2024-10-17"><Cube currency="USD" rate="1.0866"/></Cube>
+			<Cube time="2024-10-16"><Cube currency="USD" rate="1.0882"/></Cube>` + footer,
+			[]string{"2024-10-17 USD 1.0866", "2024-10-16 USD 1.0882"}, ""},
+		{"no days", header + footer, nil, ""},
+		{"zero rate", header + `<Cube time="2024-10-17"><Cube currency="USD" rate="0"/></Cube>` + footer, nil, "bad rate"},
+		{"negative rate", header + `<Cube time="2024-10-17"><Cube currency="USD" rate="-1.2"/></Cube>` + footer, nil, "bad rate"},
+		{"not a number", header + `<Cube time="2024-10-17"><Cube currency="USD" rate="1,08"/></Cube>` + footer, nil, "1,08"},
+		{"bad date", header + `<Cube time="17.10.2024"><Cube currency="USD" rate="1.08"/></Cube>` + footer, nil, "unrecognised date"},
+		{"truncated", header + `<Cube time="2024-10-17">`, nil, "EOF"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rates, err := ParseECBXML(strings.NewReader(tt.input))
+			checkParsed(t, rates, err, tt.want, tt.err)
+		})
+	}
+}
+
+func TestParseECBDate(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+		ok    bool
+	}{
+		{"2024-10-17", "2024-10-17", true},
+		{" 2024-10-17 ", "2024-10-17", true},
+		{"17 October 2024", "2024-10-17", true},
+		{"7 October 2024", "2024-10-07", true},
+		{"07 October 2024", "2024-10-07", true},
+		{"17 Oct 2024", "", false},
+		{"10/17/2024", "", false},
+		{"2024-02-30", "", false},
+		{"", "", false},
+	}
+	for _, tt := range tests {
+		got, err := parseECBDate(tt.input)
+		if (err == nil) != tt.ok || (tt.ok && got.Format("2006-01-02") != tt.want) {
+			t.Errorf("parseECBDate(%q) = %v, %v; want %q", tt.input, got, err, tt.want)
+		}
+	}
+}
+
+func checkParsed(t *testing.T, rates []Rate, err error, want []string, wantErr string) {
+	t.Helper()
+	if wantErr != "" {
+		if err == nil || !strings.Contains(err.Error(), wantErr) {
+			t.Fatalf("err = %v, want it to mention %q", err, wantErr)
+		}
+		return
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, r := range rates {
+		if r.Base != pivotCurrency || r.Source != sourceECB || r.Date.Location() != time.UTC {
+			t.Errorf("rate %+v isn't an ECB rate against EUR", r)
+		}
+		got = append(got, r.Date.Format("2006-01-02")+" "+r.Quote+" "+strconv.FormatFloat(r.Value, 'g', -1, 64))
+	}
+	if strings.Join(got, ", ") != strings.Join(want, ", ") {
+		t.Fatalf("rates = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,131 @@
+package rates
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// ErrRateNotFound is returned when no stored rate covers the requested pair and date
+var ErrRateNotFound = errors.New("exchange rate not found")
+
+// pivotCurrency is the base of the ECB reference rates; pairs without a direct rate are crossed through it
+const pivotCurrency = "EUR"
+
+// maxRateAgeDays bounds how far back a stored rate still counts for a day. ECB rates skip
+// weekends and holidays, so a few days is normal; an old manual rate must not beat current ones.
+const maxRateAgeDays = 7
+
+// ExchangeRateProvider resolves how many units of quote one unit of base buys on a given day
+type ExchangeRateProvider interface {
+	Rate(base, quote string, on time.Time) (float64, error)
+}
+
+// Rate is a single stored quote
+type Rate struct {
+	Date   time.Time
+	Base   string
+	Quote  string
+	Value  float64
+	Source string
+}
+
+// DBProvider serves rates from the exchange_rates table, so lookups never leave the database
+type DBProvider struct {
+	DB *sql.DB
+}
+
+func NewDBProvider(conn *sql.DB) *DBProvider {
+	return &DBProvider{DB: conn}
+}
+
+// Rate picks the most recent rate on or before the given day, at most maxRateAgeDays old.
+// Direct quotes win over inverted ones, which win over crossing through EUR.
+func (p *DBProvider) Rate(base, quote string, on time.Time) (float64, error) {
+	return resolveRate(p.lookup, base, quote, on)
+}
+
+// resolveRate applies the direct, inverse, cross order on top of a lookup of single stored quotes
+func resolveRate(lookup func(base, quote string, on time.Time) (float64, error), base, quote string, on time.Time) (float64, error) {
+	if base == quote {
+		return 1, nil
+	}
+
+	// 1. Direct quote
+	rate, err := lookup(base, quote, on)
+	if !errors.Is(err, ErrRateNotFound) {
+		return rate, err
+	}
+
+	// 2. Inverse quote
+	rate, err = lookup(quote, base, on)
+	if err == nil {
+		return 1 / rate, nil
+	}
+	if !errors.Is(err, ErrRateNotFound) {
+		return 0, err
+	}
+
+	// 3. Cross rate through the pivot currency
+	if base == pivotCurrency || quote == pivotCurrency {
+		return 0, ErrRateNotFound
+	}
+	toBase, err := resolveRate(lookup, pivotCurrency, base, on)
+	if err != nil {
+		return 0, err
+	}
+	toQuote, err := resolveRate(lookup, pivotCurrency, quote, on)
+	if err != nil {
+		return 0, err
+	}
+	return toQuote / toBase, nil
+}
+
+func (p *DBProvider) lookup(base, quote string, on time.Time) (float64, error) {
+	// Manual overrides beat imported rates for the same day
+	query := `
+		SELECT rate FROM exchange_rates
+		WHERE base_currency = $1 AND quote_currency = $2 AND rate_date <= $3 AND rate_date >= $4
+		ORDER BY rate_date DESC, (source = 'manual') DESC
+		LIMIT 1`
+
+	var rate float64
+	oldest := on.AddDate(0, 0, -maxRateAgeDays)
+	err := p.DB.QueryRow(query, base, quote, on.Format("2006-01-02"), oldest.Format("2006-01-02")).Scan(&rate)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, ErrRateNotFound
+	}
+	if err != nil {
+		return 0, err
+	}
+	// A zero or negative rate can't convert anything (and would invert to +Inf)
+	if rate <= 0 {
+		return 0, ErrRateNotFound
+	}
+	return rate, nil
+}
+
+// Save upserts rates in a single transaction
+func (p *DBProvider) Save(rates []Rate) error {
+	tx, err := p.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`
+		INSERT INTO exchange_rates (rate_date, base_currency, quote_currency, rate, source)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (rate_date, base_currency, quote_currency, source) DO UPDATE SET rate = EXCLUDED.rate`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, r := range rates {
+		if _, err := stmt.Exec(r.Date.Format("2006-01-02"), r.Base, r.Quote, r.Value, r.Source); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,63 @@
+package rates
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+)
+
+// fakeRates stands in for DBProvider.lookup: stored quotes keyed "BASE/QUOTE"
+type fakeRates map[string]float64
+
+func (f fakeRates) lookup(base, quote string, _ time.Time) (float64, error) {
+	if rate, ok := f[base+"/"+quote]; ok {
+		return rate, nil
+	}
+	return 0, ErrRateNotFound
+}
+
+func TestResolveRate(t *testing.T) {
+	stored := fakeRates{
+		"EUR/USD": 1.25,
+		"EUR/GBP": 0.8,
+		"EUR/JPY": 160,
+		"USD/CHF": 0.9,
+		"CHF/USD": 1.5, // an inconsistent inverse must not beat the direct quote
+		"GBP/SEK": 12,
+	}
+	tests := []struct {
+		base, quote string
+		want        float64
+		err         error
+	}{
+		{"USD", "USD", 1, nil},
+		{"EUR", "USD", 1.25, nil},     // direct
+		{"USD", "EUR", 0.8, nil},      // inverse
+		{"USD", "CHF", 0.9, nil},      // direct beats inverse
+		{"SEK", "GBP", 1.0 / 12, nil}, // inverse beats crossing
+		{"USD", "GBP", 0.64, nil},     // crossed: 0.8 / 1.25
+		{"GBP", "JPY", 200, nil},      // crossed: 160 / 0.8
+		{"EUR", "CAD", 0, ErrRateNotFound},
+		{"CAD", "EUR", 0, ErrRateNotFound},
+		{"USD", "CAD", 0, ErrRateNotFound},
+	}
+	for _, tt := range tests {
+		got, err := resolveRate(stored.lookup, tt.base, tt.quote, time.Now())
+		if !errors.Is(err, tt.err) || math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("%s->%s = %v, %v; want %v, %v", tt.base, tt.quote, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+func TestResolveRateStopsOnLookupErrors(t *testing.T) {
+	broken := errors.New("connection refused")
+	calls := 0
+	lookup := func(base, quote string, _ time.Time) (float64, error) {
+		calls++
+		return 0, broken
+	}
+	if _, err := resolveRate(lookup, "USD", "GBP", time.Now()); !errors.Is(err, broken) || calls != 1 {
+		t.Fatalf("err = %v after %d lookups, want the lookup error right away", err, calls)
+	}
+}